@@ -7,10 +7,10 @@ require (
 	github.com/gorilla/mux v1.8.1
 	github.com/joho/godotenv v1.5.1
 	github.com/likexian/whois v1.15.5
+	github.com/mailersend/mailersend-go v1.5.1
+	golang.org/x/net v0.28.0
+	golang.org/x/text v0.17.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
-require (
-	github.com/google/go-querystring v1.1.0 // indirect
-	github.com/mailersend/mailersend-go v1.5.1 // indirect
-	golang.org/x/net v0.28.0 // indirect
-)
+require github.com/google/go-querystring v1.1.0 // indirect
@@ -0,0 +1,64 @@
+// Package httpx holds the HTTP helpers shared by the middlewares of the server
+package httpx
+
+import "net/http"
+
+// Recorder records the status and the size of a response for the middlewares that
+// report on it once the handler returns
+type Recorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+// NewRecorder wraps w in a Recorder
+func NewRecorder(w http.ResponseWriter) *Recorder {
+	return &Recorder{ResponseWriter: w}
+}
+
+// Status returns the status of the response. A handler that wrote nothing answered 200.
+func (w *Recorder) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// Bytes returns the size of the response body written so far
+func (w *Recorder) Bytes() int {
+	return w.bytes
+}
+
+// WriteHeader records the status of the response
+func (w *Recorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write records the implicit 200 of a response written without WriteHeader and the size
+// of the body
+func (w *Recorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+// Flush lets streaming handlers flush through the recorder
+func (w *Recorder) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		flusher.Flush()
+	}
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer
+func (w *Recorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,77 @@
+package httpx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	tests := []struct {
+		name       string
+		handler    func(w http.ResponseWriter)
+		wantStatus int
+		wantBytes  int
+	}{
+		{
+			name:       "nothing written",
+			handler:    func(w http.ResponseWriter) {},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "body without a status",
+			handler:    func(w http.ResponseWriter) { w.Write([]byte("hello")) },
+			wantStatus: http.StatusOK,
+			wantBytes:  5,
+		},
+		{
+			name: "first status wins",
+			handler: func(w http.ResponseWriter) {
+				w.WriteHeader(http.StatusNotFound)
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte("missing"))
+			},
+			wantStatus: http.StatusNotFound,
+			wantBytes:  7,
+		},
+		{
+			name: "flush before the body",
+			handler: func(w http.ResponseWriter) {
+				w.(http.Flusher).Flush()
+				w.WriteHeader(http.StatusBadGateway)
+			},
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			recorder := NewRecorder(w)
+			tt.handler(recorder)
+
+			if status := recorder.Status(); status != tt.wantStatus {
+				t.Errorf("status = %d, want %d", status, tt.wantStatus)
+			}
+			if bytes := recorder.Bytes(); bytes != tt.wantBytes {
+				t.Errorf("bytes = %d, want %d", bytes, tt.wantBytes)
+			}
+			if w.Code != tt.wantStatus {
+				t.Errorf("written status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestRecorderUnwrap(t *testing.T) {
+	w := httptest.NewRecorder()
+	recorder := NewRecorder(w)
+
+	// ResponseController reaches the flusher of the wrapped writer
+	if err := http.NewResponseController(recorder).Flush(); err != nil {
+		t.Fatalf("error flushing: %v", err)
+	}
+	if !w.Flushed {
+		t.Error("the wrapped writer was not flushed")
+	}
+}
@@ -1,38 +1,83 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/gorilla/mux"
+	"github.com/igorsilvestre/simple-go-server/pkg/admin"
+	"github.com/igorsilvestre/simple-go-server/pkg/apikey"
+	"github.com/igorsilvestre/simple-go-server/pkg/config"
+	"github.com/igorsilvestre/simple-go-server/pkg/cors"
 	"github.com/igorsilvestre/simple-go-server/pkg/external"
+	"github.com/igorsilvestre/simple-go-server/pkg/health"
+	"github.com/igorsilvestre/simple-go-server/pkg/logging"
+	"github.com/igorsilvestre/simple-go-server/pkg/metrics"
+	"github.com/igorsilvestre/simple-go-server/pkg/problem"
+	"github.com/igorsilvestre/simple-go-server/pkg/ratelimit"
+	"github.com/igorsilvestre/simple-go-server/pkg/tracing"
+	"io"
 	"log"
 	"net/http"
 	"os"
-
-	"github.com/joho/godotenv"
+	"os/signal"
+	"syscall"
 )
 
-// CORS Middleware
-func corsMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+// configureCache replaces the default in-memory cache when the redis backend is selected
+func configureCache(cfg config.CacheConfig) error {
+	switch cfg.Backend {
+	case "memory":
+		cache := external.GlobalCache.(*external.Cache)
+		cache.SetLimits(cfg.MaxEntries, cfg.MaxBytes)
+		configureCacheSnapshots(cache, cfg)
+		return nil
+	case "redis":
+		options, err := external.ParseRedisURL(cfg.RedisURL)
+		if err != nil {
+			return err
+		}
+		options.Prefix = cfg.RedisKeyPrefix
 
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
+		store, err := external.NewRedisCache(options)
+		if err != nil {
+			return err
 		}
+		external.GlobalCache = store
+		log.Printf("Using Redis cache at %s", options.Addr)
+		return nil
+	default:
+		return fmt.Errorf("unknown cache backend: %s", cfg.Backend)
+	}
+}
+
+// configureCacheSnapshots restores the in-memory cache from the snapshot path, when set,
+// and keeps saving it every snapshot interval
+func configureCacheSnapshots(cache *external.Cache, cfg config.CacheConfig) {
+	if cfg.SnapshotPath == "" {
+		return
+	}
+
+	loaded, err := cache.LoadSnapshot(cfg.SnapshotPath)
+	if err != nil {
+		// A corrupt snapshot should not keep the server from starting
+		log.Printf("Failed to load cache snapshot: %v", err)
+	} else {
+		log.Printf("Loaded %d cache entries from %s", loaded, cfg.SnapshotPath)
+	}
 
-		next.ServeHTTP(w, r)
-	})
+	cache.StartSnapshots(cfg.SnapshotPath, cfg.SnapshotInterval)
 }
 
-// Logging Middleware
-func loggingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("Request: %s %s %s", r.RemoteAddr, r.Method, r.URL.Path)
-		next.ServeHTTP(w, r)
-	})
+// newServer creates the HTTP server with the configured port and timeouts
+func newServer(cfg config.ServerConfig, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              ":" + cfg.Port,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
 }
 
 func handler(w http.ResponseWriter, r *http.Request) {
@@ -40,27 +85,110 @@ func handler(w http.ResponseWriter, r *http.Request) {
 }
 
 func main() {
-	if os.Getenv("RAILWAY_ENVIRONMENT") == "" {
-		err := godotenv.Load()
-		if err != nil {
-			log.Println("Error loading .env file")
-		}
+	// Load and validate the configuration from the environment, .env and CONFIG_FILE
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	// Switch to structured logs, the log package included
+	logging.Setup(cfg.Log, os.Stdout)
+	cfg.LogProviders()
+
+	// Select the cache backend
+	if err := configureCache(cfg.Cache); err != nil {
+		log.Fatalf("Failed to configure cache: %v", err)
 	}
 
 	r := mux.NewRouter()
 
+	// Unknown routes and methods answer with problem details like every other error
+	r.NotFoundHandler = problem.Handler(http.StatusNotFound, problem.NotFound, "No route matches the request path")
+	r.MethodNotAllowedHandler = problem.Handler(http.StatusMethodNotAllowed, problem.MethodNotAllowed, "The route does not accept this method")
+
+	// Require an API key on the external routes when keys are configured
+	auth := apikey.New(cfg.Auth)
+	if auth.Enabled() {
+		log.Printf("API key authentication enabled for %d clients", len(cfg.Auth.Keys))
+	} else {
+		log.Println("No API keys configured, the external API is open to anyone")
+	}
+
+	// Limit the requests of each client IP and, once authenticated, of each API key. Only
+	// the requests admitted by the limits count against the quotas.
+	ipLimiter := ratelimit.New(cfg.RateLimit.PerIP.Rate, cfg.RateLimit.PerIP.Burst)
+	keyLimiter := ratelimit.New(cfg.RateLimit.PerKey.Rate, cfg.RateLimit.PerKey.Burst)
+	apiKeyClient := func(r *http.Request) (string, bool) {
+		return apikey.ClientFromContext(r.Context())
+	}
+
 	// Register external routes
-	external.RegisterExternalRoutes(r)
+	service := external.NewService(cfg, external.ServiceOptions{})
+	external.RegisterExternalRoutes(r, service, auth.Middleware, keyLimiter.Middleware(apiKeyClient), auth.QuotaMiddleware)
+
+	// Register admin routes
+	admin.RegisterAdminRoutes(r, cfg, auth)
 
 	// Main routes
-	r.HandleFunc("/", handler).Methods("GET", "OPTIONS")
+	r.HandleFunc("/", handler).Methods("GET")
+	health.RegisterRoutes(r, health.New(cfg, service))
+	r.Handle("/metrics", metrics.Default.Handler(cfg.Metrics.Token)).Methods("GET")
+
+	// Trace every request, exporting to the OTLP collector when one is configured
+	tracer := tracing.New(cfg.Tracing)
+	if tracer != nil {
+		log.Printf("Exporting traces to %s", cfg.Tracing.Endpoint)
+	}
+
+	// Trace the requests of every route
+	r.Use(tracer.Middleware)
+
+	// The CORS policy wraps the router so it can answer OPTIONS for every route. The router
+	// only runs its middlewares for the routes it matches, so the IP limit and the metrics
+	// wrap it too: requests to unknown paths are limited and measured, and so are the ones
+	// the limit rejects. The access log wraps everything so that every response gets a
+	// request ID and a log line.
+	handler := cors.New(cfg.CORS).Handler(r)
+	handler = ipLimiter.Middleware(ratelimit.ClientIP(cfg.RateLimit.TrustProxy))(handler)
+	handler = metrics.Middleware(r)(handler)
+	server := newServer(cfg.Server, logging.Middleware(handler))
+
+	// Serve until SIGINT or SIGTERM, then drain the in-flight requests
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	// Apply middlewares
-	r.Use(loggingMiddleware)
-	r.Use(corsMiddleware)
+	serverErrors := make(chan error, 1)
+	go func() {
+		log.Printf("Starting server on %s", server.Addr)
+		serverErrors <- server.ListenAndServe()
+	}()
 
-	log.Println("Starting server on port 8080")
-	if err := http.ListenAndServe(":8080", r); err != nil {
+	select {
+	case err := <-serverErrors:
 		log.Fatalf("Failed to start server: %v", err)
+	case <-ctx.Done():
+		stop()
 	}
+
+	log.Printf("Shutting down, waiting up to %s for in-flight requests", cfg.Server.ShutdownTimeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Failed to drain connections: %v", err)
+	}
+
+	// Export the spans still queued
+	if err := tracer.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Failed to export traces: %v", err)
+	}
+
+	// Stop the cache background work and release its connections
+	if closer, ok := external.GlobalCache.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Printf("Failed to close cache: %v", err)
+		}
+	}
+
+	log.Println("Server stopped")
 }
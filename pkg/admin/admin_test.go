@@ -0,0 +1,205 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/igorsilvestre/simple-go-server/pkg/apikey"
+	"github.com/igorsilvestre/simple-go-server/pkg/config"
+	"github.com/igorsilvestre/simple-go-server/pkg/external"
+)
+
+const testToken = "admin-token"
+
+// newTestRouter mounts the admin routes with the given token over a fresh cache holding a
+// few geocode and whois entries. The global cache is restored when the test ends.
+func newTestRouter(t *testing.T, token string) (http.Handler, *external.Cache) {
+	t.Helper()
+
+	previous := external.GlobalCache
+	cache := external.NewCache()
+	external.GlobalCache = cache
+	t.Cleanup(func() {
+		cache.Close()
+		external.GlobalCache = previous
+	})
+	for _, key := range []string{"geocode:c", "geocode:a", "geocode:b", "whois:example.com"} {
+		cache.Set(key, key, time.Hour)
+	}
+
+	cfg := config.Default()
+	cfg.Admin.Token = token
+	r := mux.NewRouter()
+	RegisterAdminRoutes(r, cfg, apikey.New(cfg.Auth))
+	return r, cache
+}
+
+// serve sends an admin request, authenticated with token when it is not empty
+func serve(handler http.Handler, method, target, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+// decodeJSON decodes a response body, failing the test on malformed JSON
+func decodeJSON[T any](t *testing.T, w *httptest.ResponseRecorder) T {
+	t.Helper()
+	var value T
+	if err := json.Unmarshal(w.Body.Bytes(), &value); err != nil {
+		t.Fatalf("error decoding response %q: %v", w.Body.String(), err)
+	}
+	return value
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	tests := []struct {
+		name       string
+		configured string
+		token      string
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "admin API disabled", configured: "", token: "anything", wantStatus: http.StatusNotFound, wantCode: "not_found"},
+		{name: "missing token", configured: testToken, token: "", wantStatus: http.StatusUnauthorized, wantCode: "unauthorized"},
+		{name: "wrong token", configured: testToken, token: "admin-tokem", wantStatus: http.StatusUnauthorized, wantCode: "unauthorized"},
+		{name: "valid token", configured: testToken, token: testToken, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, _ := newTestRouter(t, tt.configured)
+			for _, target := range []string{"/admin/cache", "/admin/cache/keys", "/admin/api-keys"} {
+				w := serve(router, http.MethodGet, target, tt.token)
+				if w.Code != tt.wantStatus {
+					t.Fatalf("%s: status = %d, want %d: %s", target, w.Code, tt.wantStatus, w.Body)
+				}
+				if tt.wantCode != "" && !strings.Contains(w.Body.String(), `"code":"`+tt.wantCode+`"`) {
+					t.Errorf("%s: body = %s, want code %s", target, w.Body, tt.wantCode)
+				}
+				if tt.wantStatus == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+					t.Errorf("%s: missing WWW-Authenticate header", target)
+				}
+			}
+		})
+	}
+}
+
+func TestCacheKeysHandler(t *testing.T) {
+	router, _ := newTestRouter(t, testToken)
+
+	type keysResponse struct {
+		Prefix string   `json:"prefix"`
+		Count  int      `json:"count"`
+		Keys   []string `json:"keys"`
+	}
+
+	tests := []struct {
+		target   string
+		wantKeys []string
+	}{
+		{"/admin/cache/keys", []string{"geocode:a", "geocode:b", "geocode:c", "whois:example.com"}},
+		{"/admin/cache/keys?prefix=geocode:", []string{"geocode:a", "geocode:b", "geocode:c"}},
+		{"/admin/cache/keys?prefix=geocode:&limit=2", []string{"geocode:a", "geocode:b"}},
+		{"/admin/cache/keys?prefix=missing:", []string{}},
+	}
+	for _, tt := range tests {
+		w := serve(router, http.MethodGet, tt.target, testToken)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d: %s", tt.target, w.Code, w.Body)
+		}
+		response := decodeJSON[keysResponse](t, w)
+		if strings.Join(response.Keys, ",") != strings.Join(tt.wantKeys, ",") || response.Count != len(tt.wantKeys) {
+			t.Errorf("%s: keys = %q (count %d), want %q", tt.target, response.Keys, response.Count, tt.wantKeys)
+		}
+	}
+
+	if w := serve(router, http.MethodGet, "/admin/cache/keys?limit=-1", testToken); w.Code != http.StatusBadRequest {
+		t.Errorf("negative limit: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestCacheEntryHandlers(t *testing.T) {
+	router, cache := newTestRouter(t, testToken)
+
+	w := serve(router, http.MethodGet, "/admin/cache/entry?key=geocode:a", testToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	entry := decodeJSON[map[string]interface{}](t, w)
+	if entry["value"] != "geocode:a" {
+		t.Errorf("value = %v, want geocode:a", entry["value"])
+	}
+	if ttl, _ := entry["ttl_seconds"].(float64); ttl <= 0 || ttl > 3600 {
+		t.Errorf("ttl_seconds = %v, want up to an hour", entry["ttl_seconds"])
+	}
+
+	if w := serve(router, http.MethodGet, "/admin/cache/entry", testToken); w.Code != http.StatusBadRequest {
+		t.Errorf("missing key: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	if w := serve(router, http.MethodDelete, "/admin/cache/entry?key=geocode:a", testToken); w.Code != http.StatusNoContent {
+		t.Fatalf("delete: status = %d, want %d: %s", w.Code, http.StatusNoContent, w.Body)
+	}
+	if _, found := cache.Inspect("geocode:a"); found {
+		t.Error("deleted entry is still cached")
+	}
+	if w := serve(router, http.MethodGet, "/admin/cache/entry?key=geocode:a", testToken); w.Code != http.StatusNotFound {
+		t.Errorf("deleted entry: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestCachePurgeHandler(t *testing.T) {
+	router, cache := newTestRouter(t, testToken)
+
+	if w := serve(router, http.MethodDelete, "/admin/cache", testToken); w.Code != http.StatusBadRequest {
+		t.Errorf("purge without prefix: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if stats := cache.Stats(); stats.Entries != 4 {
+		t.Fatalf("entries = %d after a rejected purge, want 4", stats.Entries)
+	}
+
+	w := serve(router, http.MethodDelete, "/admin/cache?prefix=geocode:", testToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("purge prefix: status = %d: %s", w.Code, w.Body)
+	}
+	if deleted := decodeJSON[map[string]interface{}](t, w)["deleted"]; deleted != float64(3) {
+		t.Errorf("deleted = %v, want 3", deleted)
+	}
+	if keys := cache.Keys("", 0); strings.Join(keys, ",") != "whois:example.com" {
+		t.Errorf("keys = %q, want only the whois entry", keys)
+	}
+
+	if w := serve(router, http.MethodDelete, "/admin/cache?all=true", testToken); w.Code != http.StatusOK {
+		t.Fatalf("flush: status = %d: %s", w.Code, w.Body)
+	}
+	if stats := cache.Stats(); stats.Entries != 0 {
+		t.Errorf("entries = %d after flush, want 0", stats.Entries)
+	}
+}
+
+// opaqueStore is a cache backend that cannot be inspected
+type opaqueStore struct {
+	external.CacheStore
+}
+
+func TestCacheStatsHandlerWithoutInspector(t *testing.T) {
+	router, cache := newTestRouter(t, testToken)
+	external.GlobalCache = opaqueStore{cache}
+
+	if w := serve(router, http.MethodGet, "/admin/cache", testToken); w.Code != http.StatusNotImplemented {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusNotImplemented)
+	}
+	// Flushing only needs the basic store
+	if w := serve(router, http.MethodDelete, "/admin/cache?all=true", testToken); w.Code != http.StatusOK {
+		t.Errorf("flush: status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,17 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/igorsilvestre/simple-go-server/pkg/apikey"
+)
+
+// APIKeyUsageHandler reports the requests made with every API key in the current day and month
+func APIKeyUsageHandler(auth *apikey.Authenticator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{
+			"enabled": auth.Enabled(),
+			"keys":    auth.Usage(),
+		})
+	}
+}
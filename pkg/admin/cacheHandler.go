@@ -0,0 +1,130 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/igorsilvestre/simple-go-server/pkg/external"
+	"github.com/igorsilvestre/simple-go-server/pkg/problem"
+)
+
+// defaultKeysLimit caps the number of keys listed when the request does not set a limit
+const defaultKeysLimit = 1000
+
+// cacheInspector returns the global cache if its backend can be inspected
+func cacheInspector(w http.ResponseWriter, r *http.Request) (external.CacheInspector, bool) {
+	inspector, ok := external.GlobalCache.(external.CacheInspector)
+	if !ok {
+		problem.Write(w, r, problem.New(http.StatusNotImplemented, problem.NotImplemented, "Cache backend does not support inspection"))
+		return nil, false
+	}
+	return inspector, true
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
+
+// CacheStatsHandler reports hit ratio, entry count, per-prefix sizes and the oldest entry
+func CacheStatsHandler(w http.ResponseWriter, r *http.Request) {
+	inspector, ok := cacheInspector(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, inspector.Stats())
+}
+
+// CacheKeysHandler lists the keys starting with the "prefix" query parameter
+func CacheKeysHandler(w http.ResponseWriter, r *http.Request) {
+	inspector, ok := cacheInspector(w, r)
+	if !ok {
+		return
+	}
+
+	limit := defaultKeysLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			problem.Write(w, r, problem.Invalid("limit", "Invalid 'limit' query parameter: "+value))
+			return
+		}
+		limit = parsed
+	}
+
+	prefix := r.URL.Query().Get("prefix")
+	keys := inspector.Keys(prefix, limit)
+	writeJSON(w, map[string]interface{}{
+		"prefix": prefix,
+		"count":  len(keys),
+		"keys":   keys,
+	})
+}
+
+// CacheEntryHandler returns a single entry, identified by the "key" query parameter, with its remaining TTL
+func CacheEntryHandler(w http.ResponseWriter, r *http.Request) {
+	inspector, ok := cacheInspector(w, r)
+	if !ok {
+		return
+	}
+
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		problem.Write(w, r, problem.Missing("key"))
+		return
+	}
+
+	entry, found := inspector.Inspect(key)
+	if !found {
+		problem.Write(w, r, problem.New(http.StatusNotFound, problem.NotFound, "Cache entry not found"))
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"key":         entry.Key,
+		"value":       entry.Value,
+		"bytes":       entry.Bytes,
+		"created_at":  entry.CreatedAt,
+		"expires_at":  entry.ExpiresAt,
+		"ttl_seconds": entry.TTL.Seconds(),
+	})
+}
+
+// CacheDeleteEntryHandler deletes the entry identified by the "key" query parameter
+func CacheDeleteEntryHandler(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		problem.Write(w, r, problem.Missing("key"))
+		return
+	}
+
+	external.GlobalCache.Delete(key)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CachePurgeHandler deletes every entry starting with the "prefix" query parameter.
+// Clearing the whole cache requires "all=true" so an empty prefix cannot wipe it by accident.
+func CachePurgeHandler(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+
+	if prefix == "" {
+		if r.URL.Query().Get("all") != "true" {
+			problem.Write(w, r, problem.New(http.StatusBadRequest, problem.MissingParameter,
+				"Missing 'prefix' query parameter (use all=true to clear the whole cache)").With("parameter", "prefix"))
+			return
+		}
+		external.GlobalCache.Clear()
+		writeJSON(w, map[string]interface{}{"cleared": true})
+		return
+	}
+
+	inspector, ok := cacheInspector(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"prefix":  prefix,
+		"deleted": inspector.DeletePrefix(prefix),
+	})
+}
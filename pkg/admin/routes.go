@@ -0,0 +1,46 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/igorsilvestre/simple-go-server/pkg/apikey"
+	"github.com/igorsilvestre/simple-go-server/pkg/config"
+	"github.com/igorsilvestre/simple-go-server/pkg/problem"
+)
+
+// RegisterAdminRoutes mounts the admin API under /admin. Every route requires the admin
+// bearer token; when no token is configured the admin API is disabled.
+func RegisterAdminRoutes(r *mux.Router, cfg *config.Config, auth *apikey.Authenticator) {
+	subrouter := r.PathPrefix("/admin").Subrouter()
+	subrouter.Use(authMiddleware(cfg.Admin.Token))
+	subrouter.HandleFunc("/cache", CacheStatsHandler).Methods("GET")
+	subrouter.HandleFunc("/cache", CachePurgeHandler).Methods("DELETE")
+	subrouter.HandleFunc("/cache/keys", CacheKeysHandler).Methods("GET")
+	subrouter.HandleFunc("/cache/entry", CacheEntryHandler).Methods("GET")
+	subrouter.HandleFunc("/cache/entry", CacheDeleteEntryHandler).Methods("DELETE")
+	subrouter.HandleFunc("/api-keys", APIKeyUsageHandler(auth)).Methods("GET")
+}
+
+// authMiddleware rejects requests that do not carry the expected bearer token
+func authMiddleware(expected string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if expected == "" {
+				problem.Write(w, r, problem.New(http.StatusNotFound, problem.NotFound, "Admin API is disabled"))
+				return
+			}
+
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+				problem.Write(w, r, problem.New(http.StatusUnauthorized, problem.Unauthorized, "Missing or invalid admin token"))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package apikey
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/igorsilvestre/simple-go-server/pkg/config"
+	"github.com/igorsilvestre/simple-go-server/pkg/logging"
+	"github.com/igorsilvestre/simple-go-server/pkg/problem"
+	"github.com/igorsilvestre/simple-go-server/pkg/ratelimit"
+)
+
+// Header is the request header carrying the API key
+const Header = "X-API-Key"
+
+// client is a configured API key with its usage in the current day and month
+type client struct {
+	config config.APIKeyConfig
+	// routes is nil when every route is allowed
+	routes map[string]bool
+
+	day          string
+	dailyCount   int
+	month        string
+	monthlyCount int
+}
+
+// Usage reports the requests made with an API key in the current UTC day and month
+type Usage struct {
+	Name         string `json:"name"`
+	Day          string `json:"day"`
+	DailyCount   int    `json:"daily_count"`
+	DailyQuota   int    `json:"daily_quota"`
+	Month        string `json:"month"`
+	MonthlyCount int    `json:"monthly_count"`
+	MonthlyQuota int    `json:"monthly_quota"`
+}
+
+// Authenticator checks the API key of every request and enforces its routes and quotas.
+// Usage is kept in memory, so it restarts from zero with the server and is not shared
+// between replicas.
+type Authenticator struct {
+	// clients is indexed by the SHA-256 of the key, so the lookup does not leak key
+	// prefixes through timing
+	clients map[[sha256.Size]byte]*client
+	now     func() time.Time
+
+	mu sync.Mutex
+}
+
+// New creates an authenticator for the configured keys
+func New(cfg config.AuthConfig) *Authenticator {
+	a := &Authenticator{
+		clients: make(map[[sha256.Size]byte]*client, len(cfg.Keys)),
+		now:     time.Now,
+	}
+	for _, key := range cfg.Keys {
+		c := &client{config: key}
+		if len(key.Routes) > 0 {
+			c.routes = make(map[string]bool, len(key.Routes))
+			for _, route := range key.Routes {
+				c.routes[route] = true
+			}
+		}
+		a.clients[sha256.Sum256([]byte(key.Key))] = c
+	}
+	return a
+}
+
+// Enabled reports whether any API key is configured. Without keys every request is let through.
+func (a *Authenticator) Enabled() bool {
+	return len(a.clients) > 0
+}
+
+// clientContextKey carries the client that made a request
+type clientContextKey struct{}
+
+// ClientFromContext returns the name of the API key a request was authenticated with
+func ClientFromContext(ctx context.Context) (string, bool) {
+	c, ok := ctx.Value(clientContextKey{}).(*client)
+	if !ok {
+		return "", false
+	}
+	return c.config.Name, true
+}
+
+// quotaError describes a quota that cannot cover a request
+type quotaError struct {
+	period    string
+	limit     int
+	remaining int
+	reset     time.Time
+}
+
+// Middleware rejects requests without a valid API key (401) or to a route the key may not
+// call (403). Quotas are enforced by QuotaMiddleware, registered after the rate limits.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.Enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		c, ok := a.clients[sha256.Sum256([]byte(r.Header.Get(Header)))]
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `APIKey header="`+Header+`"`)
+			problem.Write(w, r, problem.New(http.StatusUnauthorized, problem.Unauthorized, "Missing or invalid API key"))
+			return
+		}
+
+		if c.routes != nil && !c.routes[routeTemplate(r)] {
+			problem.Write(w, r, problem.New(http.StatusForbidden, problem.Forbidden, "API key is not allowed to call this route"))
+			return
+		}
+
+		logging.SetClient(r.Context(), c.config.Name)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientContextKey{}, c)))
+	})
+}
+
+// QuotaMiddleware counts the requests authenticated by Middleware against the quotas of
+// their key and rejects the ones over a quota (429). It runs after the rate limits so
+// that requests they reject do not use up the quota.
+func (a *Authenticator) QuotaMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, ok := r.Context().Value(clientContextKey{}).(*client)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if exceeded := a.consume(c, 1); exceeded != nil {
+			writeQuotaExceeded(w, r, c.config.Name, exceeded, a.now())
+			return
+		}
+
+		// The handler charges the rest of an expensive request, such as the rows of a
+		// batch, to the same quotas
+		ctx := ratelimit.WithCharger(r.Context(), func(w http.ResponseWriter, r *http.Request, n int) bool {
+			if exceeded := a.consume(c, n); exceeded != nil {
+				writeQuotaExceeded(w, r, c.config.Name, exceeded, a.now())
+				return false
+			}
+			return true
+		})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// routeTemplate returns the path template of the matched route, or the path when the
+// request did not go through a mux router
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return r.URL.Path
+}
+
+// consume counts n requests against the quotas of a client, all of them or none when they
+// do not fit. Rejected requests are not counted.
+func (a *Authenticator) consume(c *client, n int) *quotaError {
+	now := a.now().UTC()
+	day, month := now.Format("2006-01-02"), now.Format("2006-01")
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// Start new counters when the day or the month has changed
+	if c.day != day {
+		c.day, c.dailyCount = day, 0
+	}
+	if c.month != month {
+		c.month, c.monthlyCount = month, 0
+	}
+
+	if quota := c.config.DailyQuota; quota > 0 && c.dailyCount+n > quota {
+		return &quotaError{period: "daily", limit: quota, remaining: quota - c.dailyCount,
+			reset: time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)}
+	}
+	if quota := c.config.MonthlyQuota; quota > 0 && c.monthlyCount+n > quota {
+		return &quotaError{period: "monthly", limit: quota, remaining: quota - c.monthlyCount,
+			reset: time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)}
+	}
+
+	c.dailyCount += n
+	c.monthlyCount += n
+	return nil
+}
+
+// writeQuotaExceeded responds with 429 and tells the client when the quota resets
+func writeQuotaExceeded(w http.ResponseWriter, r *http.Request, name string, exceeded *quotaError, now time.Time) {
+	retryAfter := int(exceeded.reset.Sub(now).Seconds()) + 1
+
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	detail := fmt.Sprintf("The %s quota of %d requests of API key %s is exhausted", exceeded.period, exceeded.limit, name)
+	if exceeded.remaining > 0 {
+		detail = fmt.Sprintf("The %s quota of API key %s has %d of its %d requests left, fewer than the request needs",
+			exceeded.period, name, exceeded.remaining, exceeded.limit)
+	}
+	problem.Write(w, r, problem.New(http.StatusTooManyRequests, problem.QuotaExceeded, detail).
+		With("quota", exceeded.period).
+		With("limit", exceeded.limit).
+		With("remaining", exceeded.remaining).
+		With("reset_at", exceeded.reset.Format(time.RFC3339)))
+}
+
+// Usage returns the usage of every API key, sorted by name
+func (a *Authenticator) Usage() []Usage {
+	now := a.now().UTC()
+	day, month := now.Format("2006-01-02"), now.Format("2006-01")
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	usage := make([]Usage, 0, len(a.clients))
+	for _, c := range a.clients {
+		u := Usage{
+			Name:         c.config.Name,
+			Day:          day,
+			DailyQuota:   c.config.DailyQuota,
+			Month:        month,
+			MonthlyQuota: c.config.MonthlyQuota,
+		}
+		// Counters of a past period are reported as zero
+		if c.day == day {
+			u.DailyCount = c.dailyCount
+		}
+		if c.month == month {
+			u.MonthlyCount = c.monthlyCount
+		}
+		usage = append(usage, u)
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Name < usage[j].Name })
+	return usage
+}
@@ -0,0 +1,243 @@
+package apikey
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/igorsilvestre/simple-go-server/pkg/config"
+	"github.com/igorsilvestre/simple-go-server/pkg/ratelimit"
+)
+
+// newTestRouter mounts two routes behind the authenticator, with a clock set by the test.
+// The middlewares run between authentication and the quotas, like the rate limits do. A
+// "rows" query parameter makes the handler charge the request like a batch of that size.
+func newTestRouter(auth *Authenticator, now *time.Time, middlewares ...mux.MiddlewareFunc) http.Handler {
+	auth.now = func() time.Time { return *now }
+
+	r := mux.NewRouter()
+	subrouter := r.PathPrefix("/external").Subrouter()
+	subrouter.Use(auth.Middleware)
+	subrouter.Use(middlewares...)
+	subrouter.Use(auth.QuotaMiddleware)
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		if rows, err := strconv.Atoi(r.URL.Query().Get("rows")); err == nil && !ratelimit.Charge(w, r, rows-1) {
+			return
+		}
+		name, _ := ClientFromContext(r.Context())
+		w.Write([]byte(name))
+	}
+	subrouter.HandleFunc("/geocode", ok).Methods("GET")
+	subrouter.HandleFunc("/whois/{domain}", ok).Methods("GET")
+	return r
+}
+
+func TestMiddleware(t *testing.T) {
+	keys := config.AuthConfig{Keys: []config.APIKeyConfig{
+		{Name: "website", Key: "website-key", Routes: []string{"/external/geocode"}},
+		{Name: "partner", Key: "partner-key"},
+	}}
+
+	tests := []struct {
+		name       string
+		auth       config.AuthConfig
+		method     string
+		path       string
+		key        string
+		wantStatus int
+		wantClient string
+	}{
+		{name: "allowed route", auth: keys, path: "/external/geocode", key: "website-key", wantStatus: http.StatusOK, wantClient: "website"},
+		{name: "every route allowed", auth: keys, path: "/external/whois/example.com", key: "partner-key", wantStatus: http.StatusOK, wantClient: "partner"},
+		{name: "route not allowed", auth: keys, path: "/external/whois/example.com", key: "website-key", wantStatus: http.StatusForbidden},
+		{name: "missing key", auth: keys, path: "/external/geocode", wantStatus: http.StatusUnauthorized},
+		{name: "invalid key", auth: keys, path: "/external/geocode", key: "website-key2", wantStatus: http.StatusUnauthorized},
+		{name: "no keys configured", path: "/external/geocode", wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+			router := newTestRouter(New(tt.auth), &now)
+
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, tt.path, nil)
+			if tt.key != "" {
+				req.Header.Set(Header, tt.key)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantClient != "" && w.Body.String() != tt.wantClient {
+				t.Errorf("client = %q, want %q", w.Body, tt.wantClient)
+			}
+		})
+	}
+}
+
+func TestQuotas(t *testing.T) {
+	auth := New(config.AuthConfig{Keys: []config.APIKeyConfig{
+		{Name: "website", Key: "website-key", DailyQuota: 2, MonthlyQuota: 3},
+	}})
+	now := time.Date(2026, 10, 17, 23, 0, 0, 0, time.UTC)
+	router := newTestRouter(auth, &now)
+
+	request := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/external/geocode", nil)
+		req.Header.Set(Header, "website-key")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := request(); w.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want %d", i, w.Code, http.StatusOK)
+		}
+	}
+
+	// The third request of the day is over the daily quota
+	w := request()
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if retryAfter := w.Header().Get("Retry-After"); retryAfter != "3601" {
+		t.Errorf("Retry-After = %q, want 3601", retryAfter)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("error decoding %q: %v", w.Body, err)
+	}
+	if w.Header().Get("Content-Type") != "application/problem+json" {
+		t.Errorf("Content-Type = %q, want problem details", w.Header().Get("Content-Type"))
+	}
+	if body["code"] != "quota_exceeded" || body["status"] != float64(http.StatusTooManyRequests) || body["quota"] != "daily" || body["reset_at"] != "2026-10-18T00:00:00Z" {
+		t.Errorf("body = %v, want an exhausted daily quota resetting at midnight", body)
+	}
+
+	// The next day only one request is left in the monthly quota
+	now = now.Add(2 * time.Hour)
+	if w := request(); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	w = request()
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body["quota"] != "monthly" {
+		t.Errorf("body = %s, want an exhausted monthly quota", w.Body)
+	}
+
+	usage := auth.Usage()
+	if len(usage) != 1 || usage[0].DailyCount != 1 || usage[0].MonthlyCount != 3 || usage[0].Day != "2026-10-18" {
+		t.Errorf("usage = %+v, want 1 request today and 3 this month", usage)
+	}
+
+	// A new month starts from zero
+	now = time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+	if w := request(); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestQuotasIgnoreRejectedRequests(t *testing.T) {
+	auth := New(config.AuthConfig{Keys: []config.APIKeyConfig{
+		{Name: "website", Key: "website-key", DailyQuota: 2},
+	}})
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+
+	// Stands in for the per-key rate limit, rejecting every request while limited is set
+	limited := true
+	limiter := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if limited {
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	router := newTestRouter(auth, &now, limiter)
+
+	request := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/external/geocode", nil)
+		req.Header.Set(Header, "website-key")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	for i := 0; i < 5; i++ {
+		if status := request(); status != http.StatusTooManyRequests {
+			t.Fatalf("limited request %d: status = %d, want %d", i, status, http.StatusTooManyRequests)
+		}
+	}
+	if usage := auth.Usage(); usage[0].DailyCount != 0 {
+		t.Errorf("daily count = %d after rate limited requests, want 0", usage[0].DailyCount)
+	}
+
+	// The whole quota is still available once the rate limit admits the requests
+	limited = false
+	for i := 0; i < 2; i++ {
+		if status := request(); status != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want %d", i, status, http.StatusOK)
+		}
+	}
+	if usage := auth.Usage(); usage[0].DailyCount != 2 {
+		t.Errorf("daily count = %d, want 2", usage[0].DailyCount)
+	}
+}
+
+func TestQuotasChargeEveryBatchRow(t *testing.T) {
+	auth := New(config.AuthConfig{Keys: []config.APIKeyConfig{
+		{Name: "website", Key: "website-key", DailyQuota: 10},
+	}})
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	router := newTestRouter(auth, &now)
+
+	request := func(rows int) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/external/geocode?rows="+strconv.Itoa(rows), nil)
+		req.Header.Set(Header, "website-key")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := request(6); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	if usage := auth.Usage(); usage[0].DailyCount != 6 {
+		t.Errorf("daily count = %d after a batch of 6, want 6", usage[0].DailyCount)
+	}
+
+	// A batch larger than what is left is rejected whole and only its first row is counted
+	w := request(6)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusTooManyRequests, w.Body)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body["remaining"] != float64(3) {
+		t.Errorf("body = %s, want 3 requests remaining", w.Body)
+	}
+	if usage := auth.Usage(); usage[0].DailyCount != 7 {
+		t.Errorf("daily count = %d, want 7", usage[0].DailyCount)
+	}
+
+	// The rest of the quota still covers a batch that fits
+	if w := request(3); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	if w := request(1); w.Code != http.StatusTooManyRequests {
+		t.Errorf("status = %d over the quota, want %d", w.Code, http.StatusTooManyRequests)
+	}
+}
@@ -0,0 +1,818 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Provider names as used in routes, provider lists and the configuration file
+const (
+	Google     = "google"
+	Geoapify   = "geoapify"
+	Nominatim  = "nominatim"
+	MapTiler   = "maptiler"
+	JSONWhois  = "jsonwhois"
+	MailerSend = "mailersend"
+)
+
+// GeocodingProviders lists the providers that implement geocoding, in the default fallback order
+var GeocodingProviders = []string{Google, Geoapify, Nominatim, MapTiler}
+
+// AllProviders lists every upstream provider
+var AllProviders = []string{Google, Geoapify, Nominatim, MapTiler, JSONWhois, MailerSend}
+
+// Config holds every setting of the server. It is loaded once at startup by Load and
+// passed to the packages that need it.
+type Config struct {
+	Server    ServerConfig    `yaml:"server"`
+	Cache     CacheConfig     `yaml:"cache"`
+	Providers ProvidersConfig `yaml:"providers"`
+	Geocoding GeocodingConfig `yaml:"geocoding"`
+	Admin     AdminConfig     `yaml:"admin"`
+	Auth      AuthConfig      `yaml:"auth"`
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+	CORS      CORSConfig      `yaml:"cors"`
+	Log       LogConfig       `yaml:"log"`
+	Metrics   MetricsConfig   `yaml:"metrics"`
+	Tracing   TracingConfig   `yaml:"tracing"`
+}
+
+// ServerConfig configures the HTTP server
+type ServerConfig struct {
+	Port            string        `yaml:"port"`
+	ReadTimeout     time.Duration `yaml:"read_timeout"`
+	WriteTimeout    time.Duration `yaml:"write_timeout"`
+	IdleTimeout     time.Duration `yaml:"idle_timeout"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// UpstreamTimeout bounds every call to an upstream provider
+	UpstreamTimeout time.Duration `yaml:"upstream_timeout"`
+}
+
+// CacheConfig selects and sizes the cache backend
+type CacheConfig struct {
+	// Backend is either "memory" or "redis"
+	Backend          string        `yaml:"backend"`
+	MaxEntries       int           `yaml:"max_entries"`
+	MaxBytes         int64         `yaml:"max_bytes"`
+	SnapshotPath     string        `yaml:"snapshot_path"`
+	SnapshotInterval time.Duration `yaml:"snapshot_interval"`
+	RedisURL         string        `yaml:"redis_url"`
+	RedisKeyPrefix   string        `yaml:"redis_key_prefix"`
+	// Policies overrides the cache policy of a namespace such as "geoapify_geocoding"
+	Policies map[string]CachePolicyConfig `yaml:"policies"`
+}
+
+// CachePolicyConfig overrides the durations of a cache policy. Unset durations keep their default.
+type CachePolicyConfig struct {
+	TTL         *time.Duration `yaml:"ttl"`
+	StaleTTL    *time.Duration `yaml:"stale_ttl"`
+	NegativeTTL *time.Duration `yaml:"negative_ttl"`
+}
+
+// ProviderConfig holds the credentials of an upstream provider
+type ProviderConfig struct {
+	APIKey string `yaml:"api_key"`
+	// Enabled can turn a provider off even when its key is present. Unset means enabled.
+	Enabled *bool `yaml:"enabled"`
+}
+
+// ProvidersConfig holds the configuration of every upstream provider
+type ProvidersConfig struct {
+	Google     ProviderConfig `yaml:"google"`
+	Geoapify   ProviderConfig `yaml:"geoapify"`
+	Nominatim  ProviderConfig `yaml:"nominatim"`
+	MapTiler   ProviderConfig `yaml:"maptiler"`
+	JSONWhois  ProviderConfig `yaml:"jsonwhois"`
+	MailerSend ProviderConfig `yaml:"mailersend"`
+}
+
+// GeocodingConfig configures the geocoding modes
+type GeocodingConfig struct {
+	// ProviderOrder is the order in which the fallback mode tries the providers
+	ProviderOrder []string `yaml:"provider_order"`
+	BatchWorkers  int      `yaml:"batch_workers"`
+}
+
+// AdminConfig configures the admin API
+type AdminConfig struct {
+	// Token is the bearer token of the admin API, which is disabled when empty
+	Token string `yaml:"token"`
+}
+
+// AuthConfig configures the API keys of the external API. When no key is configured the
+// external API is open to anyone.
+type AuthConfig struct {
+	// KeysFile is a YAML file holding a list of keys, added to Keys
+	KeysFile string         `yaml:"keys_file"`
+	Keys     []APIKeyConfig `yaml:"keys"`
+}
+
+// APIKeyConfig describes the API key of a client
+type APIKeyConfig struct {
+	// Name identifies the client in logs and usage reports
+	Name string `yaml:"name"`
+	Key  string `yaml:"key"`
+	// Routes lists the route templates the key may call, e.g. "/external/geocode" or
+	// "/external/whois/{domain}". Empty allows every route.
+	Routes []string `yaml:"routes"`
+	// DailyQuota and MonthlyQuota limit the requests per UTC day and month, where a batch
+	// counts one request per address. 0 means unlimited
+	DailyQuota   int `yaml:"daily_quota"`
+	MonthlyQuota int `yaml:"monthly_quota"`
+}
+
+// RateLimitConfig configures the inbound and outbound rate limits
+type RateLimitConfig struct {
+	// PerIP and PerKey limit the requests of each client IP and of each API key
+	PerIP  RateConfig `yaml:"per_ip"`
+	PerKey RateConfig `yaml:"per_key"`
+	// TrustProxy takes the client IP from X-Forwarded-For, which is only safe behind a
+	// proxy that sets it, such as Railway's. It defaults to true on Railway. Behind any
+	// other proxy it must be set, or every client shares the per-IP limit of the proxy.
+	TrustProxy bool `yaml:"trust_proxy"`
+	// Providers limits the calls made to each upstream provider
+	Providers map[string]ProviderRateConfig `yaml:"providers"`
+}
+
+// RateConfig describes a token bucket
+type RateConfig struct {
+	// Rate is the sustained number of requests per second, 0 disables the limit
+	Rate float64 `yaml:"rate"`
+	// Burst is the number of requests allowed at once, at least 1
+	Burst int `yaml:"burst"`
+}
+
+// ProviderRateConfig limits the calls made to an upstream provider
+type ProviderRateConfig struct {
+	RateConfig `yaml:",inline"`
+	// MaxWait is how long a call over the limit waits for its turn before failing, so
+	// that the fallback chain can move on to the next provider
+	MaxWait time.Duration `yaml:"max_wait"`
+}
+
+// CORSConfig configures which browser origins may call the API
+type CORSConfig struct {
+	// AllowedOrigins lists origins such as "https://app.example.com", "https://*.example.com"
+	// for every subdomain, or "*" for any origin
+	AllowedOrigins []string `yaml:"allowed_origins"`
+	// AllowCredentials lets browsers send cookies and Authorization headers. It cannot be
+	// combined with the "*" origin.
+	AllowCredentials bool     `yaml:"allow_credentials"`
+	AllowedHeaders   []string `yaml:"allowed_headers"`
+	ExposedHeaders   []string `yaml:"exposed_headers"`
+	// MaxAge is how long browsers may cache a preflight response
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+// LogConfig configures the logs of the server
+type LogConfig struct {
+	// Format is either "json" or "text"
+	Format string `yaml:"format"`
+	// Level is the minimum level logged: "debug", "info", "warn" or "error"
+	Level string `yaml:"level"`
+	// RedactAddresses hides the addresses and coordinates looked up by clients from the
+	// access and upstream logs
+	RedactAddresses bool `yaml:"redact_addresses"`
+}
+
+// MetricsConfig configures the Prometheus endpoint
+type MetricsConfig struct {
+	// Token is the bearer token scrapes must carry, the endpoint is public when empty
+	Token string `yaml:"token"`
+}
+
+// TracingConfig configures the export of traces to an OpenTelemetry collector
+type TracingConfig struct {
+	// Endpoint is the base URL of the OTLP/HTTP collector, such as http://localhost:4318.
+	// Spans are posted to <endpoint>/v1/traces. Tracing is disabled when empty.
+	Endpoint string `yaml:"endpoint"`
+	// Headers are added to every export, such as the API key of a hosted collector
+	Headers     map[string]string `yaml:"headers"`
+	ServiceName string            `yaml:"service_name"`
+	// SampleRatio is the share of new traces recorded, from 0 to 1. Requests carrying a
+	// traceparent follow the decision of their caller.
+	SampleRatio float64 `yaml:"sample_ratio"`
+	// BatchTimeout is how often the queued spans are exported
+	BatchTimeout time.Duration `yaml:"batch_timeout"`
+}
+
+// providerSettings describes how a provider is configured from the environment
+type providerSettings struct {
+	name string
+	// keyEnv is the variable holding the API key, empty for providers that need none
+	keyEnv     string
+	enabledEnv string
+}
+
+var providerEnv = []providerSettings{
+	{Google, "GOOGLE_API_KEY", "GOOGLE_ENABLED"},
+	{Geoapify, "GEOAPIFY_API_KEY", "GEOAPIFY_ENABLED"},
+	{Nominatim, "", "NOMINATIM_ENABLED"},
+	{MapTiler, "MAPTILER_API_KEY", "MAPTILER_ENABLED"},
+	{JSONWhois, "JSONWHOIS_APIKEY", "JSONWHOIS_ENABLED"},
+	{MailerSend, "MAILERSEND_API_KEY", "MAILERSEND_ENABLED"},
+}
+
+// Default returns the configuration used when nothing is set
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Port:            "8080",
+			ReadTimeout:     15 * time.Second,
+			WriteTimeout:    60 * time.Second,
+			IdleTimeout:     120 * time.Second,
+			ShutdownTimeout: 25 * time.Second,
+			UpstreamTimeout: 10 * time.Second,
+		},
+		Cache: CacheConfig{
+			Backend:          "memory",
+			SnapshotInterval: 5 * time.Minute,
+		},
+		Geocoding: GeocodingConfig{
+			ProviderOrder: append([]string(nil), GeocodingProviders...),
+			BatchWorkers:  4,
+		},
+		CORS: CORSConfig{
+			AllowedOrigins: []string{"*"},
+			AllowedHeaders: []string{"Authorization", "Content-Type", "X-API-Key"},
+			ExposedHeaders: []string{"Retry-After"},
+			MaxAge:         10 * time.Minute,
+		},
+		Log: LogConfig{
+			Format: "json",
+			Level:  "info",
+		},
+		Tracing: TracingConfig{
+			ServiceName:  "simple-go-server",
+			SampleRatio:  1,
+			BatchTimeout: 5 * time.Second,
+		},
+		RateLimit: RateLimitConfig{
+			PerIP:  RateConfig{Rate: 10, Burst: 20},
+			PerKey: RateConfig{Rate: 20, Burst: 40},
+			Providers: map[string]ProviderRateConfig{
+				// Nominatim's usage policy allows an absolute maximum of 1 request per second
+				Nominatim: {RateConfig: RateConfig{Rate: 1, Burst: 1}, MaxWait: 2 * time.Second},
+			},
+		},
+	}
+}
+
+// Load builds the configuration from the defaults, the YAML file named by CONFIG_FILE and
+// the environment, in increasing order of precedence. Outside Railway the .env file is
+// loaded into the environment first. On Railway the client IP is taken from the
+// X-Forwarded-For header of its proxy by default. The result is validated.
+func Load() (*Config, error) {
+	onRailway := os.Getenv("RAILWAY_ENVIRONMENT") != ""
+	if !onRailway {
+		if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("error loading .env file: %v", err)
+		}
+	}
+
+	cfg := Default()
+
+	// Every request reaches the server through Railway's proxy, so without the forwarded
+	// IP all clients would share a single per-IP bucket
+	if onRailway {
+		cfg.RateLimit.TrustProxy = true
+	}
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+
+	if cfg.Auth.KeysFile != "" {
+		if err := cfg.loadKeysFile(cfg.Auth.KeysFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadFile reads the YAML configuration file at path. Unknown settings are rejected so
+// that typos do not go unnoticed.
+func (c *Config) loadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error reading config file: %v", err)
+	}
+	defer file.Close()
+
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("error parsing config file %s: %v", path, err)
+	}
+	return nil
+}
+
+// loadKeysFile appends the API keys listed in the YAML file at path
+func (c *Config) loadKeysFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error reading API keys file: %v", err)
+	}
+	defer file.Close()
+
+	var keys []APIKeyConfig
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&keys); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("error parsing API keys file %s: %v", path, err)
+	}
+	c.Auth.Keys = append(c.Auth.Keys, keys...)
+	return nil
+}
+
+// loadEnv applies the environment variables on top of the current configuration
+func (c *Config) loadEnv() error {
+	var errs []error
+
+	setString := func(name string, target *string) {
+		if value := os.Getenv(name); value != "" {
+			*target = value
+		}
+	}
+	setDuration := func(name string, target *time.Duration) {
+		if value := os.Getenv(name); value != "" {
+			duration, err := time.ParseDuration(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid %s: %s", name, value))
+				return
+			}
+			*target = duration
+		}
+	}
+	setFloat := func(name string, target *float64) {
+		if value := os.Getenv(name); value != "" {
+			number, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid %s: %s", name, value))
+				return
+			}
+			*target = number
+		}
+	}
+	setInt := func(name string, target *int) {
+		if value := os.Getenv(name); value != "" {
+			number, err := strconv.Atoi(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid %s: %s", name, value))
+				return
+			}
+			*target = number
+		}
+	}
+
+	// Server
+	setString("PORT", &c.Server.Port)
+	setDuration("HTTP_READ_TIMEOUT", &c.Server.ReadTimeout)
+	setDuration("HTTP_WRITE_TIMEOUT", &c.Server.WriteTimeout)
+	setDuration("HTTP_IDLE_TIMEOUT", &c.Server.IdleTimeout)
+	setDuration("SHUTDOWN_TIMEOUT", &c.Server.ShutdownTimeout)
+	setDuration("UPSTREAM_TIMEOUT", &c.Server.UpstreamTimeout)
+
+	// Cache
+	setString("CACHE_BACKEND", &c.Cache.Backend)
+	setInt("CACHE_MAX_ENTRIES", &c.Cache.MaxEntries)
+	if value := os.Getenv("CACHE_MAX_BYTES"); value != "" {
+		maxBytes, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid CACHE_MAX_BYTES: %s", value))
+		} else {
+			c.Cache.MaxBytes = maxBytes
+		}
+	}
+	setString("CACHE_SNAPSHOT_PATH", &c.Cache.SnapshotPath)
+	setDuration("CACHE_SNAPSHOT_INTERVAL", &c.Cache.SnapshotInterval)
+	setString("REDIS_URL", &c.Cache.RedisURL)
+	setString("REDIS_KEY_PREFIX", &c.Cache.RedisKeyPrefix)
+	if err := c.loadCachePolicyEnv(); err != nil {
+		errs = append(errs, err)
+	}
+
+	// Providers
+	for _, settings := range providerEnv {
+		provider := c.Providers.get(settings.name)
+		if settings.keyEnv != "" {
+			setString(settings.keyEnv, &provider.APIKey)
+		}
+		if value := os.Getenv(settings.enabledEnv); value != "" {
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid %s: %s", settings.enabledEnv, value))
+				continue
+			}
+			provider.Enabled = &enabled
+		}
+	}
+
+	// Geocoding
+	if value := os.Getenv("GEOCODING_PROVIDER_ORDER"); value != "" {
+		c.Geocoding.ProviderOrder = ParseProviderList(value)
+	}
+	setInt("GEOCODE_BATCH_WORKERS", &c.Geocoding.BatchWorkers)
+
+	// Admin
+	setString("ADMIN_TOKEN", &c.Admin.Token)
+
+	// Auth
+	setString("API_KEYS_FILE", &c.Auth.KeysFile)
+
+	// CORS
+	if value := os.Getenv("CORS_ALLOWED_ORIGINS"); value != "" {
+		c.CORS.AllowedOrigins = splitList(value)
+	}
+	if value := os.Getenv("CORS_ALLOW_CREDENTIALS"); value != "" {
+		allow, err := strconv.ParseBool(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid CORS_ALLOW_CREDENTIALS: %s", value))
+		} else {
+			c.CORS.AllowCredentials = allow
+		}
+	}
+	setDuration("CORS_MAX_AGE", &c.CORS.MaxAge)
+
+	// Logs
+	setString("LOG_FORMAT", &c.Log.Format)
+	setString("LOG_LEVEL", &c.Log.Level)
+	if value := os.Getenv("LOG_REDACT_ADDRESSES"); value != "" {
+		redact, err := strconv.ParseBool(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid LOG_REDACT_ADDRESSES: %s", value))
+		} else {
+			c.Log.RedactAddresses = redact
+		}
+	}
+
+	// Metrics
+	setString("METRICS_TOKEN", &c.Metrics.Token)
+
+	// Tracing, with the standard OpenTelemetry variables where there is one
+	setString("OTEL_EXPORTER_OTLP_ENDPOINT", &c.Tracing.Endpoint)
+	setString("OTEL_SERVICE_NAME", &c.Tracing.ServiceName)
+	if value := os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"); value != "" {
+		headers, err := parseHeaders(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid OTEL_EXPORTER_OTLP_HEADERS: %v", err))
+		} else {
+			c.Tracing.Headers = headers
+		}
+	}
+	setFloat("TRACING_SAMPLE_RATIO", &c.Tracing.SampleRatio)
+	setDuration("TRACING_BATCH_TIMEOUT", &c.Tracing.BatchTimeout)
+
+	// Rate limits
+	setFloat("RATE_LIMIT_PER_IP", &c.RateLimit.PerIP.Rate)
+	setInt("RATE_LIMIT_PER_IP_BURST", &c.RateLimit.PerIP.Burst)
+	setFloat("RATE_LIMIT_PER_KEY", &c.RateLimit.PerKey.Rate)
+	setInt("RATE_LIMIT_PER_KEY_BURST", &c.RateLimit.PerKey.Burst)
+	if value := os.Getenv("RATE_LIMIT_TRUST_PROXY"); value != "" {
+		trust, err := strconv.ParseBool(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid RATE_LIMIT_TRUST_PROXY: %s", value))
+		} else {
+			c.RateLimit.TrustProxy = trust
+		}
+	}
+	for _, settings := range providerEnv {
+		prefix := strings.ToUpper(settings.name) + "_RATE_LIMIT"
+		limit := c.RateLimit.Providers[settings.name]
+		setFloat(prefix, &limit.Rate)
+		setInt(prefix+"_BURST", &limit.Burst)
+		setDuration(prefix+"_MAX_WAIT", &limit.MaxWait)
+		if limit != (ProviderRateConfig{}) {
+			if c.RateLimit.Providers == nil {
+				c.RateLimit.Providers = make(map[string]ProviderRateConfig)
+			}
+			c.RateLimit.Providers[settings.name] = limit
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// loadCachePolicyEnv reads the CACHE_POLICY_<NAMESPACE>_TTL, _STALE_TTL and _NEGATIVE_TTL
+// variables, for example CACHE_POLICY_GEOAPIFY_GEOCODING_TTL=1000h
+func (c *Config) loadCachePolicyEnv() error {
+	var errs []error
+
+	for _, variable := range os.Environ() {
+		name, value, _ := strings.Cut(variable, "=")
+		rest, ok := strings.CutPrefix(name, "CACHE_POLICY_")
+		if !ok || value == "" {
+			continue
+		}
+
+		// Check the longer suffixes first, every one of them ends with _TTL
+		var namespace, field string
+		for _, suffix := range []string{"_NEGATIVE_TTL", "_STALE_TTL", "_TTL"} {
+			if trimmed, found := strings.CutSuffix(rest, suffix); found {
+				namespace, field = strings.ToLower(trimmed), suffix
+				break
+			}
+		}
+		if namespace == "" {
+			errs = append(errs, fmt.Errorf("unknown cache policy variable: %s", name))
+			continue
+		}
+
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s: %s", name, value))
+			continue
+		}
+
+		if c.Cache.Policies == nil {
+			c.Cache.Policies = make(map[string]CachePolicyConfig)
+		}
+		policy := c.Cache.Policies[namespace]
+		switch field {
+		case "_NEGATIVE_TTL":
+			policy.NegativeTTL = &duration
+		case "_STALE_TTL":
+			policy.StaleTTL = &duration
+		default:
+			policy.TTL = &duration
+		}
+		c.Cache.Policies[namespace] = policy
+	}
+
+	return errors.Join(errs...)
+}
+
+// Validate reports every invalid setting at once
+func (c *Config) Validate() error {
+	var errs []error
+
+	if port, err := strconv.Atoi(c.Server.Port); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("invalid server port: %s", c.Server.Port))
+	}
+	for name, duration := range map[string]time.Duration{
+		"read timeout":     c.Server.ReadTimeout,
+		"write timeout":    c.Server.WriteTimeout,
+		"idle timeout":     c.Server.IdleTimeout,
+		"shutdown timeout": c.Server.ShutdownTimeout,
+		"upstream timeout": c.Server.UpstreamTimeout,
+	} {
+		if duration <= 0 {
+			errs = append(errs, fmt.Errorf("invalid server %s: %s", name, duration))
+		}
+	}
+
+	switch c.Cache.Backend {
+	case "memory":
+		if c.Cache.SnapshotPath != "" && c.Cache.SnapshotInterval <= 0 {
+			errs = append(errs, fmt.Errorf("invalid cache snapshot interval: %s", c.Cache.SnapshotInterval))
+		}
+	case "redis":
+		if c.Cache.RedisURL == "" {
+			errs = append(errs, errors.New("the redis cache backend requires a redis URL"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown cache backend: %s", c.Cache.Backend))
+	}
+	if c.Cache.MaxEntries < 0 {
+		errs = append(errs, fmt.Errorf("invalid cache max entries: %d", c.Cache.MaxEntries))
+	}
+	if c.Cache.MaxBytes < 0 {
+		errs = append(errs, fmt.Errorf("invalid cache max bytes: %d", c.Cache.MaxBytes))
+	}
+	for namespace, policy := range c.Cache.Policies {
+		for _, duration := range []*time.Duration{policy.TTL, policy.StaleTTL, policy.NegativeTTL} {
+			if duration != nil && *duration < 0 {
+				errs = append(errs, fmt.Errorf("invalid cache policy for %s: negative duration %s", namespace, *duration))
+			}
+		}
+	}
+
+	for _, name := range c.Geocoding.ProviderOrder {
+		if !isGeocodingProvider(name) {
+			errs = append(errs, fmt.Errorf("unknown geocoding provider in provider order: %s", name))
+		}
+	}
+	if c.Geocoding.BatchWorkers < 1 {
+		errs = append(errs, fmt.Errorf("invalid geocoding batch workers: %d", c.Geocoding.BatchWorkers))
+	}
+
+	for name, limit := range map[string]RateConfig{"per IP": c.RateLimit.PerIP, "per key": c.RateLimit.PerKey} {
+		if limit.Rate < 0 || limit.Burst < 0 {
+			errs = append(errs, fmt.Errorf("invalid %s rate limit: %g/s, burst %d", name, limit.Rate, limit.Burst))
+		}
+	}
+	for name, limit := range c.RateLimit.Providers {
+		if c.Providers.get(name) == nil {
+			errs = append(errs, fmt.Errorf("rate limit for unknown provider: %s", name))
+		}
+		if limit.Rate < 0 || limit.Burst < 0 || limit.MaxWait < 0 {
+			errs = append(errs, fmt.Errorf("invalid rate limit for %s: %g/s, burst %d, max wait %s", name, limit.Rate, limit.Burst, limit.MaxWait))
+		}
+	}
+
+	for _, origin := range c.CORS.AllowedOrigins {
+		switch {
+		case origin == "*":
+			if c.CORS.AllowCredentials {
+				errs = append(errs, errors.New("CORS credentials cannot be allowed for the \"*\" origin"))
+			}
+		case !strings.HasPrefix(origin, "http://") && !strings.HasPrefix(origin, "https://"):
+			errs = append(errs, fmt.Errorf("invalid CORS origin, expected a scheme: %s", origin))
+		case strings.Contains(strings.TrimPrefix(strings.SplitN(origin, "://", 2)[1], "*."), "*"):
+			errs = append(errs, fmt.Errorf("invalid CORS origin, \"*.\" is only allowed before the domain: %s", origin))
+		}
+	}
+	if c.CORS.MaxAge < 0 {
+		errs = append(errs, fmt.Errorf("invalid CORS max age: %s", c.CORS.MaxAge))
+	}
+
+	if c.Log.Format != "json" && c.Log.Format != "text" {
+		errs = append(errs, fmt.Errorf("unknown log format: %s", c.Log.Format))
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		errs = append(errs, fmt.Errorf("unknown log level: %s", c.Log.Level))
+	}
+
+	if c.Tracing.Endpoint != "" && !strings.HasPrefix(c.Tracing.Endpoint, "http://") && !strings.HasPrefix(c.Tracing.Endpoint, "https://") {
+		errs = append(errs, fmt.Errorf("invalid tracing endpoint, expected an http or https URL: %s", c.Tracing.Endpoint))
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs = append(errs, fmt.Errorf("invalid tracing sample ratio, expected 0 to 1: %g", c.Tracing.SampleRatio))
+	}
+	if c.Tracing.BatchTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("invalid tracing batch timeout: %s", c.Tracing.BatchTimeout))
+	}
+
+	names := make(map[string]bool)
+	keys := make(map[string]bool)
+	for i, key := range c.Auth.Keys {
+		switch {
+		case key.Name == "":
+			errs = append(errs, fmt.Errorf("API key %d has no name", i))
+		case names[key.Name]:
+			errs = append(errs, fmt.Errorf("duplicate API key name: %s", key.Name))
+		}
+		switch {
+		case key.Key == "":
+			errs = append(errs, fmt.Errorf("API key %s has no key", key.Name))
+		case keys[key.Key]:
+			errs = append(errs, fmt.Errorf("API key %s reuses the key of another client", key.Name))
+		}
+		names[key.Name], keys[key.Key] = true, true
+
+		if key.DailyQuota < 0 || key.MonthlyQuota < 0 {
+			errs = append(errs, fmt.Errorf("invalid quota for API key %s", key.Name))
+		}
+		for _, route := range key.Routes {
+			if !strings.HasPrefix(route, "/") {
+				errs = append(errs, fmt.Errorf("invalid route for API key %s: %s", key.Name, route))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// get returns the configuration of a provider by name
+func (p *ProvidersConfig) get(name string) *ProviderConfig {
+	switch name {
+	case Google:
+		return &p.Google
+	case Geoapify:
+		return &p.Geoapify
+	case Nominatim:
+		return &p.Nominatim
+	case MapTiler:
+		return &p.MapTiler
+	case JSONWhois:
+		return &p.JSONWhois
+	case MailerSend:
+		return &p.MailerSend
+	}
+	return nil
+}
+
+// APIKey returns the API key of a provider
+func (p *ProvidersConfig) APIKey(name string) string {
+	if provider := p.get(name); provider != nil {
+		return provider.APIKey
+	}
+	return ""
+}
+
+// Enabled reports whether a provider can be called: it is not turned off and it has an
+// API key, unless it needs none
+func (p *ProvidersConfig) Enabled(name string) bool {
+	provider := p.get(name)
+	if provider == nil || (provider.Enabled != nil && !*provider.Enabled) {
+		return false
+	}
+	return provider.APIKey != "" || name == Nominatim
+}
+
+// EnabledGeocoders returns the enabled geocoding providers in the given order
+func (c *Config) EnabledGeocoders(order []string) []string {
+	var enabled []string
+	for _, name := range order {
+		if c.Providers.Enabled(name) {
+			enabled = append(enabled, name)
+		}
+	}
+	return enabled
+}
+
+// LogProviders reports which providers are enabled and why the others are not
+func (c *Config) LogProviders() {
+	var enabled, disabled []string
+	for _, settings := range providerEnv {
+		provider := c.Providers.get(settings.name)
+		switch {
+		case c.Providers.Enabled(settings.name):
+			enabled = append(enabled, settings.name)
+		case provider.Enabled != nil && !*provider.Enabled:
+			disabled = append(disabled, settings.name+" (turned off)")
+		default:
+			disabled = append(disabled, settings.name+" ("+settings.keyEnv+" not set)")
+		}
+	}
+	sort.Strings(enabled)
+
+	log.Printf("Enabled providers: %s", strings.Join(enabled, ", "))
+	if len(disabled) > 0 {
+		log.Printf("Disabled providers: %s", strings.Join(disabled, ", "))
+	}
+}
+
+// ParseProviderList splits a comma separated list of provider names
+func ParseProviderList(value string) []string {
+	var providers []string
+	for _, name := range strings.Split(value, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" {
+			providers = append(providers, name)
+		}
+	}
+	return providers
+}
+
+// splitList splits a comma separated list, dropping the empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseHeaders parses a list of headers such as "api-key=secret,x-team=geo", with
+// URL-encoded values as in OTEL_EXPORTER_OTLP_HEADERS
+func parseHeaders(value string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, pair := range splitList(value) {
+		name, encoded, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("expected name=value: %s", pair)
+		}
+		decoded, err := url.QueryUnescape(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of %s: %v", name, err)
+		}
+		headers[strings.TrimSpace(name)] = decoded
+	}
+	return headers, nil
+}
+
+// isGeocodingProvider reports whether name is a known geocoding provider
+func isGeocodingProvider(name string) bool {
+	for _, provider := range GeocodingProviders {
+		if provider == name {
+			return true
+		}
+	}
+	return false
+}
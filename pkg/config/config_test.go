@@ -0,0 +1,358 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeFile writes content to a file in a temporary directory and returns its path
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDefault(t *testing.T) {
+	cfg := Default()
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("default configuration is invalid: %v", err)
+	}
+
+	if cfg.Server.Port != "8080" || cfg.Cache.Backend != "memory" || cfg.Log.Format != "json" {
+		t.Errorf("port/backend/log format = %s/%s/%s, want 8080/memory/json", cfg.Server.Port, cfg.Cache.Backend, cfg.Log.Format)
+	}
+	if strings.Join(cfg.Geocoding.ProviderOrder, ",") != strings.Join(GeocodingProviders, ",") {
+		t.Errorf("provider order = %q, want %q", cfg.Geocoding.ProviderOrder, GeocodingProviders)
+	}
+	if limit := cfg.RateLimit.Providers[Nominatim]; limit.Rate != 1 || limit.Burst != 1 {
+		t.Errorf("nominatim rate limit = %+v, want 1/s as required by its usage policy", limit)
+	}
+
+	// Only Nominatim needs no key, the other providers are disabled until configured
+	for _, name := range AllProviders {
+		if enabled := cfg.Providers.Enabled(name); enabled != (name == Nominatim) {
+			t.Errorf("provider %s enabled = %t by default", name, enabled)
+		}
+	}
+
+	// Every call returns a fresh copy that can be changed safely
+	cfg.Geocoding.ProviderOrder[0] = "changed"
+	if Default().Geocoding.ProviderOrder[0] != Google {
+		t.Error("changing a default configuration changed the next one")
+	}
+}
+
+func TestLoadExampleFile(t *testing.T) {
+	t.Setenv("CONFIG_FILE", filepath.Join("..", "..", "config.example.yaml"))
+	if _, err := Load(); err != nil {
+		t.Fatalf("config.example.yaml is invalid: %v", err)
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+		check   func(t *testing.T, cfg *Config)
+	}{
+		{
+			name: "settings override the defaults",
+			content: `
+server:
+  port: "9000"
+  upstream_timeout: 3s
+cache:
+  max_entries: 500
+  policies:
+    whois:
+      ttl: 48h
+providers:
+  google:
+    api_key: file-google-key
+  nominatim:
+    enabled: false
+geocoding:
+  provider_order: [maptiler, google]
+auth:
+  keys:
+    - name: website
+      key: website-key
+      routes: [/external/geocode]
+      daily_quota: 100
+`,
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Server.Port != "9000" || cfg.Server.UpstreamTimeout != 3*time.Second {
+					t.Errorf("server = %+v, want port 9000 and a 3s upstream timeout", cfg.Server)
+				}
+				// Settings missing from the file keep their default
+				if cfg.Server.ReadTimeout != 15*time.Second || cfg.Cache.Backend != "memory" {
+					t.Errorf("read timeout/backend = %s/%s, want the defaults", cfg.Server.ReadTimeout, cfg.Cache.Backend)
+				}
+				if cfg.Cache.MaxEntries != 500 || *cfg.Cache.Policies["whois"].TTL != 48*time.Hour {
+					t.Errorf("cache = %+v, want 500 entries and a 48h whois TTL", cfg.Cache)
+				}
+				if !cfg.Providers.Enabled(Google) || cfg.Providers.Enabled(Nominatim) {
+					t.Error("want google enabled by its key and nominatim turned off")
+				}
+				if strings.Join(cfg.Geocoding.ProviderOrder, ",") != "maptiler,google" {
+					t.Errorf("provider order = %q, want maptiler,google", cfg.Geocoding.ProviderOrder)
+				}
+				if len(cfg.Auth.Keys) != 1 || cfg.Auth.Keys[0].DailyQuota != 100 {
+					t.Errorf("keys = %+v, want the website key", cfg.Auth.Keys)
+				}
+			},
+		},
+		{
+			name:    "empty file",
+			content: "",
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Server.Port != "8080" {
+					t.Errorf("port = %s, want the default", cfg.Server.Port)
+				}
+			},
+		},
+		{
+			name:    "unknown setting",
+			content: "server:\n  prot: \"9000\"\n",
+			wantErr: "field prot not found",
+		},
+		{
+			name:    "malformed YAML",
+			content: "server: [",
+			wantErr: "error parsing config file",
+		},
+		{
+			name:    "invalid value",
+			content: "server:\n  port: \"0\"\n",
+			wantErr: "invalid server port: 0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CONFIG_FILE", writeFile(t, "config.yaml", tt.content))
+			cfg, err := Load()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error loading config: %v", err)
+			}
+			tt.check(t, cfg)
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		t.Setenv("CONFIG_FILE", filepath.Join(t.TempDir(), "missing.yaml"))
+		if _, err := Load(); err == nil || !strings.Contains(err.Error(), "error reading config file") {
+			t.Errorf("error = %v, want a read error", err)
+		}
+	})
+}
+
+func TestLoadEnvOverridesFile(t *testing.T) {
+	t.Setenv("CONFIG_FILE", writeFile(t, "config.yaml", `
+server:
+  port: "9000"
+  shutdown_timeout: 10s
+providers:
+  google:
+    api_key: file-google-key
+rate_limit:
+  per_ip:
+    rate: 5
+cors:
+  allowed_origins: ["https://file.example.com"]
+`))
+	t.Setenv("PORT", "9100")
+	t.Setenv("GOOGLE_API_KEY", "env-google-key")
+	t.Setenv("GOOGLE_ENABLED", "false")
+	t.Setenv("RATE_LIMIT_PER_IP", "2.5")
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://a.example.com, https://*.b.example.com")
+	t.Setenv("GEOCODING_PROVIDER_ORDER", "Nominatim, MapTiler")
+	t.Setenv("CACHE_POLICY_GEOAPIFY_GEOCODING_NEGATIVE_TTL", "5m")
+	t.Setenv("NOMINATIM_RATE_LIMIT_MAX_WAIT", "500ms")
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "api-key=s%3Dcret,x-team=geo")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("error loading config: %v", err)
+	}
+
+	if cfg.Server.Port != "9100" {
+		t.Errorf("port = %s, want the environment value 9100", cfg.Server.Port)
+	}
+	// Settings without a variable keep the file value
+	if cfg.Server.ShutdownTimeout != 10*time.Second {
+		t.Errorf("shutdown timeout = %s, want the file value 10s", cfg.Server.ShutdownTimeout)
+	}
+	if cfg.Providers.Google.APIKey != "env-google-key" || cfg.Providers.Enabled(Google) {
+		t.Errorf("google = %+v, want the environment key and turned off", cfg.Providers.Google)
+	}
+	if cfg.RateLimit.PerIP.Rate != 2.5 {
+		t.Errorf("per IP rate = %g, want 2.5", cfg.RateLimit.PerIP.Rate)
+	}
+	if got := strings.Join(cfg.CORS.AllowedOrigins, ","); got != "https://a.example.com,https://*.b.example.com" {
+		t.Errorf("allowed origins = %s", got)
+	}
+	if got := strings.Join(cfg.Geocoding.ProviderOrder, ","); got != "nominatim,maptiler" {
+		t.Errorf("provider order = %s, want nominatim,maptiler", got)
+	}
+	if ttl := cfg.Cache.Policies["geoapify_geocoding"].NegativeTTL; ttl == nil || *ttl != 5*time.Minute {
+		t.Errorf("geoapify negative TTL = %v, want 5m", ttl)
+	}
+	// A provider variable only changes its own field of the default limit
+	if limit := cfg.RateLimit.Providers[Nominatim]; limit.Rate != 1 || limit.MaxWait != 500*time.Millisecond {
+		t.Errorf("nominatim rate limit = %+v, want 1/s with a 500ms max wait", limit)
+	}
+	if cfg.Tracing.Headers["api-key"] != "s=cret" || cfg.Tracing.Headers["x-team"] != "geo" {
+		t.Errorf("tracing headers = %v, want the decoded values", cfg.Tracing.Headers)
+	}
+}
+
+func TestLoadEnvErrors(t *testing.T) {
+	t.Setenv("HTTP_READ_TIMEOUT", "fifteen")
+	t.Setenv("CACHE_MAX_ENTRIES", "many")
+	t.Setenv("MAPTILER_ENABLED", "maybe")
+	t.Setenv("CACHE_POLICY_WHOIS_FRESHNESS", "1h")
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "api-key")
+
+	_, err := Load()
+	if err == nil {
+		t.Fatal("loading invalid variables succeeded")
+	}
+	// Every invalid variable is reported at once
+	for _, want := range []string{
+		"invalid HTTP_READ_TIMEOUT: fifteen",
+		"invalid CACHE_MAX_ENTRIES: many",
+		"invalid MAPTILER_ENABLED: maybe",
+		"unknown cache policy variable: CACHE_POLICY_WHOIS_FRESHNESS",
+		"invalid OTEL_EXPORTER_OTLP_HEADERS",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error = %v, want it to contain %q", err, want)
+		}
+	}
+}
+
+func TestLoadKeysFile(t *testing.T) {
+	t.Setenv("CONFIG_FILE", writeFile(t, "config.yaml", `
+auth:
+  keys:
+    - name: website
+      key: website-key
+`))
+	t.Setenv("API_KEYS_FILE", writeFile(t, "keys.yaml", `
+- name: partner
+  key: partner-key
+  monthly_quota: 20000
+`))
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("error loading config: %v", err)
+	}
+	if len(cfg.Auth.Keys) != 2 || cfg.Auth.Keys[0].Name != "website" || cfg.Auth.Keys[1].MonthlyQuota != 20000 {
+		t.Errorf("keys = %+v, want the file key followed by the keys file entry", cfg.Auth.Keys)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	negative := -time.Minute
+
+	tests := []struct {
+		name    string
+		change  func(cfg *Config)
+		wantErr string
+	}{
+		{"port out of range", func(c *Config) { c.Server.Port = "70000" }, "invalid server port"},
+		{"port not a number", func(c *Config) { c.Server.Port = "http" }, "invalid server port"},
+		{"zero timeout", func(c *Config) { c.Server.UpstreamTimeout = 0 }, "invalid server upstream timeout"},
+		{"unknown cache backend", func(c *Config) { c.Cache.Backend = "memcached" }, "unknown cache backend"},
+		{"redis without URL", func(c *Config) { c.Cache.Backend = "redis" }, "requires a redis URL"},
+		{"snapshot without interval", func(c *Config) { c.Cache.SnapshotPath, c.Cache.SnapshotInterval = "cache.json", 0 }, "invalid cache snapshot interval"},
+		{"negative max entries", func(c *Config) { c.Cache.MaxEntries = -1 }, "invalid cache max entries"},
+		{"negative policy duration", func(c *Config) {
+			c.Cache.Policies = map[string]CachePolicyConfig{"whois": {StaleTTL: &negative}}
+		}, "invalid cache policy for whois"},
+		{"unknown provider in order", func(c *Config) { c.Geocoding.ProviderOrder = []string{"bing"} }, "unknown geocoding provider in provider order: bing"},
+		{"no batch workers", func(c *Config) { c.Geocoding.BatchWorkers = 0 }, "invalid geocoding batch workers"},
+		{"negative rate", func(c *Config) { c.RateLimit.PerKey.Rate = -1 }, "invalid per key rate limit"},
+		{"rate limit for unknown provider", func(c *Config) {
+			c.RateLimit.Providers = map[string]ProviderRateConfig{"bing": {}}
+		}, "rate limit for unknown provider: bing"},
+		{"credentials with any origin", func(c *Config) { c.CORS.AllowCredentials = true }, "cannot be allowed"},
+		{"origin without scheme", func(c *Config) { c.CORS.AllowedOrigins = []string{"example.com"} }, "expected a scheme"},
+		{"wildcard inside origin", func(c *Config) { c.CORS.AllowedOrigins = []string{"https://app.*.example.com"} }, "only allowed before the domain"},
+		{"unknown log format", func(c *Config) { c.Log.Format = "xml" }, "unknown log format"},
+		{"unknown log level", func(c *Config) { c.Log.Level = "verbose" }, "unknown log level"},
+		{"tracing endpoint without scheme", func(c *Config) { c.Tracing.Endpoint = "localhost:4318" }, "invalid tracing endpoint"},
+		{"sample ratio above 1", func(c *Config) { c.Tracing.SampleRatio = 1.5 }, "invalid tracing sample ratio"},
+		{"API key without name", func(c *Config) { c.Auth.Keys = []APIKeyConfig{{Key: "k"}} }, "API key 0 has no name"},
+		{"duplicate API key", func(c *Config) {
+			c.Auth.Keys = []APIKeyConfig{{Name: "a", Key: "k"}, {Name: "b", Key: "k"}}
+		}, "reuses the key of another client"},
+		{"relative API key route", func(c *Config) {
+			c.Auth.Keys = []APIKeyConfig{{Name: "a", Key: "k", Routes: []string{"external/geocode"}}}
+		}, "invalid route for API key a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			tt.change(cfg)
+			err := cfg.Validate()
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("every error is reported", func(t *testing.T) {
+		cfg := Default()
+		cfg.Server.Port = "0"
+		cfg.Log.Format = "xml"
+		err := cfg.Validate()
+		if err == nil || !strings.Contains(err.Error(), "invalid server port") || !strings.Contains(err.Error(), "unknown log format") {
+			t.Errorf("error = %v, want both problems", err)
+		}
+	})
+}
+
+func TestLoadTrustsRailwayProxy(t *testing.T) {
+	tests := []struct {
+		name    string
+		railway string
+		env     string
+		want    bool
+	}{
+		{name: "outside Railway", want: false},
+		{name: "on Railway", railway: "production", want: true},
+		{name: "on Railway, turned off", railway: "production", env: "false", want: false},
+		{name: "outside Railway, turned on", env: "true", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("RAILWAY_ENVIRONMENT", tt.railway)
+			t.Setenv("RATE_LIMIT_TRUST_PROXY", tt.env)
+			cfg, err := Load()
+			if err != nil {
+				t.Fatalf("error loading config: %v", err)
+			}
+			if cfg.RateLimit.TrustProxy != tt.want {
+				t.Errorf("trust proxy = %t, want %t", cfg.RateLimit.TrustProxy, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package cors
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/igorsilvestre/simple-go-server/pkg/config"
+	"github.com/igorsilvestre/simple-go-server/pkg/problem"
+)
+
+// routeMethods are the methods checked against the router to answer preflight requests
+var routeMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// originPattern matches an allowed origin. Wildcard patterns such as "https://*.example.com"
+// match every subdomain, but not the domain itself.
+type originPattern struct {
+	prefix, suffix string
+	wildcard       bool
+}
+
+// matches reports whether a lowercase origin matches the pattern
+func (p originPattern) matches(origin string) bool {
+	if !p.wildcard {
+		return origin == p.prefix
+	}
+	if len(origin) <= len(p.prefix)+len(p.suffix) || !strings.HasPrefix(origin, p.prefix) || !strings.HasSuffix(origin, p.suffix) {
+		return false
+	}
+	// The subdomain part cannot hold a port or a path
+	subdomain := origin[len(p.prefix) : len(origin)-len(p.suffix)]
+	return !strings.ContainsAny(subdomain, ":/")
+}
+
+// Policy answers preflight requests and adds the CORS headers to the responses of a router
+type Policy struct {
+	anyOrigin   bool
+	origins     []originPattern
+	credentials bool
+	headers     string
+	exposed     string
+	maxAge      string
+}
+
+// New creates the policy described by the configuration
+func New(cfg config.CORSConfig) *Policy {
+	p := &Policy{
+		credentials: cfg.AllowCredentials,
+		headers:     strings.Join(cfg.AllowedHeaders, ", "),
+		exposed:     strings.Join(cfg.ExposedHeaders, ", "),
+		maxAge:      strconv.Itoa(int(cfg.MaxAge.Seconds())),
+	}
+	for _, origin := range cfg.AllowedOrigins {
+		origin = strings.ToLower(strings.TrimSuffix(origin, "/"))
+		if origin == "*" {
+			p.anyOrigin = true
+			continue
+		}
+		if scheme, host, ok := strings.Cut(origin, "://*."); ok {
+			p.origins = append(p.origins, originPattern{prefix: scheme + "://", suffix: "." + host, wildcard: true})
+			continue
+		}
+		p.origins = append(p.origins, originPattern{prefix: origin})
+	}
+	return p
+}
+
+// allows reports whether an origin may call the API
+func (p *Policy) allows(origin string) bool {
+	if p.anyOrigin {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	for _, pattern := range p.origins {
+		if pattern.matches(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowOrigin sets the allowed origin of the response and reports whether the origin is allowed
+func (p *Policy) allowOrigin(w http.ResponseWriter, origin string) bool {
+	if !p.allows(origin) {
+		return false
+	}
+
+	if p.anyOrigin && !p.credentials {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+	if p.credentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+	return true
+}
+
+// allowedMethods returns the methods the routes of the router accept for the request path
+func allowedMethods(router *mux.Router, r *http.Request) []string {
+	var methods []string
+	for _, method := range routeMethods {
+		probe := r.Clone(r.Context())
+		probe.Method = method
+
+		var match mux.RouteMatch
+		if router.Match(probe, &match) && match.MatchErr == nil {
+			methods = append(methods, method)
+		}
+	}
+	return methods
+}
+
+// Handler wraps the router. OPTIONS requests are answered here with the methods the
+// matching routes accept, and 404 when no route matches the path.
+func (p *Policy) Handler(router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+
+		// The answer depends on the origin, caches must not share it between origins
+		w.Header().Add("Vary", "Origin")
+
+		if r.Method != http.MethodOptions {
+			if origin != "" && p.allowOrigin(w, origin) && p.exposed != "" {
+				w.Header().Set("Access-Control-Expose-Headers", p.exposed)
+			}
+			router.ServeHTTP(w, r)
+			return
+		}
+
+		methods := allowedMethods(router, r)
+		if len(methods) == 0 {
+			problem.Write(w, r, problem.New(http.StatusNotFound, problem.NotFound, "No route matches the request path"))
+			return
+		}
+		w.Header().Set("Allow", strings.Join(append(methods, http.MethodOptions), ", "))
+
+		// A preflight request names the method of the actual request
+		if origin != "" && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			if p.allowOrigin(w, origin) {
+				w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+				w.Header().Set("Access-Control-Allow-Headers", p.headers)
+				w.Header().Set("Access-Control-Max-Age", p.maxAge)
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,135 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/igorsilvestre/simple-go-server/pkg/config"
+	"github.com/igorsilvestre/simple-go-server/pkg/problem"
+)
+
+func newTestHandler(cfg config.CORSConfig) http.Handler {
+	r := mux.NewRouter()
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	r.HandleFunc("/external/geocode", ok).Methods("GET")
+	r.HandleFunc("/external/geocode/batch", ok).Methods("POST")
+	r.HandleFunc("/admin/cache", ok).Methods("GET")
+	r.HandleFunc("/admin/cache", ok).Methods("DELETE")
+	return New(cfg).Handler(r)
+}
+
+func TestHandler(t *testing.T) {
+	dashboards := config.CORSConfig{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.example.org"},
+		AllowCredentials: true,
+		AllowedHeaders:   []string{"Authorization", "X-API-Key"},
+		ExposedHeaders:   []string{"Retry-After"},
+		MaxAge:           10 * time.Minute,
+	}
+	wildcard := config.CORSConfig{AllowedOrigins: []string{"*"}, MaxAge: time.Minute}
+
+	tests := []struct {
+		name          string
+		cfg           config.CORSConfig
+		method        string
+		path          string
+		origin        string
+		requestMethod string
+		wantStatus    int
+		// wantHeaders lists expected response headers, an empty value means absent
+		wantHeaders map[string]string
+	}{
+		{
+			name: "preflight reflects the route methods", cfg: dashboards,
+			method: "OPTIONS", path: "/admin/cache", origin: "https://app.example.com", requestMethod: "DELETE",
+			wantStatus: http.StatusNoContent,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin":      "https://app.example.com",
+				"Access-Control-Allow-Methods":     "GET, DELETE",
+				"Access-Control-Allow-Headers":     "Authorization, X-API-Key",
+				"Access-Control-Allow-Credentials": "true",
+				"Access-Control-Max-Age":           "600",
+				"Allow":                            "GET, DELETE, OPTIONS",
+			},
+		},
+		{
+			name: "wildcard subdomain", cfg: dashboards,
+			method: "OPTIONS", path: "/external/geocode/batch", origin: "https://ops.eu.example.org", requestMethod: "POST",
+			wantStatus: http.StatusNoContent,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin":  "https://ops.eu.example.org",
+				"Access-Control-Allow-Methods": "POST",
+			},
+		},
+		{
+			name: "wildcard does not match the domain itself", cfg: dashboards,
+			method: "OPTIONS", path: "/external/geocode", origin: "https://example.org", requestMethod: "GET",
+			wantStatus:  http.StatusNoContent,
+			wantHeaders: map[string]string{"Access-Control-Allow-Origin": "", "Access-Control-Allow-Methods": ""},
+		},
+		{
+			name: "origin not allowed", cfg: dashboards,
+			method: "GET", path: "/external/geocode", origin: "https://evil.example.com",
+			wantStatus:  http.StatusOK,
+			wantHeaders: map[string]string{"Access-Control-Allow-Origin": "", "Vary": "Origin"},
+		},
+		{
+			name: "actual request", cfg: dashboards,
+			method: "GET", path: "/external/geocode", origin: "https://APP.example.com",
+			wantStatus: http.StatusOK,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin":   "https://APP.example.com",
+				"Access-Control-Expose-Headers": "Retry-After",
+				"Vary":                          "Origin",
+			},
+		},
+		{
+			name: "any origin without credentials", cfg: wildcard,
+			method: "OPTIONS", path: "/external/geocode", origin: "https://anything.test", requestMethod: "GET",
+			wantStatus: http.StatusNoContent,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin":      "*",
+				"Access-Control-Allow-Credentials": "",
+				"Access-Control-Max-Age":           "60",
+			},
+		},
+		{
+			name: "unknown route", cfg: wildcard,
+			method: "OPTIONS", path: "/external/nothing", origin: "https://anything.test", requestMethod: "GET",
+			wantStatus:  http.StatusNotFound,
+			wantHeaders: map[string]string{"Access-Control-Allow-Origin": "", "Content-Type": problem.ContentType},
+		},
+		{
+			name: "plain OPTIONS lists the methods", cfg: wildcard,
+			method: "OPTIONS", path: "/external/geocode",
+			wantStatus:  http.StatusNoContent,
+			wantHeaders: map[string]string{"Allow": "GET, OPTIONS", "Access-Control-Allow-Origin": ""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.requestMethod != "" {
+				req.Header.Set("Access-Control-Request-Method", tt.requestMethod)
+			}
+			w := httptest.NewRecorder()
+			newTestHandler(tt.cfg).ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			for name, want := range tt.wantHeaders {
+				if got := w.Header().Get(name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
@@ -1,16 +1,17 @@
 package external
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
-	"os"
 	"strings"
-	"time"
 
 	"github.com/google/uuid"
+	"github.com/igorsilvestre/simple-go-server/pkg/config"
+	"github.com/igorsilvestre/simple-go-server/pkg/problem"
 )
 
 var stateAbbreviations = map[string]string{
@@ -44,22 +45,10 @@ var stateAbbreviations = map[string]string{
 }
 
 // AddressAutocompleteHandler handles autocomplete requests and returns suggestions
-func AddressAutocompleteHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Service) AddressAutocompleteHandler(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query().Get("q")
 	if query == "" {
-		http.Error(w, "Missing 'q' query parameter", http.StatusBadRequest)
-		return
-	}
-
-	// Create a cache key based on the query
-	cacheKey := "address_autocomplete:" + query
-
-	// Check if the data is in the cache
-	if cachedData, found := GlobalCache.Get(cacheKey); found {
-		// Use the cached data
-		suggestions := cachedData.(*AutocompleteResponse)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(suggestions)
+		problem.Write(w, r, problem.Missing("q"))
 		return
 	}
 
@@ -68,20 +57,24 @@ func AddressAutocompleteHandler(w http.ResponseWriter, r *http.Request) {
 		sessionToken = generateSessionToken()
 	}
 
-	suggestions, err := getAutocompleteSuggestions(query, sessionToken)
+	// Use the cached data or fetch it once for every concurrent request
+	suggestions, err := s.addressAutocompleteCache.Fetch(r.Context(), normalizeQueryKey(query), func(ctx context.Context) (*AutocompleteResponse, error) {
+		suggestions, err := s.getAutocompleteSuggestions(ctx, query, sessionToken)
+		if err != nil {
+			return nil, err
+		}
+
+		// Process each prediction to abbreviate the state if needed
+		for i, prediction := range suggestions.Predictions {
+			suggestions.Predictions[i].Description = abbreviateState(prediction.Description)
+		}
+		return suggestions, nil
+	})
 	if err != nil {
-		http.Error(w, "Error fetching autocomplete suggestions: "+err.Error(), http.StatusInternalServerError)
+		problem.Write(w, r, upstreamProblem(config.Google, err))
 		return
 	}
 
-	// Process each prediction to abbreviate the state if needed
-	for i, prediction := range suggestions.Predictions {
-		suggestions.Predictions[i].Description = abbreviateState(prediction.Description)
-	}
-
-	// Store the data in the cache (24 hours expiration)
-	GlobalCache.Set(cacheKey, suggestions, 24*time.Hour)
-
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(suggestions)
 }
@@ -92,13 +85,13 @@ func generateSessionToken() string {
 }
 
 // getAutocompleteSuggestions fetches suggestions from the Google Places Autocomplete API
-func getAutocompleteSuggestions(input, sessionToken string) (*AutocompleteResponse, error) {
-	apiKey := os.Getenv("GOOGLE_API_KEY")
+func (s *Service) getAutocompleteSuggestions(ctx context.Context, input, sessionToken string) (*AutocompleteResponse, error) {
+	apiKey := s.config.Providers.APIKey(config.Google)
 	if apiKey == "" {
 		return nil, fmt.Errorf("Google API key is not set")
 	}
 
-	endpoint := "https://maps.googleapis.com/maps/api/place/autocomplete/json"
+	endpoint := s.urls.Google + "/place/autocomplete/json"
 	params := url.Values{}
 	params.Add("input", input)
 	params.Add("key", apiKey)
@@ -107,7 +100,11 @@ func getAutocompleteSuggestions(input, sessionToken string) (*AutocompleteRespon
 
 	apiURL := endpoint + "?" + params.Encode()
 
-	resp, err := http.Get(apiURL)
+	req, err := s.newProviderRequest(ctx, config.Google, http.MethodGet, apiURL)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -164,6 +161,11 @@ type AutocompleteResponse struct {
 	ErrorMessage string       `json:"error_message,omitempty"`
 }
 
+// noResults reports whether Google found no suggestions for the input
+func (r *AutocompleteResponse) noResults() bool {
+	return r != nil && r.Status == "ZERO_RESULTS"
+}
+
 // Prediction represents a single prediction in the autocomplete response
 type Prediction struct {
 	Description string `json:"description"`
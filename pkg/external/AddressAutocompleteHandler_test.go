@@ -0,0 +1,105 @@
+package external
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/igorsilvestre/simple-go-server/pkg/config"
+)
+
+func TestAddressAutocompleteHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		fixture    fixture
+		target     string
+		requests   int
+		wantStatus int
+		wantBody   string
+		wantCalls  int
+	}{
+		{
+			name:       "success abbreviates the state and is cached",
+			fixture:    recorded("google/autocomplete_ok.json"),
+			target:     "/external/autocomplete-address?q=Avenida+Paulista+1578",
+			requests:   2,
+			wantStatus: http.StatusOK,
+			wantBody:   "São Paulo - SP, Brasil",
+			wantCalls:  1,
+		},
+		{
+			name:       "zero results are cached",
+			fixture:    recorded("google/autocomplete_zero_results.json"),
+			target:     "/external/autocomplete-address?q=zzzz",
+			requests:   2,
+			wantStatus: http.StatusOK,
+			wantBody:   `"predictions":[]`,
+			wantCalls:  1,
+		},
+		{
+			name:       "non-200",
+			fixture:    inline(http.StatusForbidden, "forbidden"),
+			target:     "/external/autocomplete-address?q=Avenida+Paulista",
+			requests:   2,
+			wantStatus: http.StatusBadGateway,
+			wantBody:   `"code":"upstream_unavailable"`,
+			wantCalls:  2,
+		},
+		{
+			name:       "malformed JSON",
+			fixture:    inline(http.StatusOK, `{"predictions": `),
+			target:     "/external/autocomplete-address?q=Avenida+Paulista",
+			requests:   1,
+			wantStatus: http.StatusBadGateway,
+			wantBody:   `"code":"upstream_unavailable"`,
+			wantCalls:  1,
+		},
+		{
+			name:       "missing query",
+			fixture:    recorded("google/autocomplete_ok.json"),
+			target:     "/external/autocomplete-address",
+			requests:   1,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `"code":"missing_parameter"`,
+			wantCalls:  0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			upstream.handle(config.Google, "/place/autocomplete/json", tt.fixture)
+			router := newTestRouter(t, testConfig(), upstream.urls())
+
+			for i := 0; i < tt.requests; i++ {
+				w := serve(router, http.MethodGet, tt.target, "")
+				if w.Code != tt.wantStatus {
+					t.Fatalf("request %d: status = %d, want %d: %s", i, w.Code, tt.wantStatus, w.Body)
+				}
+				if !strings.Contains(w.Body.String(), tt.wantBody) {
+					t.Errorf("request %d: body = %s, want it to contain %s", i, w.Body, tt.wantBody)
+				}
+			}
+			if calls := upstream.callCount(config.Google); calls != tt.wantCalls {
+				t.Errorf("upstream calls = %d, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestAddressAutocompletePrefixesAreNotExpanded(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	upstream.handle(config.Google, "/place/autocomplete/json", recorded("google/autocomplete_ok.json"))
+	router := newTestRouter(t, testConfig(), upstream.urls())
+
+	// Each prefix is a different query, while case and accents still share an entry
+	for _, query := range []string{"Al", "Alameda", "Est", "Estrada", "ESTRADA", "São", "sao"} {
+		if w := serve(router, http.MethodGet, "/external/autocomplete-address?q="+url.QueryEscape(query), ""); w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d: %s", query, w.Code, w.Body)
+		}
+	}
+	if calls := upstream.callCount(config.Google); calls != 5 {
+		t.Errorf("upstream calls = %d, want 5", calls)
+	}
+}
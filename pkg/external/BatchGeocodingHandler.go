@@ -0,0 +1,350 @@
+package external
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/igorsilvestre/simple-go-server/pkg/problem"
+	"github.com/igorsilvestre/simple-go-server/pkg/ratelimit"
+)
+
+const (
+	// maxBatchWorkers caps the worker pool so a single batch cannot exhaust provider quotas
+	maxBatchWorkers = 32
+	// maxBatchSize is the largest number of addresses accepted in a single batch
+	maxBatchSize = 10000
+	// batchStreamThreshold is the batch size above which results are always streamed as NDJSON
+	batchStreamThreshold = 500
+	// maxBatchBodySize limits the size of the uploaded JSON or CSV document
+	maxBatchBodySize = 10 << 20
+)
+
+// Batch row statuses
+const (
+	BatchStatusOK        = "ok"
+	BatchStatusNoResults = "no_results"
+	BatchStatusError     = "error"
+)
+
+// BatchGeocodeRow is the result of geocoding a single address from a batch
+type BatchGeocodeRow struct {
+	Index    int               `json:"index"`
+	Address  string            `json:"address"`
+	Status   string            `json:"status"`
+	Provider string            `json:"provider,omitempty"`
+	Results  []GeocodeResult   `json:"results"`
+	Failures []ProviderFailure `json:"failures,omitempty"`
+	Error    string            `json:"error,omitempty"`
+}
+
+// BatchGeocodeResponse is the non-streamed response of the batch endpoint
+type BatchGeocodeResponse struct {
+	Results []BatchGeocodeRow `json:"results"`
+}
+
+// batchWorkerCount returns the worker pool size for a request
+func (s *Service) batchWorkerCount(r *http.Request) (int, error) {
+	value := r.URL.Query().Get("workers")
+	if value == "" {
+		return min(s.config.Geocoding.BatchWorkers, maxBatchWorkers), nil
+	}
+
+	workers, err := strconv.Atoi(value)
+	if err != nil || workers < 1 {
+		return 0, fmt.Errorf("Invalid worker count: %s", value)
+	}
+	if workers > maxBatchWorkers {
+		workers = maxBatchWorkers
+	}
+	return workers, nil
+}
+
+// readBatchAddresses extracts the addresses from a JSON array, a CSV body or a multipart CSV upload
+func readBatchAddresses(r *http.Request) ([]string, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	var body io.Reader = r.Body
+	if mediaType == "multipart/form-data" {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			return nil, fmt.Errorf("missing 'file' upload: %w", err)
+		}
+		defer file.Close()
+		body = file
+		mediaType = "text/csv"
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading request body: %w", err)
+	}
+
+	switch mediaType {
+	case "text/csv", "application/csv":
+		return parseBatchCSV(data)
+	default:
+		return parseBatchJSON(data)
+	}
+}
+
+// parseBatchJSON accepts either an array of strings or an array of {"address": "..."} objects
+func parseBatchJSON(data []byte) ([]string, error) {
+	var addresses []string
+	if err := json.Unmarshal(data, &addresses); err == nil {
+		return addresses, nil
+	}
+
+	var items []struct {
+		Address string `json:"address"`
+	}
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, fmt.Errorf("invalid JSON: expected an array of addresses")
+	}
+
+	addresses = make([]string, len(items))
+	for i, item := range items {
+		addresses[i] = item.Address
+	}
+	return addresses, nil
+}
+
+// parseBatchCSV reads the "address" column of a CSV document, or its first column when there is no header
+func parseBatchCSV(data []byte) ([]string, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %v", err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	column := 0
+	for i, header := range records[0] {
+		if strings.EqualFold(strings.TrimSpace(header), "address") {
+			column = i
+			records = records[1:]
+			break
+		}
+	}
+
+	addresses := make([]string, 0, len(records))
+	for _, record := range records {
+		if column < len(record) {
+			addresses = append(addresses, record[column])
+		} else {
+			addresses = append(addresses, "")
+		}
+	}
+	return addresses, nil
+}
+
+// geocodeBatchRow geocodes a single address with a selected provider or the fallback chain
+func (s *Service) geocodeBatchRow(ctx context.Context, index int, address string, geocoder Geocoder, providers []string) BatchGeocodeRow {
+	row := BatchGeocodeRow{Index: index, Address: address, Results: []GeocodeResult{}}
+
+	address = strings.TrimSpace(address)
+	if address == "" {
+		row.Status = BatchStatusError
+		row.Error = "empty address"
+		return row
+	}
+
+	var err error
+	if geocoder != nil {
+		var response *GeocodeResponse
+		row.Provider = geocoder.Name()
+		if response, err = s.cachedGeocode(ctx, geocoder, address); err == nil {
+			row.Results = response.Results
+		}
+	} else {
+		var response *FallbackGeocodeResponse
+		response, err = s.geocodeWithFallback(ctx, address, providers)
+		row.Provider = response.Provider
+		row.Results = response.Results
+		row.Failures = response.Failures
+	}
+
+	switch {
+	case err == nil:
+		row.Status = BatchStatusOK
+	case errors.Is(err, ErrNoResults):
+		row.Status = BatchStatusNoResults
+	default:
+		row.Status = BatchStatusError
+		row.Error = upstreamDetail(err)
+	}
+	return row
+}
+
+// cancelledBatchRow is the result of a row that was not geocoded because the batch was cancelled
+func cancelledBatchRow(index int, address string, err error) BatchGeocodeRow {
+	return BatchGeocodeRow{
+		Index:   index,
+		Address: address,
+		Status:  BatchStatusError,
+		Results: []GeocodeResult{},
+		Error:   err.Error(),
+	}
+}
+
+// geocodeBatch fans the addresses out to a worker pool and returns one channel per row,
+// so callers can consume the results in input order as soon as each one is ready.
+// No new rows are dispatched once the context is cancelled, the remaining rows are
+// then answered with a cancellation error.
+func (s *Service) geocodeBatch(ctx context.Context, addresses []string, workers int, geocoder Geocoder, providers []string) []chan BatchGeocodeRow {
+	rows := make([]chan BatchGeocodeRow, len(addresses))
+	for i := range rows {
+		rows[i] = make(chan BatchGeocodeRow, 1)
+	}
+
+	jobs := make(chan int)
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := range jobs {
+				rows[i] <- s.geocodeBatchRow(ctx, i, addresses[i], geocoder, providers)
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobs)
+		for i := range addresses {
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				// Every row the readers wait on must be answered, so the rows that were
+				// never dispatched are reported as cancelled
+				for ; i < len(addresses); i++ {
+					rows[i] <- cancelledBatchRow(i, addresses[i], ctx.Err())
+				}
+				return
+			}
+		}
+	}()
+
+	return rows
+}
+
+// wantsNDJSON reports whether the batch results should be streamed
+func wantsNDJSON(r *http.Request, size int) bool {
+	if size > batchStreamThreshold || r.URL.Query().Get("stream") == "true" {
+		return true
+	}
+	return strings.Contains(r.Header.Get("Accept"), "application/x-ndjson")
+}
+
+// BatchGeocodingHandler geocodes a JSON array or CSV upload of addresses with bounded concurrency
+func (s *Service) BatchGeocodingHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBatchBodySize)
+
+	addresses, err := readBatchAddresses(r)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			problem.Write(w, r, problem.Newf(http.StatusRequestEntityTooLarge, problem.PayloadTooLarge,
+				"request body exceeds %d bytes", tooLarge.Limit))
+			return
+		}
+		problem.Write(w, r, problem.New(http.StatusBadRequest, problem.InvalidBody, err.Error()))
+		return
+	}
+	if len(addresses) == 0 {
+		problem.Write(w, r, problem.New(http.StatusBadRequest, problem.InvalidBody, "no addresses provided"))
+		return
+	}
+	if len(addresses) > maxBatchSize {
+		problem.Write(w, r, problem.Newf(http.StatusRequestEntityTooLarge, problem.PayloadTooLarge,
+			"batch exceeds %d addresses", maxBatchSize))
+		return
+	}
+
+	workers, err := s.batchWorkerCount(r)
+	if err != nil {
+		problem.Write(w, r, problem.Invalid("workers", err.Error()))
+		return
+	}
+
+	// A selected provider is used directly, otherwise every row goes through the fallback chain
+	var geocoder Geocoder
+	var providers []string
+	if provider := r.URL.Query().Get("provider"); provider != "" {
+		if geocoder, err = s.lookupGeocoder(provider); err != nil {
+			problem.Write(w, r, providerProblem(provider, err))
+			return
+		}
+	} else {
+		var p *problem.Problem
+		if providers, p = s.requestedProviders(r); p != nil {
+			problem.Write(w, r, p)
+			return
+		}
+	}
+
+	// The middlewares charged the request as one lookup; every other row is charged to
+	// the same rate limits and quotas before any of them is geocoded
+	if !ratelimit.Charge(w, r, len(addresses)-1) {
+		return
+	}
+
+	// Large batches legitimately outlive the server write timeout, the request context
+	// still stops the work when the client goes away
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		slog.WarnContext(r.Context(), "Error clearing write deadline", "error", err.Error())
+	}
+
+	ctx := r.Context()
+	rows := s.geocodeBatch(ctx, addresses, workers, geocoder, providers)
+
+	// The readers stop waiting when the client goes away: a row in flight may be a
+	// coalesced upstream call that outlives the request until the upstream timeout
+	if !wantsNDJSON(r, len(addresses)) {
+		response := BatchGeocodeResponse{Results: make([]BatchGeocodeRow, len(rows))}
+		for i, row := range rows {
+			select {
+			case response.Results[i] = <-row:
+			case <-ctx.Done():
+				slog.WarnContext(ctx, "Batch cancelled by the client", "rows", i, "total", len(rows))
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	// Stream one JSON object per line, in input order, flushing as rows complete
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	for i, row := range rows {
+		var result BatchGeocodeRow
+		select {
+		case result = <-row:
+		case <-ctx.Done():
+			slog.WarnContext(ctx, "Batch cancelled by the client", "rows", i, "total", len(rows))
+			return
+		}
+		if err := encoder.Encode(result); err != nil {
+			// The client went away, the request context stops the dispatch of new rows
+			slog.WarnContext(ctx, "Error streaming batch results", "error", err.Error())
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
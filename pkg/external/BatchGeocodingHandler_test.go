@@ -0,0 +1,200 @@
+package external
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/igorsilvestre/simple-go-server/pkg/config"
+)
+
+// batchUpstream answers Google requests per address: a match, nothing, or a failure
+func batchUpstream(t *testing.T) *fakeUpstream {
+	upstream := newFakeUpstream(t)
+	upstream.handle(config.Google, "/geocode/json?address=Avenida Paulista, 1578", recorded("google/geocode_ok.json"))
+	upstream.handle(config.Google, "/geocode/json?address=zzzz nowhere zzzz", recorded("google/geocode_zero_results.json"))
+	upstream.handle(config.Google, "/geocode/json?address=Rua Quebrada", inline(http.StatusBadGateway, "bad gateway"))
+	upstream.handle(config.Geoapify, "/geocode/search?text=Rua Quebrada", recorded("geoapify/search_ok.json"))
+	return upstream
+}
+
+func TestBatchGeocodingHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		target      string
+		contentType string
+		body        string
+		wantStatus  int
+		// wantRows lists the status and the provider of every row, in input order
+		wantRows []string
+	}{
+		{
+			name:       "selected provider",
+			target:     "/external/geocode/batch?provider=google",
+			body:       `["Avenida Paulista, 1578", "zzzz nowhere zzzz", "Rua Quebrada", " "]`,
+			wantStatus: http.StatusOK,
+			wantRows:   []string{"ok google", "no_results google", "error google", "error "},
+		},
+		{
+			name:       "fallback chain",
+			target:     "/external/geocode/batch?providers=google,geoapify",
+			body:       `[{"address": "Avenida Paulista, 1578"}, {"address": "Rua Quebrada"}]`,
+			wantStatus: http.StatusOK,
+			wantRows:   []string{"ok google", "ok geoapify"},
+		},
+		{
+			name:        "CSV upload",
+			target:      "/external/geocode/batch?provider=google",
+			contentType: "text/csv",
+			body:        "id,address\n1,\"Avenida Paulista, 1578\"\n2,zzzz nowhere zzzz\n",
+			wantStatus:  http.StatusOK,
+			wantRows:    []string{"ok google", "no_results google"},
+		},
+		{
+			name:       "empty batch",
+			target:     "/external/geocode/batch",
+			body:       `[]`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "unknown provider",
+			target:     "/external/geocode/batch?provider=bing",
+			body:       `["Avenida Paulista, 1578"]`,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := newTestRouter(t, testConfig(), batchUpstream(t).urls())
+
+			req := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			response := decodeJSON[BatchGeocodeResponse](t, w)
+			rows := []string{}
+			for i, row := range response.Results {
+				if row.Index != i {
+					t.Errorf("row %d has index %d", i, row.Index)
+				}
+				rows = append(rows, row.Status+" "+row.Provider)
+			}
+			if !equalStrings(rows, tt.wantRows) {
+				t.Errorf("rows = %q, want %q", rows, tt.wantRows)
+			}
+		})
+	}
+}
+
+func TestBatchGeocodingHandlerStream(t *testing.T) {
+	upstream := batchUpstream(t)
+	router := newTestRouter(t, testConfig(), upstream.urls())
+
+	// The repeated address is geocoded once and served from the cache afterwards
+	body := `["Avenida Paulista, 1578", "zzzz nowhere zzzz", "avenida paulista,  1578"]`
+	w := serve(router, http.MethodPost, "/external/geocode/batch?provider=google&stream=true&workers=1", body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	if contentType := w.Header().Get("Content-Type"); contentType != "application/x-ndjson" {
+		t.Errorf("content type = %q, want application/x-ndjson", contentType)
+	}
+
+	var statuses []string
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		var row BatchGeocodeRow
+		if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			t.Fatalf("error decoding row %q: %v", scanner.Text(), err)
+		}
+		statuses = append(statuses, row.Status)
+	}
+	if want := []string{"ok", "no_results", "ok"}; !equalStrings(statuses, want) {
+		t.Errorf("statuses = %v, want %v", statuses, want)
+	}
+	if calls := upstream.callCount(config.Google); calls != 2 {
+		t.Errorf("upstream calls = %d, want 2", calls)
+	}
+}
+
+func TestBatchGeocodingHandlerCancelled(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		t.Run(fmt.Sprintf("stream=%t", stream), func(t *testing.T) {
+			// Google hangs until released, so the batch is cancelled while the first row is
+			// in flight and the other rows are still waiting for the only worker
+			started, release := make(chan struct{}), make(chan struct{})
+			var once sync.Once
+			google := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				once.Do(func() { close(started) })
+				<-release
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}))
+			t.Cleanup(google.Close)
+
+			urls := newFakeUpstream(t).urls()
+			urls.Google = google.URL
+			router := newTestRouter(t, testConfig(), urls)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			body := `["Avenida Paulista, 1578", "Rua Augusta, 100", "Rua Oscar Freire, 200"]`
+			target := fmt.Sprintf("/external/geocode/batch?provider=google&workers=1&stream=%t", stream)
+			req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body)).WithContext(ctx)
+			w := httptest.NewRecorder()
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				router.ServeHTTP(w, req)
+			}()
+
+			<-started
+			cancel()
+			// The upstream timeout is 5s, the handler must not wait for the row in flight
+			select {
+			case <-done:
+			case <-time.After(2 * time.Second):
+				t.Fatal("handler did not return after the request was cancelled")
+			}
+
+			// Let the coalesced call finish before the test cache is torn down
+			close(release)
+			waitForFlights(t)
+		})
+	}
+}
+
+// waitForFlights waits until no coalesced upstream call is in flight
+func waitForFlights(t *testing.T) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		upstreamFlights.mu.Lock()
+		inFlight := len(upstreamFlights.calls)
+		upstreamFlights.mu.Unlock()
+		if inFlight == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d upstream calls still in flight", inFlight)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,216 @@
+package external
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/igorsilvestre/simple-go-server/pkg/problem"
+)
+
+const (
+	// defaultConsensusRadius is the distance in metres under which two providers are considered to agree
+	defaultConsensusRadius = 500.0
+	// earthRadius is the mean radius of the earth in metres
+	earthRadius = 6371008.8
+)
+
+// Coordinate is a latitude/longitude pair
+type Coordinate struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+// ConsensusOutlier is a provider whose answer is outside the consensus cluster
+type ConsensusOutlier struct {
+	Provider  string     `json:"provider"`
+	Location  Coordinate `json:"location"`
+	DistanceM float64    `json:"distance_m"`
+}
+
+// ConsensusGeocodeResponse is the response returned by the consensus geocoding mode
+type ConsensusGeocodeResponse struct {
+	Consensus    *Coordinate        `json:"consensus"`
+	Disagreement float64            `json:"disagreement"`
+	MaxDistanceM float64            `json:"max_distance_m"`
+	RadiusM      float64            `json:"radius_m"`
+	Agreeing     []string           `json:"agreeing"`
+	Outliers     []ConsensusOutlier `json:"outliers"`
+	Results      []GeocodeResult    `json:"results"`
+	Failures     []ProviderFailure  `json:"failures"`
+}
+
+// haversineDistance returns the great-circle distance in metres between two coordinates
+func haversineDistance(a, b Coordinate) float64 {
+	toRadians := func(degrees float64) float64 { return degrees * math.Pi / 180 }
+
+	dLat := toRadians(b.Lat - a.Lat)
+	dLon := toRadians(b.Lon - a.Lon)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRadians(a.Lat))*math.Cos(toRadians(b.Lat))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// queryProviders geocodes an address with every provider in parallel and keeps the best result of each.
+// It also reports whether any provider failed for a reason other than finding nothing.
+func (s *Service) queryProviders(ctx context.Context, address string, providers []string) ([]GeocodeResult, []ProviderFailure, bool) {
+	results := make([]*GeocodeResult, len(providers))
+	failures := make([]*ProviderFailure, len(providers))
+	errs := make([]error, len(providers))
+
+	var wg sync.WaitGroup
+	for i, name := range providers {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+
+			geocoder, err := s.lookupGeocoder(name)
+			if err == nil {
+				var response *GeocodeResponse
+				if response, err = s.cachedGeocode(ctx, geocoder, address); err == nil {
+					results[i] = &response.Results[0]
+					return
+				}
+			}
+			errs[i] = err
+			failure := providerFailure(name, err)
+			failures[i] = &failure
+		}(i, name)
+	}
+	wg.Wait()
+
+	// Keep the configured provider order in the response
+	answered := []GeocodeResult{}
+	failed := []ProviderFailure{}
+	upstreamFailed := false
+	for i := range providers {
+		if results[i] != nil {
+			answered = append(answered, *results[i])
+		}
+		if failures[i] != nil {
+			failed = append(failed, *failures[i])
+			upstreamFailed = upstreamFailed || !errors.Is(errs[i], ErrNoResults)
+		}
+	}
+	return answered, failed, upstreamFailed
+}
+
+// resultWeight returns the weight of a result in the consensus, so that confident answers count more
+func resultWeight(result GeocodeResult) float64 {
+	return math.Max(result.Confidence, 0.1)
+}
+
+// buildConsensus clusters the results by distance and computes the consensus of the largest cluster
+func buildConsensus(results []GeocodeResult, radius float64) *ConsensusGeocodeResponse {
+	response := &ConsensusGeocodeResponse{
+		RadiusM:  radius,
+		Agreeing: []string{},
+		Outliers: []ConsensusOutlier{},
+		Results:  results,
+	}
+	if len(results) == 0 {
+		return response
+	}
+
+	// Pick the result whose neighbourhood holds the most providers, breaking ties by total weight
+	var best []int
+	var bestWeight float64
+	for _, center := range results {
+		var members []int
+		var weight float64
+		for j, other := range results {
+			if haversineDistance(coordinateOf(center), coordinateOf(other)) <= radius {
+				members = append(members, j)
+				weight += resultWeight(other)
+			}
+		}
+		if len(members) > len(best) || (len(members) == len(best) && weight > bestWeight) {
+			best, bestWeight = members, weight
+		}
+	}
+
+	// The consensus is the weighted centroid of the winning cluster
+	var lat, lon float64
+	inCluster := make(map[int]bool, len(best))
+	for _, i := range best {
+		weight := resultWeight(results[i])
+		lat += results[i].Lat * weight
+		lon += results[i].Lon * weight
+		inCluster[i] = true
+	}
+	consensus := Coordinate{Lat: lat / bestWeight, Lon: lon / bestWeight}
+	response.Consensus = &consensus
+
+	for i, result := range results {
+		distance := haversineDistance(consensus, coordinateOf(result))
+		response.MaxDistanceM = math.Max(response.MaxDistanceM, distance)
+		if inCluster[i] {
+			response.Agreeing = append(response.Agreeing, result.Provider)
+		} else {
+			response.Outliers = append(response.Outliers, ConsensusOutlier{
+				Provider:  result.Provider,
+				Location:  coordinateOf(result),
+				DistanceM: distance,
+			})
+		}
+	}
+
+	// Disagreement is the share of answering providers outside the consensus cluster
+	response.Disagreement = float64(len(response.Outliers)) / float64(len(results))
+
+	return response
+}
+
+// coordinateOf returns the coordinate of a result
+func coordinateOf(result GeocodeResult) Coordinate {
+	return Coordinate{Lat: result.Lat, Lon: result.Lon}
+}
+
+// consensusGeocodingHandler handles geocoding requests that compare several providers
+func (s *Service) consensusGeocodingHandler(w http.ResponseWriter, r *http.Request) {
+	address := r.URL.Query().Get("address")
+	if address == "" {
+		problem.Write(w, r, problem.Missing("address"))
+		return
+	}
+
+	radius := defaultConsensusRadius
+	if value := r.URL.Query().Get("radius"); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(parsed) || math.IsInf(parsed, 0) || parsed <= 0 {
+			problem.Write(w, r, problem.Invalid("radius", "Invalid 'radius' query parameter: "+value))
+			return
+		}
+		radius = parsed
+	}
+
+	providers, p := s.requestedProviders(r)
+	if p != nil {
+		problem.Write(w, r, p)
+		return
+	}
+
+	results, failures, upstreamFailed := s.queryProviders(r.Context(), address, providers)
+
+	// Without any answer there is no consensus; only report an upstream failure when
+	// a provider did more than find nothing
+	if len(results) == 0 && upstreamFailed {
+		problem.Write(w, r, problem.New(http.StatusBadGateway, problem.UpstreamUnavailable,
+			"no geocoding provider answered").With("failures", failures))
+		return
+	}
+	if len(results) == 0 {
+		problem.Write(w, r, noResultsProblem(providers...).With("failures", failures))
+		return
+	}
+
+	response := buildConsensus(results, radius)
+	response.Failures = failures
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
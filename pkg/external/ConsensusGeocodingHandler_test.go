@@ -0,0 +1,176 @@
+package external
+
+import (
+	"math"
+	"net/http"
+	"testing"
+
+	"github.com/igorsilvestre/simple-go-server/pkg/config"
+)
+
+func TestConsensusGeocodingHandler(t *testing.T) {
+	unavailable := inline(http.StatusServiceUnavailable, "unavailable")
+
+	tests := []struct {
+		name          string
+		fixtures      map[string]fixture
+		target        string
+		wantStatus    int
+		wantConsensus bool
+		wantAgreeing  []string
+		wantOutliers  []string
+		wantFailures  int
+	}{
+		{
+			name: "distant provider is an outlier",
+			fixtures: map[string]fixture{
+				config.Google:    recorded("google/geocode_ok.json"),
+				config.Geoapify:  recorded("geoapify/search_ok.json"),
+				config.Nominatim: recorded("nominatim/search_ok.json"),
+				config.MapTiler:  fromFile(http.StatusOK, "maptiler/geocoding_far.json"),
+			},
+			target:        "/external/geocode?mode=consensus&address=Avenida+Paulista,+1578",
+			wantStatus:    http.StatusOK,
+			wantConsensus: true,
+			wantAgreeing:  []string{config.Google, config.Geoapify, config.Nominatim},
+			wantOutliers:  []string{config.MapTiler},
+		},
+		{
+			name: "small radius splits the providers",
+			fixtures: map[string]fixture{
+				config.Google:   recorded("google/geocode_ok.json"),
+				config.MapTiler: recorded("maptiler/geocoding_ok.json"),
+			},
+			target:        "/external/geocode?mode=consensus&providers=google,maptiler&radius=1&address=Avenida+Paulista,+1578",
+			wantStatus:    http.StatusOK,
+			wantConsensus: true,
+			wantAgreeing:  []string{config.Google},
+			wantOutliers:  []string{config.MapTiler},
+		},
+		{
+			name: "a provider listed twice counts once",
+			fixtures: map[string]fixture{
+				config.Google:   recorded("google/geocode_ok.json"),
+				config.MapTiler: fromFile(http.StatusOK, "maptiler/geocoding_far.json"),
+			},
+			target:        "/external/geocode?mode=consensus&providers=google,google,maptiler&address=Avenida+Paulista,+1578",
+			wantStatus:    http.StatusOK,
+			wantConsensus: true,
+			wantAgreeing:  []string{config.Google},
+			wantOutliers:  []string{config.MapTiler},
+		},
+		{
+			name:         "radius that is not a number",
+			target:       "/external/geocode?mode=consensus&radius=NaN&address=Avenida+Paulista,+1578",
+			wantStatus:   http.StatusBadRequest,
+			wantAgreeing: []string{},
+			wantOutliers: []string{},
+		},
+		{
+			name:         "infinite radius",
+			target:       "/external/geocode?mode=consensus&radius=Inf&address=Avenida+Paulista,+1578",
+			wantStatus:   http.StatusBadRequest,
+			wantAgreeing: []string{},
+			wantOutliers: []string{},
+		},
+		{
+			name: "failed providers are reported",
+			fixtures: map[string]fixture{
+				config.Google:    unavailable,
+				config.Geoapify:  recorded("geoapify/search_ok.json"),
+				config.Nominatim: recorded("nominatim/search_empty.json"),
+				config.MapTiler:  inline(http.StatusOK, "{"),
+			},
+			target:        "/external/geocode?mode=consensus&address=Avenida+Paulista,+1578",
+			wantStatus:    http.StatusOK,
+			wantConsensus: true,
+			wantAgreeing:  []string{config.Geoapify},
+			wantOutliers:  []string{},
+			wantFailures:  3,
+		},
+		{
+			name: "no provider finds anything",
+			fixtures: map[string]fixture{
+				config.Google:    recorded("google/geocode_zero_results.json"),
+				config.Geoapify:  recorded("geoapify/search_empty.json"),
+				config.Nominatim: recorded("nominatim/search_empty.json"),
+				config.MapTiler:  recorded("maptiler/geocoding_empty.json"),
+			},
+			target:       "/external/geocode?mode=consensus&address=zzzz+nowhere+zzzz",
+			wantStatus:   http.StatusNotFound,
+			wantAgreeing: []string{},
+			wantOutliers: []string{},
+			wantFailures: 4,
+		},
+		{
+			name: "every provider fails",
+			fixtures: map[string]fixture{
+				config.Google:    unavailable,
+				config.Geoapify:  unavailable,
+				config.Nominatim: unavailable,
+				config.MapTiler:  unavailable,
+			},
+			target:       "/external/geocode?mode=consensus&address=Avenida+Paulista",
+			wantStatus:   http.StatusBadGateway,
+			wantAgreeing: []string{},
+			wantOutliers: []string{},
+			wantFailures: 4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			upstream.handleGeocoding(tt.fixtures)
+			router := newTestRouter(t, testConfig(), upstream.urls())
+
+			w := serve(router, http.MethodGet, tt.target, "")
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			response := decodeJSON[ConsensusGeocodeResponse](t, w)
+			if (response.Consensus != nil) != tt.wantConsensus {
+				t.Errorf("consensus = %v, want present: %v", response.Consensus, tt.wantConsensus)
+			}
+			if !equalStrings(response.Agreeing, tt.wantAgreeing) {
+				t.Errorf("agreeing = %v, want %v", response.Agreeing, tt.wantAgreeing)
+			}
+			outliers := []string{}
+			for _, outlier := range response.Outliers {
+				outliers = append(outliers, outlier.Provider)
+			}
+			if !equalStrings(outliers, tt.wantOutliers) {
+				t.Errorf("outliers = %v, want %v", outliers, tt.wantOutliers)
+			}
+			if len(response.Failures) != tt.wantFailures {
+				t.Errorf("failures = %+v, want %d", response.Failures, tt.wantFailures)
+			}
+		})
+	}
+}
+
+func TestHaversineDistance(t *testing.T) {
+	// Avenida Paulista to Campinas, about 84 km
+	paulista := Coordinate{Lat: -23.5614, Lon: -46.6559}
+	campinas := Coordinate{Lat: -22.9056, Lon: -47.0608}
+
+	if distance := haversineDistance(paulista, campinas); math.Abs(distance-83600) > 1000 {
+		t.Errorf("distance = %.0f m, want about 83600 m", distance)
+	}
+	if distance := haversineDistance(paulista, paulista); distance != 0 {
+		t.Errorf("distance to itself = %f, want 0", distance)
+	}
+}
+
+// equalStrings reports whether two string slices hold the same values in the same order
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,129 @@
+package external
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/igorsilvestre/simple-go-server/pkg/config"
+	"github.com/igorsilvestre/simple-go-server/pkg/problem"
+)
+
+// ProviderFailure describes why a provider did not answer a request
+type ProviderFailure struct {
+	Provider string `json:"provider"`
+	Code     string `json:"code"`
+	Error    string `json:"error"`
+}
+
+// FallbackGeocodeResponse is the response returned by the fallback geocoding mode
+type FallbackGeocodeResponse struct {
+	Provider string            `json:"provider"`
+	Results  []GeocodeResult   `json:"results"`
+	Failures []ProviderFailure `json:"failures"`
+}
+
+// geocodingProviderOrder returns the configured order in which the enabled providers are tried
+func (s *Service) geocodingProviderOrder() []string {
+	return s.config.EnabledGeocoders(s.config.Geocoding.ProviderOrder)
+}
+
+// requestedProviders returns the providers listed by the "providers" query parameter, or
+// the configured order when the request lists none. Unknown names are a client error, so
+// they are rejected before any lookup; disabled providers are reported as failures. A
+// provider listed twice is only asked once, so it cannot weigh twice in a consensus.
+func (s *Service) requestedProviders(r *http.Request) ([]string, *problem.Problem) {
+	listed := config.ParseProviderList(r.URL.Query().Get("providers"))
+	if len(listed) == 0 {
+		return s.geocodingProviderOrder(), nil
+	}
+	providers := make([]string, 0, len(listed))
+	seen := make(map[string]bool, len(listed))
+	for _, name := range listed {
+		if _, ok := s.geocoders[name]; !ok {
+			return nil, problem.Invalid("providers", "Unknown geocoding provider: "+name)
+		}
+		if !seen[name] {
+			seen[name] = true
+			providers = append(providers, name)
+		}
+	}
+	return providers, nil
+}
+
+// geocodeWithFallback tries each provider in order and stops at the first non-empty answer
+func (s *Service) geocodeWithFallback(ctx context.Context, address string, providers []string) (*FallbackGeocodeResponse, error) {
+	failures := []ProviderFailure{}
+	onlyNoResults := true
+
+	for _, name := range providers {
+		geocoder, err := s.lookupGeocoder(name)
+		if err != nil {
+			failures = append(failures, providerFailure(name, err))
+			onlyNoResults = false
+			continue
+		}
+
+		response, err := s.cachedGeocode(ctx, geocoder, address)
+		if err != nil {
+			// Log the error and fall through to the next provider. "No results" errors
+			// name the address, which the logs may not show.
+			if errors.Is(err, ErrNoResults) {
+				slog.InfoContext(ctx, "No geocoding results", "provider", name)
+			} else {
+				slog.WarnContext(ctx, "Error with geocoding provider", "provider", name, "error", upstreamDetail(err))
+			}
+			failures = append(failures, providerFailure(name, err))
+			if !errors.Is(err, ErrNoResults) {
+				onlyNoResults = false
+			}
+			continue
+		}
+
+		return &FallbackGeocodeResponse{
+			Provider: response.Provider,
+			Results:  response.Results,
+			Failures: failures,
+		}, nil
+	}
+
+	response := &FallbackGeocodeResponse{Results: []GeocodeResult{}, Failures: failures}
+	if onlyNoResults {
+		return response, ErrNoResults
+	}
+	return response, errors.New("all geocoding providers failed")
+}
+
+// fallbackGeocodingHandler handles geocoding requests that try several providers in order
+func (s *Service) fallbackGeocodingHandler(w http.ResponseWriter, r *http.Request) {
+	address := r.URL.Query().Get("address")
+	if address == "" {
+		problem.Write(w, r, problem.Missing("address"))
+		return
+	}
+
+	// The request can override the configured provider order
+	providers, p := s.requestedProviders(r)
+	if p != nil {
+		problem.Write(w, r, p)
+		return
+	}
+
+	response, err := s.geocodeWithFallback(r.Context(), address, providers)
+
+	// An empty answer from every provider is a no_results problem, not an upstream failure
+	if errors.Is(err, ErrNoResults) {
+		problem.Write(w, r, noResultsProblem(providers...).With("failures", response.Failures))
+		return
+	}
+	if err != nil {
+		problem.Write(w, r, problem.New(http.StatusBadGateway, problem.UpstreamUnavailable, err.Error()).
+			With("failures", response.Failures))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,250 @@
+package external
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/igorsilvestre/simple-go-server/pkg/config"
+)
+
+func TestFallbackGeocodingHandler(t *testing.T) {
+	unavailable := inline(http.StatusServiceUnavailable, "unavailable")
+
+	tests := []struct {
+		name         string
+		fixtures     map[string]fixture
+		target       string
+		wantStatus   int
+		wantProvider string
+		wantResults  int
+		// wantFailures lists the providers reported as failed, in order
+		wantFailures []string
+	}{
+		{
+			name: "first provider answers",
+			fixtures: map[string]fixture{
+				config.Google: recorded("google/geocode_ok.json"),
+			},
+			target:       "/external/geocode?mode=fallback&address=Avenida+Paulista,+1578",
+			wantStatus:   http.StatusOK,
+			wantProvider: config.Google,
+			wantResults:  1,
+			wantFailures: []string{},
+		},
+		{
+			name: "falls back after an upstream failure",
+			fixtures: map[string]fixture{
+				config.Google:   unavailable,
+				config.Geoapify: recorded("geoapify/search_ok.json"),
+			},
+			target:       "/external/geocode?mode=fallback&address=Avenida+Paulista,+1578",
+			wantStatus:   http.StatusOK,
+			wantProvider: config.Geoapify,
+			wantResults:  1,
+			wantFailures: []string{config.Google},
+		},
+		{
+			name: "falls back after zero results and malformed JSON",
+			fixtures: map[string]fixture{
+				config.Google:    recorded("google/geocode_zero_results.json"),
+				config.Geoapify:  inline(http.StatusOK, `{"features": [`),
+				config.Nominatim: recorded("nominatim/search_ok.json"),
+			},
+			target:       "/external/geocode?mode=fallback&address=Conjunto+Nacional",
+			wantStatus:   http.StatusOK,
+			wantProvider: config.Nominatim,
+			wantResults:  1,
+			wantFailures: []string{config.Google, config.Geoapify},
+		},
+		{
+			name: "request overrides the provider order",
+			fixtures: map[string]fixture{
+				config.MapTiler: recorded("maptiler/geocoding_ok.json"),
+			},
+			target:       "/external/geocode?mode=fallback&providers=maptiler,google&address=Avenida+Paulista+1578",
+			wantStatus:   http.StatusOK,
+			wantProvider: config.MapTiler,
+			wantResults:  1,
+			wantFailures: []string{},
+		},
+		{
+			name: "no provider finds anything",
+			fixtures: map[string]fixture{
+				config.Google:    recorded("google/geocode_zero_results.json"),
+				config.Geoapify:  recorded("geoapify/search_empty.json"),
+				config.Nominatim: recorded("nominatim/search_empty.json"),
+				config.MapTiler:  recorded("maptiler/geocoding_empty.json"),
+			},
+			target:     "/external/geocode?mode=fallback&address=zzzz+nowhere+zzzz",
+			wantStatus: http.StatusNotFound,
+			// The no_results problem names every provider that was asked
+			wantProvider: "google,geoapify,nominatim,maptiler",
+			wantResults:  0,
+			wantFailures: []string{config.Google, config.Geoapify, config.Nominatim, config.MapTiler},
+		},
+		{
+			name: "every provider fails",
+			fixtures: map[string]fixture{
+				config.Google:    unavailable,
+				config.Geoapify:  unavailable,
+				config.Nominatim: recorded("nominatim/search_empty.json"),
+				config.MapTiler:  unavailable,
+			},
+			target:       "/external/geocode?mode=fallback&address=Avenida+Paulista",
+			wantStatus:   http.StatusBadGateway,
+			wantResults:  0,
+			wantFailures: []string{config.Google, config.Geoapify, config.Nominatim, config.MapTiler},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			upstream.handleGeocoding(tt.fixtures)
+			router := newTestRouter(t, testConfig(), upstream.urls())
+
+			w := serve(router, http.MethodGet, tt.target, "")
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			response := decodeJSON[FallbackGeocodeResponse](t, w)
+			if response.Provider != tt.wantProvider {
+				t.Errorf("provider = %q, want %q", response.Provider, tt.wantProvider)
+			}
+			if len(response.Results) != tt.wantResults {
+				t.Errorf("results = %d, want %d", len(response.Results), tt.wantResults)
+			}
+			if len(response.Failures) != len(tt.wantFailures) {
+				t.Fatalf("failures = %+v, want %v", response.Failures, tt.wantFailures)
+			}
+			for i, failure := range response.Failures {
+				if failure.Provider != tt.wantFailures[i] {
+					t.Errorf("failure %d = %s, want %s", i, failure.Provider, tt.wantFailures[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFallbackGeocodingHandlerCache(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	upstream.handleGeocoding(map[string]fixture{
+		config.Google:   inline(http.StatusServiceUnavailable, "unavailable"),
+		config.Geoapify: recorded("geoapify/search_ok.json"),
+	})
+	router := newTestRouter(t, testConfig(), upstream.urls())
+
+	for i := 0; i < 2; i++ {
+		w := serve(router, http.MethodGet, "/external/geocode?mode=fallback&address=Avenida+Paulista,+1578", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want %d: %s", i, w.Code, http.StatusOK, w.Body)
+		}
+	}
+
+	// The Google failure is retried, the Geoapify answer is served from the cache
+	if calls := upstream.callCount(config.Google); calls != 2 {
+		t.Errorf("google calls = %d, want 2", calls)
+	}
+	if calls := upstream.callCount(config.Geoapify); calls != 1 {
+		t.Errorf("geoapify calls = %d, want 1", calls)
+	}
+}
+
+func TestFallbackGeocodingHandlerRateLimit(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	upstream.handleGeocoding(map[string]fixture{
+		config.Nominatim: recorded("nominatim/search_ok.json"),
+		config.MapTiler:  recorded("maptiler/geocoding_ok.json"),
+	})
+
+	// Nominatim allows a single call and the next one cannot wait for its turn
+	cfg := testConfig()
+	cfg.RateLimit.Providers = map[string]config.ProviderRateConfig{
+		config.Nominatim: {RateConfig: config.RateConfig{Rate: 0.01, Burst: 1}},
+	}
+	router := newTestRouter(t, cfg, upstream.urls())
+
+	wantProviders := []string{config.Nominatim, config.MapTiler}
+	for i, address := range []string{"Conjunto+Nacional", "Avenida+Paulista+1578"} {
+		w := serve(router, http.MethodGet, "/external/geocode?mode=fallback&providers=nominatim,maptiler&address="+address, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want %d: %s", i, w.Code, http.StatusOK, w.Body)
+		}
+		if response := decodeJSON[FallbackGeocodeResponse](t, w); response.Provider != wantProviders[i] {
+			t.Errorf("request %d: provider = %q, want %q", i, response.Provider, wantProviders[i])
+		}
+	}
+
+	// The throttled call never reached Nominatim
+	if calls := upstream.callCount(config.Nominatim); calls != 1 {
+		t.Errorf("nominatim calls = %d, want 1", calls)
+	}
+}
+
+func TestFallbackGeocodingHandlerLogsNoKeys(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	upstream.handleGeocoding(map[string]fixture{
+		config.Geoapify: recorded("geoapify/search_ok.json"),
+	})
+
+	// Google refuses the connection, so the client fails with the full URL of the call
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	urls := upstream.urls()
+	urls.Google = closed.URL
+	router := newTestRouter(t, testConfig(), urls)
+
+	previous := slog.Default()
+	var logs bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	w := serve(router, http.MethodGet, "/external/geocode?mode=fallback&providers=google,geoapify&address=Avenida+Paulista,+1578", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	if !strings.Contains(logs.String(), "Error with geocoding provider") {
+		t.Fatalf("the Google failure was not logged: %s", logs.String())
+	}
+	if strings.Contains(logs.String(), "test-google-key") {
+		t.Errorf("the logs contain the Google API key: %s", logs.String())
+	}
+}
+
+func TestGeocodingModesRejectUnknownProviders(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+	}{
+		{name: "fallback", method: http.MethodGet, target: "/external/geocode?mode=fallback&providers=google,gogle&address=Avenida+Paulista"},
+		{name: "consensus", method: http.MethodGet, target: "/external/geocode?mode=consensus&providers=foo,google&address=Avenida+Paulista"},
+		{name: "batch", method: http.MethodPost, target: "/external/geocode/batch?providers=google,foo", body: `["Avenida Paulista"]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			upstream.handleGeocoding(map[string]fixture{config.Google: recorded("google/geocode_ok.json")})
+			router := newTestRouter(t, testConfig(), upstream.urls())
+
+			w := serve(router, tt.method, tt.target, tt.body)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body)
+			}
+			if !strings.Contains(w.Body.String(), `"parameter":"providers"`) {
+				t.Errorf("body = %s, want the providers parameter named", w.Body)
+			}
+			// The typo is caught before the valid providers are called
+			if calls := upstream.callCount(config.Google); calls != 0 {
+				t.Errorf("google calls = %d, want 0", calls)
+			}
+		})
+	}
+}
@@ -1,13 +1,16 @@
 package external
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
-	"os"
-	"time"
+	"strconv"
+
+	"github.com/igorsilvestre/simple-go-server/pkg/config"
+	"github.com/igorsilvestre/simple-go-server/pkg/problem"
 )
 
 // GeoapifyResponse represents the top-level response from Geoapify Geocoding API
@@ -66,28 +69,60 @@ type GeoapifyQuery struct {
 }
 
 // Function to fetch geocoding data from Geoapify API
-func fetchGeocodingData(address string) (*GeoapifyResponse, error) {
-	// Base URL for the Geoapify geocoding API
-	baseURL := "https://api.geoapify.com/v1/geocode/search"
-
+func (s *Service) fetchGeocodingData(ctx context.Context, address string) (*GeoapifyResponse, error) {
 	// Create URL with query parameters
 	params := url.Values{}
 	params.Add("text", address)
-	params.Add("apiKey", os.Getenv("GEOAPIFY_API_KEY"))
+
+	result, err := s.requestGeoapify(ctx, s.urls.Geoapify+"/geocode/search", params)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check if we got any results
+	if len(result.Features) == 0 {
+		return result, fmt.Errorf("%w for address: %s", ErrNoResults, address)
+	}
+
+	return result, nil
+}
+
+// Function to fetch reverse geocoding data from Geoapify API
+func (s *Service) fetchReverseGeocodingData(ctx context.Context, lat, lon float64) (*GeoapifyResponse, error) {
+	// Create URL with query parameters
+	params := url.Values{}
+	params.Add("lat", strconv.FormatFloat(lat, 'f', -1, 64))
+	params.Add("lon", strconv.FormatFloat(lon, 'f', -1, 64))
+
+	result, err := s.requestGeoapify(ctx, s.urls.Geoapify+"/geocode/reverse", params)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check if we got any results
+	if len(result.Features) == 0 {
+		return result, fmt.Errorf("%w for coordinates: %f,%f", ErrNoResults, lat, lon)
+	}
+
+	return result, nil
+}
+
+// requestGeoapify calls a Geoapify geocoding endpoint with the given query parameters
+func (s *Service) requestGeoapify(ctx context.Context, baseURL string, params url.Values) (*GeoapifyResponse, error) {
+	params.Add("apiKey", s.config.Providers.APIKey(config.Geoapify))
 
 	// Construct the full URL
 	requestURL := fmt.Sprintf("%s?%s", baseURL, params.Encode())
 
 	// Create and execute the request
-	req, err := http.NewRequest("GET", requestURL, nil)
+	req, err := s.newProviderRequest(ctx, config.Geoapify, "GET", requestURL)
 	if err != nil {
-		return nil, fmt.Errorf("error creating request: %v", err)
+		return nil, err
 	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := s.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("error executing request: %v", err)
+		return nil, fmt.Errorf("error executing request: %w", err)
 	}
 	defer resp.Body.Close()
 
@@ -109,61 +144,110 @@ func fetchGeocodingData(address string) (*GeoapifyResponse, error) {
 		return nil, fmt.Errorf("error parsing response: %v", err)
 	}
 
-	// Check if we got any results
-	if len(result.Features) == 0 {
-		return &result, fmt.Errorf("no geocoding results found for address: %s", address)
-	}
-
 	return &result, nil
 }
 
 // GeocodeGeoapifyHandler handles requests to the Geoapify geocoding endpoint
-func GeoapifyGeocodingHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Service) GeoapifyGeocodingHandler(w http.ResponseWriter, r *http.Request) {
 	// Extract the address from query parameters
 	address := r.URL.Query().Get("address")
 	if address == "" {
-		http.Error(w, "Address parameter is required", http.StatusBadRequest)
+		problem.Write(w, r, problem.Missing("address"))
 		return
 	}
 
-	// Create a cache key based on the address
-	cacheKey := "geoapify_geocoding:" + address
+	// Use the cached data or fetch it once for every concurrent request
+	data, err := s.geoapifyGeocodingCache.Fetch(r.Context(), normalizeAddressKey(address), func(ctx context.Context) (*GeoapifyResponse, error) {
+		return s.fetchGeocodingData(ctx, address)
+	})
 
-	// Check if the data is in the cache
-	if cachedData, found := GlobalCache.Get(cacheKey); found {
-		// Use the cached data
-		data := cachedData.(*GeoapifyResponse)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(data)
+	if err != nil {
+		problem.Write(w, r, upstreamProblem(config.Geoapify, err))
 		return
 	}
 
-	// Fetch geocoding data
-	data, err := fetchGeocodingData(address)
-
-	// Set response content type
+	// Write the successful response in JSON format
 	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
+
+// geoapifyGeocoder implements Geocoder on top of the Geoapify Geocoding API
+type geoapifyGeocoder struct {
+	s *Service
+}
 
+// Name returns the provider name
+func (geoapifyGeocoder) Name() string {
+	return "geoapify"
+}
+
+// Geocode converts an address into normalized results using Geoapify
+func (g geoapifyGeocoder) Geocode(ctx context.Context, address string) ([]GeocodeResult, error) {
+	data, err := g.s.fetchGeocodingData(ctx, address)
+	return g.normalizeResponse(data, err)
+}
+
+// ReverseGeocode converts a coordinate into normalized results using Geoapify
+func (g geoapifyGeocoder) ReverseGeocode(ctx context.Context, lat, lon float64) ([]GeocodeResult, error) {
+	data, err := g.s.fetchReverseGeocodingData(ctx, lat, lon)
+	return g.normalizeResponse(data, err)
+}
+
+// normalizeResponse converts every feature of a Geoapify response
+func (g geoapifyGeocoder) normalizeResponse(data *GeoapifyResponse, err error) ([]GeocodeResult, error) {
+	if data != nil && len(data.Features) == 0 {
+		return nil, ErrNoResults
+	}
 	if err != nil {
-		// If we have some data but there was an error, include both
-		if data != nil {
-			json.NewEncoder(w).Encode(map[string]interface{}{
-				"error": err.Error(),
-				"data":  data,
-			})
-		} else {
-			// Just return the error if we have no data
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(map[string]interface{}{
-				"error": err.Error(),
-			})
-		}
-		return
+		return nil, err
 	}
 
-	// Store the data in the cache (1000 hours expiration)
-	GlobalCache.Set(cacheKey, data, 1000*time.Hour)
+	results := make([]GeocodeResult, 0, len(data.Features))
+	for _, feature := range data.Features {
+		results = append(results, g.normalize(feature))
+	}
+	return results, nil
+}
 
-	// Write the successful response in JSON format
-	json.NewEncoder(w).Encode(data)
+// normalize converts a Geoapify feature into a GeocodeResult
+func (g geoapifyGeocoder) normalize(feature GeoapifyFeature) GeocodeResult {
+	properties := feature.Properties
+
+	components := make(map[string]string)
+	setComponent(components, "housenumber", properties.Housenumber)
+	setComponent(components, "street", properties.Street)
+	setComponent(components, "suburb", properties.Suburb)
+	setComponent(components, "city", properties.City)
+	setComponent(components, "county", properties.County)
+	setComponent(components, "state", properties.State)
+	setComponent(components, "state_code", properties.StateCode)
+	setComponent(components, "postcode", properties.Postcode)
+	setComponent(components, "country", properties.Country)
+	setComponent(components, "country_code", properties.CountryCode)
+
+	var confidence float64
+	if value, ok := properties.Rank["confidence"].(float64); ok {
+		confidence = clampConfidence(value)
+	}
+
+	result := GeocodeResult{
+		Lat:              properties.Lat,
+		Lon:              properties.Lon,
+		FormattedAddress: properties.Formatted,
+		Components:       components,
+		Confidence:       confidence,
+		Provider:         g.Name(),
+	}
+
+	// Geoapify returns the bounding box as [lon1, lat1, lon2, lat2]
+	if len(feature.BBox) == 4 {
+		result.BoundingBox = &BoundingBox{
+			South: feature.BBox[1],
+			West:  feature.BBox[0],
+			North: feature.BBox[3],
+			East:  feature.BBox[2],
+		}
+	}
+
+	return result
 }
@@ -1,13 +1,16 @@
 package external
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
-	"os"
-	"time"
+	"strings"
+
+	"github.com/igorsilvestre/simple-go-server/pkg/config"
+	"github.com/igorsilvestre/simple-go-server/pkg/problem"
 )
 
 // GeocodingResponse represents the response structure from the Google Geocoding API
@@ -25,6 +28,11 @@ type GeocodingResult struct {
 	AddressComponents []AddressComponent `json:"address_components"`
 }
 
+// noResults reports whether Google found nothing for the request
+func (r *GeocodingResponse) noResults() bool {
+	return r != nil && r.Status == "ZERO_RESULTS"
+}
+
 // GeometryData contains location information
 type GeometryData struct {
 	Location     LatLngData   `json:"location"`
@@ -52,53 +60,87 @@ type AddressComponent struct {
 }
 
 // GeocodingHandler handles geocoding requests and returns location data
-func googleGeocodingHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Service) googleGeocodingHandler(w http.ResponseWriter, r *http.Request) {
+	// Fallback mode tries every configured provider in order, consensus mode compares them
+	switch r.URL.Query().Get("mode") {
+	case "fallback":
+		s.fallbackGeocodingHandler(w, r)
+		return
+	case "consensus":
+		s.consensusGeocodingHandler(w, r)
+		return
+	}
+
+	// Selecting a provider switches to the normalized response format
+	if r.URL.Query().Get("provider") != "" {
+		s.normalizedGeocodingHandler(w, r)
+		return
+	}
+
 	address := r.URL.Query().Get("address")
 	if address == "" {
-		http.Error(w, "Missing 'address' query parameter", http.StatusBadRequest)
+		problem.Write(w, r, problem.Missing("address"))
 		return
 	}
 
-	// Create a cache key based on the address
-	cacheKey := "google_geocoding:" + address
-
-	// Check if the data is in the cache
-	if cachedData, found := GlobalCache.Get(cacheKey); found {
-		// Use the cached data
-		geocodingData := cachedData.(*GeocodingResponse)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(geocodingData)
+	// The raw Google response needs Google, the other modes work with any provider
+	if !s.config.Providers.Enabled(config.Google) {
+		problem.Write(w, r, problem.New(http.StatusNotFound, problem.ProviderNotConfigured,
+			"Google geocoding is not configured, select a provider or a mode").WithProvider(config.Google))
 		return
 	}
 
-	geocodingData, err := getGeocodingData(address)
+	// Use the cached data or fetch it once for every concurrent request
+	geocodingData, err := s.googleGeocodingCache.Fetch(r.Context(), normalizeAddressKey(address), func(ctx context.Context) (*GeocodingResponse, error) {
+		return s.getGeocodingData(ctx, address)
+	})
 	if err != nil {
-		http.Error(w, "Error fetching geocoding data: "+err.Error(), http.StatusInternalServerError)
+		problem.Write(w, r, upstreamProblem(config.Google, err))
+		return
+	}
+	// ZERO_RESULTS is cached as an answer but reported like on the other routes
+	if geocodingData.noResults() {
+		problem.Write(w, r, noResultsProblem(config.Google))
 		return
 	}
-
-	// Store the data in the cache (24 hours expiration)
-	GlobalCache.Set(cacheKey, geocodingData, 24*time.Hour)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(geocodingData)
 }
 
 // getGeocodingData fetches geocoding data from the Google Geocoding API
-func getGeocodingData(address string) (*GeocodingResponse, error) {
-	apiKey := os.Getenv("GOOGLE_API_KEY")
+func (s *Service) getGeocodingData(ctx context.Context, address string) (*GeocodingResponse, error) {
+	params := url.Values{}
+	params.Add("address", address)
+
+	return s.requestGoogleGeocoding(ctx, params)
+}
+
+// getReverseGeocodingData fetches the addresses found at a coordinate from the Google Geocoding API
+func (s *Service) getReverseGeocodingData(ctx context.Context, lat, lon float64) (*GeocodingResponse, error) {
+	params := url.Values{}
+	params.Add("latlng", fmt.Sprintf("%f,%f", lat, lon))
+
+	return s.requestGoogleGeocoding(ctx, params)
+}
+
+// requestGoogleGeocoding calls the Google Geocoding API with the given query parameters
+func (s *Service) requestGoogleGeocoding(ctx context.Context, params url.Values) (*GeocodingResponse, error) {
+	apiKey := s.config.Providers.APIKey(config.Google)
 	if apiKey == "" {
 		return nil, fmt.Errorf("Google API key is not set")
 	}
 
-	endpoint := "https://maps.googleapis.com/maps/api/geocode/json"
-	params := url.Values{}
-	params.Add("address", address)
+	endpoint := s.urls.Google + "/geocode/json"
 	params.Add("key", apiKey)
 
 	apiURL := endpoint + "?" + params.Encode()
 
-	resp, err := http.Get(apiURL)
+	req, err := s.newProviderRequest(ctx, config.Google, http.MethodGet, apiURL)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -124,3 +166,95 @@ func getGeocodingData(address string) (*GeocodingResponse, error) {
 
 	return &geocodingResponse, nil
 }
+
+// googleGeocoder implements Geocoder on top of the Google Geocoding API
+type googleGeocoder struct {
+	s *Service
+}
+
+// Name returns the provider name
+func (googleGeocoder) Name() string {
+	return "google"
+}
+
+// Geocode converts an address into normalized results using Google
+func (g googleGeocoder) Geocode(ctx context.Context, address string) ([]GeocodeResult, error) {
+	data, err := g.s.getGeocodingData(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	return g.normalizeResponse(data)
+}
+
+// ReverseGeocode converts a coordinate into normalized results using Google
+func (g googleGeocoder) ReverseGeocode(ctx context.Context, lat, lon float64) ([]GeocodeResult, error) {
+	data, err := g.s.getReverseGeocodingData(ctx, lat, lon)
+	if err != nil {
+		return nil, err
+	}
+	return g.normalizeResponse(data)
+}
+
+// normalizeResponse converts every result of a Google response
+func (g googleGeocoder) normalizeResponse(data *GeocodingResponse) ([]GeocodeResult, error) {
+	if data.Status == "ZERO_RESULTS" || len(data.Results) == 0 {
+		return nil, ErrNoResults
+	}
+
+	results := make([]GeocodeResult, 0, len(data.Results))
+	for _, result := range data.Results {
+		results = append(results, g.normalize(result))
+	}
+	return results, nil
+}
+
+// normalize converts a Google result into a GeocodeResult
+func (g googleGeocoder) normalize(result GeocodingResult) GeocodeResult {
+	components := make(map[string]string)
+	for _, component := range result.AddressComponents {
+		for _, componentType := range component.Types {
+			switch componentType {
+			case "street_number":
+				setComponent(components, "housenumber", component.LongName)
+			case "route":
+				setComponent(components, "street", component.LongName)
+			case "sublocality", "sublocality_level_1":
+				setComponent(components, "suburb", component.LongName)
+			case "administrative_area_level_2", "locality":
+				setComponent(components, "city", component.LongName)
+			case "administrative_area_level_1":
+				setComponent(components, "state", component.LongName)
+				setComponent(components, "state_code", component.ShortName)
+			case "country":
+				setComponent(components, "country", component.LongName)
+				setComponent(components, "country_code", strings.ToLower(component.ShortName))
+			case "postal_code":
+				setComponent(components, "postcode", component.LongName)
+			}
+		}
+	}
+
+	viewport := result.Geometry.Viewport
+	return GeocodeResult{
+		Lat:              result.Geometry.Location.Lat,
+		Lon:              result.Geometry.Location.Lng,
+		FormattedAddress: result.FormattedAddress,
+		Components:       components,
+		BoundingBox: &BoundingBox{
+			South: viewport.Southwest.Lat,
+			West:  viewport.Southwest.Lng,
+			North: viewport.Northeast.Lat,
+			East:  viewport.Northeast.Lng,
+		},
+		Confidence: googleLocationTypeConfidence[result.Geometry.LocationType],
+		Provider:   g.Name(),
+	}
+}
+
+// googleLocationTypeConfidence maps Google's location_type to a confidence score
+var googleLocationTypeConfidence = map[string]float64{
+	"ROOFTOP":            1.0,
+	"RANGE_INTERPOLATED": 0.8,
+	"GEOMETRIC_CENTER":   0.6,
+	"APPROXIMATE":        0.4,
+}
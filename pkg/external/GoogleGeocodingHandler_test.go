@@ -0,0 +1,137 @@
+package external
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/igorsilvestre/simple-go-server/pkg/config"
+)
+
+func TestGoogleGeocodingHandler(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture fixture
+		target  string
+		// requests is the number of identical requests sent, to exercise the cache
+		requests   int
+		wantStatus int
+		wantBody   string
+		wantCalls  int
+	}{
+		{
+			name:       "success is cached",
+			fixture:    recorded("google/geocode_ok.json"),
+			target:     "/external/geocode?address=Avenida+Paulista,+1578,+S%C3%A3o+Paulo",
+			requests:   2,
+			wantStatus: http.StatusOK,
+			wantBody:   `"formatted_address":"Av. Paulista, 1578 - Bela Vista, São Paulo - SP, 01310-200, Brasil"`,
+			wantCalls:  1,
+		},
+		{
+			name:       "zero results are a cached no_results problem",
+			fixture:    recorded("google/geocode_zero_results.json"),
+			target:     "/external/geocode?address=zzzz+nowhere+zzzz",
+			requests:   2,
+			wantStatus: http.StatusNotFound,
+			wantBody:   `"code":"no_results"`,
+			wantCalls:  1,
+		},
+		{
+			name:       "normalized zero results are a no_results problem",
+			fixture:    recorded("google/geocode_zero_results.json"),
+			target:     "/external/geocode?provider=google&address=zzzz+nowhere+zzzz",
+			requests:   2,
+			wantStatus: http.StatusNotFound,
+			wantBody:   `"provider":"google","status":404`,
+			wantCalls:  1,
+		},
+		{
+			name:       "error status",
+			fixture:    fromFile(http.StatusOK, "google/geocode_request_denied.json"),
+			target:     "/external/geocode?address=Avenida+Paulista",
+			requests:   2,
+			wantStatus: http.StatusBadGateway,
+			wantBody:   "Google API error: REQUEST_DENIED",
+			wantCalls:  2,
+		},
+		{
+			name:       "non-200",
+			fixture:    inline(http.StatusServiceUnavailable, "backend unavailable"),
+			target:     "/external/geocode?address=Avenida+Paulista",
+			requests:   1,
+			wantStatus: http.StatusBadGateway,
+			wantBody:   "Google API error: backend unavailable",
+			wantCalls:  1,
+		},
+		{
+			name:       "malformed JSON",
+			fixture:    inline(http.StatusOK, `{"results": [`),
+			target:     "/external/geocode?address=Avenida+Paulista",
+			requests:   1,
+			wantStatus: http.StatusBadGateway,
+			wantBody:   `"code":"upstream_unavailable","detail":"unexpected end of JSON input"`,
+			wantCalls:  1,
+		},
+		{
+			name:       "missing address",
+			fixture:    recorded("google/geocode_ok.json"),
+			target:     "/external/geocode",
+			requests:   1,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `"code":"missing_parameter"`,
+			wantCalls:  0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			upstream.handle(config.Google, "/geocode/json", tt.fixture)
+			router := newTestRouter(t, testConfig(), upstream.urls())
+
+			for i := 0; i < tt.requests; i++ {
+				w := serve(router, http.MethodGet, tt.target, "")
+				if w.Code != tt.wantStatus {
+					t.Fatalf("request %d: status = %d, want %d: %s", i, w.Code, tt.wantStatus, w.Body)
+				}
+				if !strings.Contains(w.Body.String(), tt.wantBody) {
+					t.Errorf("request %d: body = %s, want it to contain %s", i, w.Body, tt.wantBody)
+				}
+			}
+			if calls := upstream.callCount(config.Google); calls != tt.wantCalls {
+				t.Errorf("upstream calls = %d, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestGoogleGeocodingHandlerNormalized(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	upstream.handle(config.Google, "/geocode/json", recorded("google/geocode_ok.json"))
+	router := newTestRouter(t, testConfig(), upstream.urls())
+
+	w := serve(router, http.MethodGet, "/external/geocode?provider=google&address=Avenida+Paulista,+1578", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	response := decodeJSON[GeocodeResponse](t, w)
+	if response.Provider != config.Google || len(response.Results) != 1 {
+		t.Fatalf("response = %+v, want one google result", response)
+	}
+	if result := response.Results[0]; result.Lat != -23.5613991 || result.Lon != -46.6558621 {
+		t.Errorf("location = %f,%f, want -23.5613991,-46.6558621", result.Lat, result.Lon)
+	}
+}
+
+func TestGoogleGeocodingHandlerDisabled(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	cfg := testConfig()
+	cfg.Providers.Google.APIKey = ""
+	router := newTestRouter(t, cfg, upstream.urls())
+
+	w := serve(router, http.MethodGet, "/external/geocode?address=Avenida+Paulista", "")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d: %s", w.Code, http.StatusNotFound, w.Body)
+	}
+}
@@ -1,13 +1,16 @@
 package external
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
-	"os"
-	"time"
+	"strconv"
+
+	"github.com/igorsilvestre/simple-go-server/pkg/config"
+	"github.com/igorsilvestre/simple-go-server/pkg/problem"
 )
 
 // MapTilerResponse represents the top-level response from MapTiler Geocoding API
@@ -23,6 +26,7 @@ type MapTilerFeature struct {
 	Properties MapTilerProperties `json:"properties"`
 	Geometry   MapTilerGeometry   `json:"geometry"`
 	BBox       []float64          `json:"bbox,omitempty"`
+	Relevance  float64            `json:"relevance,omitempty"`
 }
 
 // MapTilerProperties contains the detailed location data
@@ -60,33 +64,69 @@ type MapTilerGeometry struct {
 }
 
 // Function to fetch geocoding data from MapTiler API
-func fetchMapTilerGeocodingData(address string) (*MapTilerResponse, error) {
+func (s *Service) fetchMapTilerGeocodingData(ctx context.Context, address string) (*MapTilerResponse, error) {
 	// URL encode the address
 	encodedAddress := url.PathEscape(address)
 
-	// Base URL for the MapTiler geocoding API
-	baseURL := fmt.Sprintf("https://api.maptiler.com/geocoding/%s.json", encodedAddress)
-
 	// Create URL with query parameters
 	params := url.Values{}
 	params.Add("autocomplete", "false")
 	params.Add("fuzzyMatch", "true")
 	params.Add("limit", "3")
-	params.Add("key", os.Getenv("MAPTILER_API_KEY"))
+
+	result, err := s.requestMapTiler(ctx, encodedAddress, params)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check if we got any results
+	if len(result.Features) == 0 {
+		return result, fmt.Errorf("%w for address: %s", ErrNoResults, address)
+	}
+
+	return result, nil
+}
+
+// Function to fetch reverse geocoding data from MapTiler API
+func (s *Service) fetchMapTilerReverseGeocodingData(ctx context.Context, lat, lon float64) (*MapTilerResponse, error) {
+	// MapTiler expects the coordinate as "lon,lat" in the path
+	query := strconv.FormatFloat(lon, 'f', -1, 64) + "," + strconv.FormatFloat(lat, 'f', -1, 64)
+
+	params := url.Values{}
+	params.Add("limit", "3")
+
+	result, err := s.requestMapTiler(ctx, query, params)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check if we got any results
+	if len(result.Features) == 0 {
+		return result, fmt.Errorf("%w for coordinates: %f,%f", ErrNoResults, lat, lon)
+	}
+
+	return result, nil
+}
+
+// requestMapTiler calls the MapTiler geocoding API for an already escaped query
+func (s *Service) requestMapTiler(ctx context.Context, query string, params url.Values) (*MapTilerResponse, error) {
+	// Base URL for the MapTiler geocoding API
+	baseURL := fmt.Sprintf("%s/geocoding/%s.json", s.urls.MapTiler, query)
+
+	params.Add("key", s.config.Providers.APIKey(config.MapTiler))
 
 	// Construct the full URL
 	requestURL := fmt.Sprintf("%s?%s", baseURL, params.Encode())
 
 	// Create and execute the request
-	req, err := http.NewRequest("GET", requestURL, nil)
+	req, err := s.newProviderRequest(ctx, config.MapTiler, "GET", requestURL)
 	if err != nil {
-		return nil, fmt.Errorf("error creating request: %v", err)
+		return nil, err
 	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := s.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("error executing request: %v", err)
+		return nil, fmt.Errorf("error executing request: %w", err)
 	}
 	defer resp.Body.Close()
 
@@ -108,70 +148,125 @@ func fetchMapTilerGeocodingData(address string) (*MapTilerResponse, error) {
 		return nil, fmt.Errorf("error parsing response: %v", err)
 	}
 
-	// Check if we got any results
-	if len(result.Features) == 0 {
-		return &result, fmt.Errorf("no geocoding results found for address: %s", address)
-	}
-
 	return &result, nil
 }
 
 // MapTilerGeocodingHandler handles requests to the MapTiler geocoding endpoint
-func MapTilerGeocodingHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Service) MapTilerGeocodingHandler(w http.ResponseWriter, r *http.Request) {
 	// Extract the address from query parameters
 	address := r.URL.Query().Get("address")
 	if address == "" {
-		http.Error(w, "Address parameter is required", http.StatusBadRequest)
+		problem.Write(w, r, problem.Missing("address"))
 		return
 	}
 
-	// Create a cache key based on the address
-	cacheKey := "maptiler_geocoding:" + address
+	// Use the cached data or fetch it once for every concurrent request
+	data, err := s.mapTilerGeocodingCache.Fetch(r.Context(), normalizeAddressKey(address), func(ctx context.Context) (*MapTilerResponse, error) {
+		data, err := s.fetchMapTilerGeocodingData(ctx, address)
 
-	// Check if the data is in the cache
-	if cachedData, found := GlobalCache.Get(cacheKey); found {
-		// Use the cached data
-		data := cachedData.(*MapTilerResponse)
-		w.Header().Set("Content-Type", "application/geo+json")
-		json.NewEncoder(w).Encode(data)
+		// Ensure the response is a valid GeoJSON by setting the type to "FeatureCollection"
+		if data != nil && data.Type == "" {
+			data.Type = "FeatureCollection"
+		}
+		return data, err
+	})
+
+	if err != nil {
+		problem.Write(w, r, upstreamProblem(config.MapTiler, err))
 		return
 	}
 
-	// Fetch geocoding data
-	data, err := fetchMapTilerGeocodingData(address)
-
-	// Set response content type for GeoJSON
+	// Write the successful response in GeoJSON format
 	w.Header().Set("Content-Type", "application/geo+json")
+	json.NewEncoder(w).Encode(data)
+}
+
+// mapTilerGeocoder implements Geocoder on top of the MapTiler Geocoding API
+type mapTilerGeocoder struct {
+	s *Service
+}
 
+// Name returns the provider name
+func (mapTilerGeocoder) Name() string {
+	return "maptiler"
+}
+
+// Geocode converts an address into normalized results using MapTiler
+func (g mapTilerGeocoder) Geocode(ctx context.Context, address string) ([]GeocodeResult, error) {
+	data, err := g.s.fetchMapTilerGeocodingData(ctx, address)
+	return g.normalizeResponse(data, err)
+}
+
+// ReverseGeocode converts a coordinate into normalized results using MapTiler
+func (g mapTilerGeocoder) ReverseGeocode(ctx context.Context, lat, lon float64) ([]GeocodeResult, error) {
+	data, err := g.s.fetchMapTilerReverseGeocodingData(ctx, lat, lon)
+	return g.normalizeResponse(data, err)
+}
+
+// normalizeResponse converts every feature of a MapTiler response
+func (g mapTilerGeocoder) normalizeResponse(data *MapTilerResponse, err error) ([]GeocodeResult, error) {
+	if data != nil && len(data.Features) == 0 {
+		return nil, ErrNoResults
+	}
 	if err != nil {
-		// If we have some data but there was an error, include both
-		if data != nil {
-			// Set content type back to regular JSON for error responses
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(map[string]interface{}{
-				"error": err.Error(),
-				"data":  data,
-			})
-		} else {
-			// Just return the error if we have no data
-			w.WriteHeader(http.StatusInternalServerError)
-			// Set content type back to regular JSON for error responses
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(map[string]interface{}{
-				"error": err.Error(),
-			})
-		}
-		return
+		return nil, err
 	}
 
-	// Ensure the response is a valid GeoJSON by setting the type to "FeatureCollection"
-	if data.Type == "" {
-		data.Type = "FeatureCollection"
+	results := make([]GeocodeResult, 0, len(data.Features))
+	for _, feature := range data.Features {
+		results = append(results, g.normalize(feature))
 	}
+	return results, nil
+}
 
-	// Store the data in the cache (24 hours expiration)
-	GlobalCache.Set(cacheKey, data, 24*time.Hour)
+// normalize converts a MapTiler feature into a GeocodeResult
+func (g mapTilerGeocoder) normalize(feature MapTilerFeature) GeocodeResult {
+	properties := feature.Properties
 
-	// Write the successful response in GeoJSON format
-	json.NewEncoder(w).Encode(data)
+	components := make(map[string]string)
+	setComponent(components, "housenumber", properties.HouseNumber)
+	setComponent(components, "street", properties.Street)
+	setComponent(components, "suburb", properties.Suburb)
+	setComponent(components, "city", properties.City)
+	setComponent(components, "county", properties.County)
+	setComponent(components, "state", properties.State)
+	setComponent(components, "state_code", properties.RegionCode)
+	setComponent(components, "postcode", properties.Postcode)
+	setComponent(components, "country", properties.Country)
+	setComponent(components, "country_code", properties.CountryCode)
+
+	confidence := feature.Relevance
+	if confidence == 0 {
+		confidence = properties.Score
+	}
+
+	formatted := properties.Formatted
+	if formatted == "" {
+		formatted = properties.Label
+	}
+
+	result := GeocodeResult{
+		FormattedAddress: formatted,
+		Components:       components,
+		Confidence:       clampConfidence(confidence),
+		Provider:         g.Name(),
+	}
+
+	// GeoJSON coordinates are [lon, lat]
+	if len(feature.Geometry.Coordinates) >= 2 {
+		result.Lon = feature.Geometry.Coordinates[0]
+		result.Lat = feature.Geometry.Coordinates[1]
+	}
+
+	// MapTiler returns the bounding box as [minlon, minlat, maxlon, maxlat]
+	if len(feature.BBox) == 4 {
+		result.BoundingBox = &BoundingBox{
+			South: feature.BBox[1],
+			West:  feature.BBox[0],
+			North: feature.BBox[3],
+			East:  feature.BBox[2],
+		}
+	}
+
+	return result
 }
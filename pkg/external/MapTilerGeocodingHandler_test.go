@@ -0,0 +1,88 @@
+package external
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/igorsilvestre/simple-go-server/pkg/config"
+)
+
+func TestMapTilerGeocodingHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		fixture    fixture
+		target     string
+		requests   int
+		wantStatus int
+		wantBody   string
+		wantCalls  int
+	}{
+		{
+			name:       "success is cached",
+			fixture:    recorded("maptiler/geocoding_ok.json"),
+			target:     "/external/geocode-maptiler?address=Avenida+Paulista+1578",
+			requests:   2,
+			wantStatus: http.StatusOK,
+			wantBody:   `"label":"Avenida Paulista 1578, São Paulo, Brazil"`,
+			wantCalls:  1,
+		},
+		{
+			name:       "zero results are a cached no_results problem",
+			fixture:    recorded("maptiler/geocoding_empty.json"),
+			target:     "/external/geocode-maptiler?address=zzzz+nowhere+zzzz",
+			requests:   2,
+			wantStatus: http.StatusNotFound,
+			wantBody:   `"code":"no_results","detail":"no geocoding results found for address: zzzz nowhere zzzz"`,
+			wantCalls:  1,
+		},
+		{
+			name:       "non-200",
+			fixture:    inline(http.StatusForbidden, `{"message": "Invalid key"}`),
+			target:     "/external/geocode-maptiler?address=Avenida+Paulista",
+			requests:   2,
+			wantStatus: http.StatusBadGateway,
+			wantBody:   "non-200 response from MapTiler (403)",
+			wantCalls:  2,
+		},
+		{
+			name:       "malformed JSON",
+			fixture:    inline(http.StatusOK, `{"features": [`),
+			target:     "/external/geocode-maptiler?address=Avenida+Paulista",
+			requests:   1,
+			wantStatus: http.StatusBadGateway,
+			wantBody:   `"detail":"error parsing response`,
+			wantCalls:  1,
+		},
+		{
+			name:       "missing address",
+			fixture:    recorded("maptiler/geocoding_ok.json"),
+			target:     "/external/geocode-maptiler",
+			requests:   1,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `"code":"missing_parameter"`,
+			wantCalls:  0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			upstream.handle(config.MapTiler, "/geocoding/", tt.fixture)
+			router := newTestRouter(t, testConfig(), upstream.urls())
+
+			for i := 0; i < tt.requests; i++ {
+				w := serve(router, http.MethodGet, tt.target, "")
+				if w.Code != tt.wantStatus {
+					t.Fatalf("request %d: status = %d, want %d: %s", i, w.Code, tt.wantStatus, w.Body)
+				}
+				if !strings.Contains(w.Body.String(), tt.wantBody) {
+					t.Errorf("request %d: body = %s, want it to contain %s", i, w.Body, tt.wantBody)
+				}
+			}
+			if calls := upstream.callCount(config.MapTiler); calls != tt.wantCalls {
+				t.Errorf("upstream calls = %d, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
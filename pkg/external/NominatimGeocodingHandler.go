@@ -1,12 +1,17 @@
 package external
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
-	"time"
+	"strconv"
+	"strings"
+
+	"github.com/igorsilvestre/simple-go-server/pkg/config"
+	"github.com/igorsilvestre/simple-go-server/pkg/problem"
 )
 
 // NominatimGeocodingResult represents a single result from the Nominatim API
@@ -25,71 +30,126 @@ type NominatimGeocodingResult struct {
 	Name        string   `json:"name"`
 	DisplayName string   `json:"display_name"`
 	BoundingBox []string `json:"boundingbox"`
+	// Address is only returned when the request sets addressdetails=1
+	Address NominatimAddress `json:"address"`
+}
+
+// NominatimAddress holds the address breakdown of a Nominatim result
+type NominatimAddress struct {
+	HouseNumber   string `json:"house_number,omitempty"`
+	Road          string `json:"road,omitempty"`
+	Suburb        string `json:"suburb,omitempty"`
+	Neighbourhood string `json:"neighbourhood,omitempty"`
+	City          string `json:"city,omitempty"`
+	Town          string `json:"town,omitempty"`
+	Village       string `json:"village,omitempty"`
+	Municipality  string `json:"municipality,omitempty"`
+	County        string `json:"county,omitempty"`
+	State         string `json:"state,omitempty"`
+	StateCode     string `json:"ISO3166-2-lvl4,omitempty"`
+	Postcode      string `json:"postcode,omitempty"`
+	Country       string `json:"country,omitempty"`
+	CountryCode   string `json:"country_code,omitempty"`
 }
 
 // NominatimGeocodingHandler handles geocoding requests using the Nominatim API
-func NominatimGeocodingHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Service) NominatimGeocodingHandler(w http.ResponseWriter, r *http.Request) {
 	// Extract the address from query parameters
 	address := r.URL.Query().Get("address")
 	if address == "" {
-		http.Error(w, "Address parameter is required", http.StatusBadRequest)
-		return
-	}
-
-	// Create a cache key based on the address
-	cacheKey := "nominatim_geocoding:" + address
-
-	// Check if the data is in the cache
-	if cachedData, found := GlobalCache.Get(cacheKey); found {
-		// Use the cached data
-		results := cachedData.([]NominatimGeocodingResult)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(results)
+		problem.Write(w, r, problem.Missing("address"))
 		return
 	}
 
-	// Fetch geocoding data from Nominatim
-	results, err := fetchNominatimGeocodingData(address)
+	// Use the cached data or fetch it once for every concurrent request
+	results, err := s.nominatimGeocodingCache.Fetch(r.Context(), normalizeAddressKey(address), func(ctx context.Context) ([]NominatimGeocodingResult, error) {
+		return s.fetchNominatimGeocodingData(ctx, address)
+	})
 	if err != nil {
-		http.Error(w, "Error fetching geocoding data: "+err.Error(), http.StatusInternalServerError)
+		problem.Write(w, r, upstreamProblem(config.Nominatim, err))
 		return
 	}
 
-	// Store the data in the cache (24 hours expiration)
-	GlobalCache.Set(cacheKey, results, 24*time.Hour)
-
 	// Set response content type and return the results
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(results)
 }
 
 // fetchNominatimGeocodingData fetches geocoding data from the Nominatim API
-func fetchNominatimGeocodingData(address string) ([]NominatimGeocodingResult, error) {
-	// Base URL for the Nominatim API
-	baseURL := "https://nominatim.openstreetmap.org/search"
-
+func (s *Service) fetchNominatimGeocodingData(ctx context.Context, address string) ([]NominatimGeocodingResult, error) {
 	// Create URL with query parameters
 	params := url.Values{}
 	params.Add("q", address)
 	params.Add("format", "json")
+	params.Add("addressdetails", "1")
 
+	body, err := s.requestNominatim(ctx, s.urls.Nominatim+"/search", params)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unmarshal the response
+	var results []NominatimGeocodingResult
+	if err := json.Unmarshal(body, &results); err != nil {
+		return nil, fmt.Errorf("error parsing response: %v", err)
+	}
+
+	// Check if we got any results
+	if len(results) == 0 {
+		return results, fmt.Errorf("%w for address: %s", ErrNoResults, address)
+	}
+
+	return results, nil
+}
+
+// fetchNominatimReverseGeocodingData fetches the place found at a coordinate from the Nominatim API
+func (s *Service) fetchNominatimReverseGeocodingData(ctx context.Context, lat, lon float64) ([]NominatimGeocodingResult, error) {
+	// Create URL with query parameters
+	params := url.Values{}
+	params.Add("lat", strconv.FormatFloat(lat, 'f', -1, 64))
+	params.Add("lon", strconv.FormatFloat(lon, 'f', -1, 64))
+	params.Add("format", "json")
+	params.Add("addressdetails", "1")
+
+	body, err := s.requestNominatim(ctx, s.urls.Nominatim+"/reverse", params)
+	if err != nil {
+		return nil, err
+	}
+
+	// The reverse endpoint returns a single object, or an error object when nothing is found
+	var result struct {
+		NominatimGeocodingResult
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("error parsing response: %v", err)
+	}
+
+	if result.Error != "" || result.Lat == "" {
+		return []NominatimGeocodingResult{}, fmt.Errorf("%w for coordinates: %f,%f", ErrNoResults, lat, lon)
+	}
+
+	return []NominatimGeocodingResult{result.NominatimGeocodingResult}, nil
+}
+
+// requestNominatim calls a Nominatim endpoint and returns the raw response body
+func (s *Service) requestNominatim(ctx context.Context, baseURL string, params url.Values) ([]byte, error) {
 	// Construct the full URL
 	requestURL := fmt.Sprintf("%s?%s", baseURL, params.Encode())
 
-	// Create a client with custom headers (Nominatim requires a User-Agent)
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", requestURL, nil)
+	// Create a request with custom headers (Nominatim requires a User-Agent)
+	req, err := s.newProviderRequest(ctx, config.Nominatim, "GET", requestURL)
 	if err != nil {
-		return nil, fmt.Errorf("error creating request: %v", err)
+		return nil, err
 	}
 
 	// Set a User-Agent as required by Nominatim's usage policy
 	req.Header.Set("User-Agent", "simple-go-server")
 
 	// Execute the request
-	resp, err := client.Do(req)
+	resp, err := s.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("error executing request: %v", err)
+		return nil, fmt.Errorf("error executing request: %w", err)
 	}
 	defer resp.Body.Close()
 
@@ -105,16 +165,105 @@ func fetchNominatimGeocodingData(address string) ([]NominatimGeocodingResult, er
 		return nil, fmt.Errorf("error reading response body: %v", err)
 	}
 
-	// Unmarshal the response
-	var results []NominatimGeocodingResult
-	if err := json.Unmarshal(body, &results); err != nil {
-		return nil, fmt.Errorf("error parsing response: %v", err)
-	}
+	return body, nil
+}
 
-	// Check if we got any results
-	if len(results) == 0 {
-		return results, fmt.Errorf("no geocoding results found for address: %s", address)
+// nominatimGeocoder implements Geocoder on top of the Nominatim API
+type nominatimGeocoder struct {
+	s *Service
+}
+
+// Name returns the provider name
+func (nominatimGeocoder) Name() string {
+	return "nominatim"
+}
+
+// Geocode converts an address into normalized results using Nominatim
+func (g nominatimGeocoder) Geocode(ctx context.Context, address string) ([]GeocodeResult, error) {
+	data, err := g.s.fetchNominatimGeocodingData(ctx, address)
+	return g.normalizeResponse(data, err)
+}
+
+// ReverseGeocode converts a coordinate into normalized results using Nominatim
+func (g nominatimGeocoder) ReverseGeocode(ctx context.Context, lat, lon float64) ([]GeocodeResult, error) {
+	data, err := g.s.fetchNominatimReverseGeocodingData(ctx, lat, lon)
+	return g.normalizeResponse(data, err)
+}
+
+// normalizeResponse converts every result of a Nominatim response
+func (g nominatimGeocoder) normalizeResponse(data []NominatimGeocodingResult, err error) ([]GeocodeResult, error) {
+	if data != nil && len(data) == 0 {
+		return nil, ErrNoResults
+	}
+	if err != nil {
+		return nil, err
 	}
 
+	results := make([]GeocodeResult, 0, len(data))
+	for _, item := range data {
+		result, err := g.normalize(item)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
 	return results, nil
 }
+
+// normalize converts a Nominatim result into a GeocodeResult
+func (g nominatimGeocoder) normalize(item NominatimGeocodingResult) (GeocodeResult, error) {
+	lat, err := strconv.ParseFloat(item.Lat, 64)
+	if err != nil {
+		return GeocodeResult{}, fmt.Errorf("error parsing latitude %q: %v", item.Lat, err)
+	}
+	lon, err := strconv.ParseFloat(item.Lon, 64)
+	if err != nil {
+		return GeocodeResult{}, fmt.Errorf("error parsing longitude %q: %v", item.Lon, err)
+	}
+
+	address := item.Address
+	components := make(map[string]string)
+	setComponent(components, "name", item.Name)
+	setComponent(components, "type", item.AddressType)
+	setComponent(components, "housenumber", address.HouseNumber)
+	setComponent(components, "street", address.Road)
+	setComponent(components, "suburb", firstNonEmpty(address.Suburb, address.Neighbourhood))
+	setComponent(components, "city", firstNonEmpty(address.City, address.Town, address.Village, address.Municipality))
+	setComponent(components, "county", address.County)
+	setComponent(components, "state", address.State)
+	setComponent(components, "postcode", address.Postcode)
+	setComponent(components, "country", address.Country)
+	setComponent(components, "country_code", address.CountryCode)
+
+	// The ISO 3166-2 code is prefixed with the country, e.g. BR-SP
+	if _, code, ok := strings.Cut(address.StateCode, "-"); ok {
+		setComponent(components, "state_code", code)
+	}
+
+	result := GeocodeResult{
+		Lat:              lat,
+		Lon:              lon,
+		FormattedAddress: item.DisplayName,
+		Components:       components,
+		Confidence:       clampConfidence(item.Importance),
+		Provider:         g.Name(),
+	}
+
+	// Nominatim returns the bounding box as [minlat, maxlat, minlon, maxlon]
+	if len(item.BoundingBox) == 4 {
+		var bbox [4]float64
+		for i, value := range item.BoundingBox {
+			if bbox[i], err = strconv.ParseFloat(value, 64); err != nil {
+				return GeocodeResult{}, fmt.Errorf("error parsing bounding box %q: %v", value, err)
+			}
+		}
+		result.BoundingBox = &BoundingBox{
+			South: bbox[0],
+			North: bbox[1],
+			West:  bbox[2],
+			East:  bbox[3],
+		}
+	}
+
+	return result, nil
+}
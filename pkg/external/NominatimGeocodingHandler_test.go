@@ -0,0 +1,124 @@
+package external
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/igorsilvestre/simple-go-server/pkg/config"
+)
+
+func TestNominatimGeocodingHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		fixture    fixture
+		target     string
+		requests   int
+		wantStatus int
+		wantBody   string
+		wantCalls  int
+	}{
+		{
+			name:       "success is cached",
+			fixture:    recorded("nominatim/search_ok.json"),
+			target:     "/external/geocode-nominatim?address=Conjunto+Nacional,+Avenida+Paulista",
+			requests:   2,
+			wantStatus: http.StatusOK,
+			wantBody:   `"lat":"-23.5617127"`,
+			wantCalls:  1,
+		},
+		{
+			name:       "zero results are a cached no_results problem",
+			fixture:    recorded("nominatim/search_empty.json"),
+			target:     "/external/geocode-nominatim?address=zzzz+nowhere+zzzz",
+			requests:   2,
+			wantStatus: http.StatusNotFound,
+			wantBody:   `"code":"no_results","detail":"no geocoding results found for address: zzzz nowhere zzzz"`,
+			wantCalls:  1,
+		},
+		{
+			name:       "non-200",
+			fixture:    inline(http.StatusTooManyRequests, "Too Many Requests"),
+			target:     "/external/geocode-nominatim?address=Avenida+Paulista",
+			requests:   2,
+			wantStatus: http.StatusBadGateway,
+			wantBody:   "non-200 response from Nominatim (429)",
+			wantCalls:  2,
+		},
+		{
+			name:       "malformed JSON",
+			fixture:    inline(http.StatusOK, `[{"lat": `),
+			target:     "/external/geocode-nominatim?address=Avenida+Paulista",
+			requests:   1,
+			wantStatus: http.StatusBadGateway,
+			wantBody:   "error parsing response",
+			wantCalls:  1,
+		},
+		{
+			name:       "missing address",
+			fixture:    recorded("nominatim/search_ok.json"),
+			target:     "/external/geocode-nominatim",
+			requests:   1,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `"code":"missing_parameter"`,
+			wantCalls:  0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			upstream.handle(config.Nominatim, "/search", tt.fixture)
+			router := newTestRouter(t, testConfig(), upstream.urls())
+
+			for i := 0; i < tt.requests; i++ {
+				w := serve(router, http.MethodGet, tt.target, "")
+				if w.Code != tt.wantStatus {
+					t.Fatalf("request %d: status = %d, want %d: %s", i, w.Code, tt.wantStatus, w.Body)
+				}
+				if !strings.Contains(w.Body.String(), tt.wantBody) {
+					t.Errorf("request %d: body = %s, want it to contain %s", i, w.Body, tt.wantBody)
+				}
+			}
+			if calls := upstream.callCount(config.Nominatim); calls != tt.wantCalls {
+				t.Errorf("upstream calls = %d, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestNominatimGeocoderAddressComponents(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	// Only requests asking for the address breakdown match the fixture
+	upstream.handle(config.Nominatim, "/search?addressdetails=1", recorded("nominatim/search_ok.json"))
+	router := newTestRouter(t, testConfig(), upstream.urls())
+
+	w := serve(router, http.MethodGet, "/external/geocode?provider=nominatim&address=Conjunto+Nacional", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	response := decodeJSON[GeocodeResponse](t, w)
+	if len(response.Results) != 1 {
+		t.Fatalf("results = %d, want 1", len(response.Results))
+	}
+
+	want := map[string]string{
+		"name":         "Conjunto Nacional",
+		"type":         "building",
+		"housenumber":  "2073",
+		"street":       "Avenida Paulista",
+		"suburb":       "Bela Vista",
+		"city":         "São Paulo",
+		"state":        "São Paulo",
+		"state_code":   "SP",
+		"postcode":     "01311-300",
+		"country":      "Brasil",
+		"country_code": "br",
+	}
+	components := response.Results[0].Components
+	for key, value := range want {
+		if components[key] != value {
+			t.Errorf("component %s = %q, want %q", key, components[key], value)
+		}
+	}
+}
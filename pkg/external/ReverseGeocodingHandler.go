@@ -0,0 +1,106 @@
+package external
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/igorsilvestre/simple-go-server/pkg/problem"
+)
+
+// defaultReverseGeocodingProvider is used when the request does not select a provider
+const defaultReverseGeocodingProvider = "google"
+
+// reverseGeocodingProvider returns the default provider, or the first enabled one supporting
+// reverse geocoding when the default is not configured
+func (s *Service) reverseGeocodingProvider() string {
+	if s.config.Providers.Enabled(defaultReverseGeocodingProvider) {
+		return defaultReverseGeocodingProvider
+	}
+	for _, name := range s.geocodingProviderOrder() {
+		if _, ok := s.geocoders[name].(ReverseGeocoder); ok {
+			return name
+		}
+	}
+	return defaultReverseGeocodingProvider
+}
+
+// reverseGeocodingKeyPrecision is the number of decimals kept in reverse geocoding cache keys
+// (five decimals is roughly one metre)
+const reverseGeocodingKeyPrecision = 5
+
+// parseCoordinate parses and validates a latitude or longitude query parameter
+func parseCoordinate(value, name string, limit float64) (float64, *problem.Problem) {
+	if value == "" {
+		return 0, problem.Missing(name)
+	}
+	coordinate, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, problem.Invalid(name, fmt.Sprintf("Invalid '%s' query parameter: %s", name, value))
+	}
+	if coordinate < -limit || coordinate > limit {
+		return 0, problem.Invalid(name, fmt.Sprintf("'%s' must be between %g and %g", name, -limit, limit))
+	}
+	return coordinate, nil
+}
+
+// reverseGeocodingCacheKey builds a cache key for a coordinate rounded to a fixed precision
+func reverseGeocodingCacheKey(provider string, lat, lon float64) string {
+	return fmt.Sprintf("%s:%.*f,%.*f",
+		provider, reverseGeocodingKeyPrecision, lat, reverseGeocodingKeyPrecision, lon)
+}
+
+// cachedReverseGeocode reverse geocodes a coordinate with the given provider, using GlobalCache when possible
+func (s *Service) cachedReverseGeocode(ctx context.Context, geocoder ReverseGeocoder, lat, lon float64) (*GeocodeResponse, error) {
+	cacheKey := reverseGeocodingCacheKey(geocoder.Name(), lat, lon)
+
+	// Use the cached data or fetch it once for every concurrent request
+	return s.reverseGeocodingCache.Fetch(ctx, cacheKey, func(ctx context.Context) (*GeocodeResponse, error) {
+		results, err := geocoder.ReverseGeocode(ctx, lat, lon)
+		if err != nil {
+			return nil, err
+		}
+		return &GeocodeResponse{Provider: geocoder.Name(), Results: results}, nil
+	})
+}
+
+// ReverseGeocodingHandler handles requests that convert coordinates into addresses
+func (s *Service) ReverseGeocodingHandler(w http.ResponseWriter, r *http.Request) {
+	lat, p := parseCoordinate(r.URL.Query().Get("lat"), "lat", 90)
+	if p != nil {
+		problem.Write(w, r, p)
+		return
+	}
+	lon, p := parseCoordinate(r.URL.Query().Get("lon"), "lon", 180)
+	if p != nil {
+		problem.Write(w, r, p)
+		return
+	}
+
+	provider := r.URL.Query().Get("provider")
+	if provider == "" {
+		provider = s.reverseGeocodingProvider()
+	}
+
+	geocoder, err := s.lookupGeocoder(provider)
+	if err != nil {
+		problem.Write(w, r, providerProblem(provider, err))
+		return
+	}
+	reverseGeocoder, ok := geocoder.(ReverseGeocoder)
+	if !ok {
+		problem.Write(w, r, problem.Invalid("provider", "Provider does not support reverse geocoding: "+provider))
+		return
+	}
+
+	response, err := s.cachedReverseGeocode(r.Context(), reverseGeocoder, lat, lon)
+	if err != nil {
+		problem.Write(w, r, upstreamProblem(reverseGeocoder.Name(), err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,133 @@
+package external
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/igorsilvestre/simple-go-server/pkg/config"
+)
+
+func TestReverseGeocodingHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		provider   string
+		path       string
+		fixture    fixture
+		target     string
+		requests   int
+		wantStatus int
+		wantBody   string
+		wantCalls  int
+	}{
+		{
+			name:       "google is the default and is cached",
+			provider:   config.Google,
+			path:       "/geocode/json",
+			fixture:    recorded("google/geocode_ok.json"),
+			target:     "/external/reverse-geocode?lat=-23.5613991&lon=-46.6558621",
+			requests:   2,
+			wantStatus: http.StatusOK,
+			wantBody:   `"provider":"google"`,
+			wantCalls:  1,
+		},
+		{
+			name:       "nearby coordinates share the cache entry",
+			provider:   config.Google,
+			path:       "/geocode/json",
+			fixture:    recorded("google/geocode_ok.json"),
+			target:     "/external/reverse-geocode?lat=-23.561399100001&lon=-46.6558621",
+			requests:   2,
+			wantStatus: http.StatusOK,
+			wantBody:   `"formatted_address":"Av. Paulista, 1578 - Bela Vista, São Paulo - SP, 01310-200, Brasil"`,
+			wantCalls:  1,
+		},
+		{
+			name:       "zero results are a no_results problem",
+			provider:   config.Google,
+			path:       "/geocode/json",
+			fixture:    recorded("google/geocode_zero_results.json"),
+			target:     "/external/reverse-geocode?lat=0&lon=-160",
+			requests:   2,
+			wantStatus: http.StatusNotFound,
+			wantBody:   `"provider":"google","status":404`,
+			wantCalls:  1,
+		},
+		{
+			name:       "selected provider",
+			provider:   config.Nominatim,
+			path:       "/reverse",
+			fixture:    recorded("nominatim/reverse_ok.json"),
+			target:     "/external/reverse-geocode?provider=nominatim&lat=-23.5617127&lon=-46.6560481",
+			requests:   1,
+			wantStatus: http.StatusOK,
+			wantBody:   `"provider":"nominatim"`,
+			wantCalls:  1,
+		},
+		{
+			name:       "nominatim error object is a no_results problem",
+			provider:   config.Nominatim,
+			path:       "/reverse",
+			fixture:    recorded("nominatim/reverse_not_found.json"),
+			target:     "/external/reverse-geocode?provider=nominatim&lat=0&lon=-160",
+			requests:   1,
+			wantStatus: http.StatusNotFound,
+			wantBody:   `"provider":"nominatim","status":404`,
+			wantCalls:  1,
+		},
+		{
+			name:       "non-200",
+			provider:   config.Google,
+			path:       "/geocode/json",
+			fixture:    inline(http.StatusInternalServerError, "internal error"),
+			target:     "/external/reverse-geocode?lat=-23.5613991&lon=-46.6558621",
+			requests:   2,
+			wantStatus: http.StatusBadGateway,
+			wantBody:   `"provider":"google"`,
+			wantCalls:  2,
+		},
+		{
+			name:       "malformed JSON",
+			provider:   config.Nominatim,
+			path:       "/reverse",
+			fixture:    inline(http.StatusOK, `{"lat": `),
+			target:     "/external/reverse-geocode?provider=nominatim&lat=-23.5617127&lon=-46.6560481",
+			requests:   1,
+			wantStatus: http.StatusBadGateway,
+			wantBody:   "error parsing response",
+			wantCalls:  1,
+		},
+		{
+			name:       "latitude out of range",
+			provider:   config.Google,
+			path:       "/geocode/json",
+			fixture:    recorded("google/geocode_ok.json"),
+			target:     "/external/reverse-geocode?lat=91&lon=0",
+			requests:   1,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "'lat' must be between -90 and 90",
+			wantCalls:  0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			upstream.handle(tt.provider, tt.path, tt.fixture)
+			router := newTestRouter(t, testConfig(), upstream.urls())
+
+			for i := 0; i < tt.requests; i++ {
+				w := serve(router, http.MethodGet, tt.target, "")
+				if w.Code != tt.wantStatus {
+					t.Fatalf("request %d: status = %d, want %d: %s", i, w.Code, tt.wantStatus, w.Body)
+				}
+				if !strings.Contains(w.Body.String(), tt.wantBody) {
+					t.Errorf("request %d: body = %s, want it to contain %s", i, w.Body, tt.wantBody)
+				}
+			}
+			if calls := upstream.callCount(tt.provider); calls != tt.wantCalls {
+				t.Errorf("upstream calls = %d, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
@@ -13,11 +13,13 @@ import (
     "context"
     "encoding/json"
     "fmt"
+    "log/slog"
     "net/http"
     "net/mail"
-    "os"
     "time"
 
+    "github.com/igorsilvestre/simple-go-server/pkg/config"
+    "github.com/igorsilvestre/simple-go-server/pkg/problem"
     "github.com/mailersend/mailersend-go"
 )
 
@@ -28,10 +30,16 @@ type EmailRequest struct {
     Recipients []string `json:"recipients"`
 }
 
-func SendEmailHandler(w http.ResponseWriter, r *http.Request) {
+// EmailFailure is a recipient MailerSend did not accept, with the reason
+type EmailFailure struct {
+    Recipient string `json:"recipient"`
+    Error     string `json:"error"`
+}
+
+func (s *Service) SendEmailHandler(w http.ResponseWriter, r *http.Request) {
     // Only allow POST requests
     if r.Method != http.MethodPost {
-        http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+        problem.Write(w, r, problem.New(http.StatusMethodNotAllowed, problem.MethodNotAllowed, "Only POST is allowed"))
         return
     }
 
@@ -40,30 +48,46 @@ func SendEmailHandler(w http.ResponseWriter, r *http.Request) {
     decoder := json.NewDecoder(r.Body)
     err := decoder.Decode(&emailReq)
     if err != nil {
-        http.Error(w, "Bad Request: Invalid JSON", http.StatusBadRequest)
+        problem.Write(w, r, problem.New(http.StatusBadRequest, problem.InvalidBody, "Invalid JSON"))
         return
     }
 
     // Validate required fields
     if emailReq.Subject == "" || emailReq.BodyHTML == "" || emailReq.Sender == "" || len(emailReq.Recipients) == 0 {
-        http.Error(w, "Bad Request: Missing required fields", http.StatusBadRequest)
+        problem.Write(w, r, problem.New(http.StatusBadRequest, problem.MissingParameter,
+            "Missing required fields: subject, body_html, sender and recipients are required"))
         return
     }
 
     // Validate sender email
     _, err = mail.ParseAddress(emailReq.Sender)
     if err != nil {
-        http.Error(w, "Bad Request: Invalid sender email address", http.StatusBadRequest)
+        problem.Write(w, r, problem.Invalid("sender", "Invalid sender email address"))
+        return
+    }
+
+    // Validate every recipient before sending anything, so an invalid address is not
+    // mistaken for a MailerSend failure
+    invalidRecipients := []string{}
+    for _, recipientEmail := range emailReq.Recipients {
+        if _, err := mail.ParseAddress(recipientEmail); err != nil {
+            invalidRecipients = append(invalidRecipients, recipientEmail)
+        }
+    }
+    if len(invalidRecipients) > 0 {
+        problem.Write(w, r, problem.Invalid("recipients", "Invalid recipient email addresses").
+            With("invalid_recipients", invalidRecipients))
         return
     }
 
     // Create an instance of the MailerSend client
-    apiKey := os.Getenv("MAILERSEND_API_KEY")
+    apiKey := s.config.Providers.APIKey(config.MailerSend)
     if apiKey == "" {
-        http.Error(w, "Server Error: Missing MailerSend API key", http.StatusInternalServerError)
+        problem.Write(w, r, problem.New(http.StatusServiceUnavailable, problem.ProviderNotConfigured,
+            "Missing MailerSend API key").WithProvider(config.MailerSend))
         return
     }
-    ms := mailersend.NewMailersend(apiKey)
+    ms := s.newMailerSend(apiKey)
 
     // Prepare the sender
     from := mailersend.From{
@@ -71,23 +95,17 @@ func SendEmailHandler(w http.ResponseWriter, r *http.Request) {
         Name:  "", // No name provided
     }
 
-    // Initialize a slice to hold message IDs
+    // Initialize slices to hold the message IDs and the recipients that failed
     var messageIDs []string
+    failures := []EmailFailure{}
 
-    // Set a context with timeout
-    ctx := context.Background()
+    // Set a context with timeout, keeping the request ID but not the cancellation of the request
+    ctx := withProvider(context.WithoutCancel(r.Context()), config.MailerSend)
     ctx, cancel := context.WithTimeout(ctx, time.Minute)
     defer cancel()
 
     // Loop over recipients and send individual emails
     for _, recipientEmail := range emailReq.Recipients {
-        // Validate recipient email
-        _, err = mail.ParseAddress(recipientEmail)
-        if err != nil {
-            // Skip invalid email addresses
-            continue
-        }
-
         // Create the email message
         message := ms.Email.NewMessage()
         message.SetFrom(from)
@@ -104,10 +122,13 @@ func SendEmailHandler(w http.ResponseWriter, r *http.Request) {
         // Send the email
         res, err := ms.Email.Send(ctx, message)
         if err != nil {
-            // Handle send error (log, continue, or return error)
-            fmt.Printf("Failed to send email to %s: %v\n", recipientEmail, err)
+            // Report the recipient and carry on with the others
+            slog.WarnContext(ctx, "Failed to send email", "recipient", recipientEmail, "error", err.Error())
+            emailsFailed.Inc()
+            failures = append(failures, EmailFailure{Recipient: recipientEmail, Error: upstreamDetail(err)})
             continue
         }
+        emailsSent.Inc()
 
         // Append the message ID to the list
         messageID := res.Header.Get("X-Message-Id")
@@ -116,16 +137,22 @@ func SendEmailHandler(w http.ResponseWriter, r *http.Request) {
 
     // Check if any emails were sent
     if len(messageIDs) == 0 {
-        http.Error(w, "Failed to send emails to any recipients", http.StatusInternalServerError)
+        problem.Write(w, r, problem.New(http.StatusBadGateway, problem.UpstreamUnavailable,
+            "Failed to send emails to any recipients").WithProvider(config.MailerSend).With("failures", failures))
         return
     }
 
-    // Respond with success and the list of message IDs
+    // Respond with the list of message IDs and the recipients that were not sent to
+    message := "Emails sent successfully"
+    if len(failures) > 0 {
+        message = fmt.Sprintf("Emails sent to %d of %d recipients", len(messageIDs), len(emailReq.Recipients))
+    }
     w.Header().Set("Content-Type", "application/json")
     w.WriteHeader(http.StatusOK)
     response := map[string]interface{}{
-        "message":     "Emails sent successfully",
+        "message":     message,
         "message_ids": messageIDs,
+        "failures":    failures,
     }
     json.NewEncoder(w).Encode(response)
 }
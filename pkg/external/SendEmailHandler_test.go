@@ -0,0 +1,146 @@
+package external
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/igorsilvestre/simple-go-server/pkg/config"
+)
+
+func TestSendEmailHandler(t *testing.T) {
+	accepted := fixture{status: http.StatusAccepted, header: map[string]string{"X-Message-Id": "5e42957d51f1d94a1070a733"}}
+
+	tests := []struct {
+		name       string
+		fixture    fixture
+		body       string
+		wantStatus int
+		wantBody   string
+		wantCalls  int
+	}{
+		{
+			name:       "one email per recipient",
+			fixture:    accepted,
+			body:       `{"subject": "O titulo", "body_html": "<p>O corpo</p>", "sender": "igor@example.com", "recipients": ["a@example.com", "b@example.com"]}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `"message_ids":["5e42957d51f1d94a1070a733","5e42957d51f1d94a1070a733"]`,
+			wantCalls:  2,
+		},
+		{
+			name:       "invalid recipient",
+			fixture:    accepted,
+			body:       `{"subject": "O titulo", "body_html": "<p>O corpo</p>", "sender": "igor@example.com", "recipients": ["not an address", "b@example.com"]}`,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `"invalid_recipients":["not an address"]`,
+			wantCalls:  0,
+		},
+		{
+			name:       "every recipient invalid",
+			fixture:    accepted,
+			body:       `{"subject": "O titulo", "body_html": "<p>O corpo</p>", "sender": "igor@example.com", "recipients": ["a", "b"]}`,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `"parameter":"recipients"`,
+			wantCalls:  0,
+		},
+		{
+			name:       "rejected by MailerSend",
+			fixture:    fromFile(http.StatusUnprocessableEntity, "mailersend/email_invalid.json"),
+			body:       `{"subject": "O titulo", "body_html": "<p>O corpo</p>", "sender": "igor@example.com", "recipients": ["a@example.com"]}`,
+			wantStatus: http.StatusBadGateway,
+			wantBody:   `"failures":[{"recipient":"a@example.com","error":`,
+			wantCalls:  1,
+		},
+		{
+			name:       "missing fields",
+			fixture:    accepted,
+			body:       `{"subject": "O titulo", "sender": "igor@example.com", "recipients": ["a@example.com"]}`,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "Missing required fields",
+			wantCalls:  0,
+		},
+		{
+			name:       "invalid sender",
+			fixture:    accepted,
+			body:       `{"subject": "O titulo", "body_html": "<p>O corpo</p>", "sender": "igor", "recipients": ["a@example.com"]}`,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "Invalid sender email address",
+			wantCalls:  0,
+		},
+		{
+			name:       "malformed JSON",
+			fixture:    accepted,
+			body:       `{"subject": `,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "Invalid JSON",
+			wantCalls:  0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			upstream.handle(config.MailerSend, "/email", tt.fixture)
+			router := newTestRouter(t, testConfig(), upstream.urls())
+
+			w := serve(router, http.MethodPost, "/external/send-email", tt.body)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("body = %s, want it to contain %s", w.Body, tt.wantBody)
+			}
+			if calls := upstream.callCount(config.MailerSend); calls != tt.wantCalls {
+				t.Errorf("upstream calls = %d, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestSendEmailHandlerReportsFailedRecipients(t *testing.T) {
+	// MailerSend accepts every recipient except the ones at bounce.example.com
+	mailerSend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var message struct {
+			To []struct {
+				Email string `json:"email"`
+			} `json:"to"`
+		}
+		json.NewDecoder(r.Body).Decode(&message)
+		if len(message.To) == 1 && strings.HasSuffix(message.To[0].Email, "@bounce.example.com") {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Write([]byte(`{"message": "The to.0.email domain is not allowed."}`))
+			return
+		}
+		w.Header().Set("X-Message-Id", "id-"+message.To[0].Email)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer mailerSend.Close()
+
+	urls := newFakeUpstream(t).urls()
+	urls.MailerSend = mailerSend.URL
+	router := newTestRouter(t, testConfig(), urls)
+
+	w := serve(router, http.MethodPost, "/external/send-email",
+		`{"subject": "O titulo", "body_html": "<p>O corpo</p>", "sender": "igor@example.com", "recipients": ["a@example.com", "b@bounce.example.com"]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	response := decodeJSON[struct {
+		Message    string         `json:"message"`
+		MessageIDs []string       `json:"message_ids"`
+		Failures   []EmailFailure `json:"failures"`
+	}](t, w)
+	if response.Message != "Emails sent to 1 of 2 recipients" {
+		t.Errorf("message = %q, want the share of recipients sent to", response.Message)
+	}
+	if !equalStrings(response.MessageIDs, []string{"id-a@example.com"}) {
+		t.Errorf("message_ids = %v, want [id-a@example.com]", response.MessageIDs)
+	}
+	if len(response.Failures) != 1 || response.Failures[0].Recipient != "b@bounce.example.com" ||
+		!strings.Contains(response.Failures[0].Error, "domain is not allowed") {
+		t.Errorf("failures = %+v, want b@bounce.example.com with the error of MailerSend", response.Failures)
+	}
+}
@@ -1,83 +1,293 @@
 package external
 
 import (
+	"container/list"
+	"encoding/json"
+	"log"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 )
 
-// Cache represents a simple in-memory cache
+// CacheStore is implemented by every cache backend
+type CacheStore interface {
+	// Get retrieves an item from the cache
+	Get(key string) (interface{}, bool)
+	// Set adds an item to the cache with an optional expiration time
+	Set(key string, value interface{}, duration time.Duration)
+	// Delete removes an item from the cache
+	Delete(key string)
+	// Clear removes all items from the cache
+	Clear()
+}
+
+// CacheInspector is implemented by cache backends that can be inspected from the admin API
+type CacheInspector interface {
+	// Stats returns the cache statistics
+	Stats() CacheStats
+	// Keys returns the first limit keys starting with prefix in sorted order, zero meaning no limit
+	Keys(prefix string, limit int) []string
+	// Inspect returns an item with its metadata without counting it as a hit
+	Inspect(key string) (CacheEntry, bool)
+	// DeletePrefix removes every item whose key starts with prefix and returns how many were removed
+	DeletePrefix(prefix string) int
+}
+
+// CachePinger is implemented by cache backends that live outside of the process and can
+// become unreachable
+type CachePinger interface {
+	// Ping checks that the backend answers
+	Ping() error
+}
+
+// CacheCounter is implemented by cache backends whose counters can be read without
+// walking their entries, so they can be scraped as often as the metrics need
+type CacheCounter interface {
+	// Counters returns the current counters of the cache
+	Counters() CacheCounters
+}
+
+// CacheCounters are the cheap subset of CacheStats exposed as metrics
+type CacheCounters struct {
+	Entries   int
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	// Bytes is the approximate size of the entries, negative when the backend does not track it
+	Bytes int64
+}
+
+// CacheEntry describes a single cached item
+type CacheEntry struct {
+	Key       string      `json:"key"`
+	Value     interface{} `json:"value"`
+	Bytes     int64       `json:"bytes"`
+	CreatedAt *time.Time  `json:"created_at,omitempty"`
+	ExpiresAt *time.Time  `json:"expires_at,omitempty"`
+	// TTL is the remaining time to live, zero for items that never expire
+	TTL time.Duration `json:"ttl"`
+}
+
+// PrefixStats describes the items sharing a key prefix such as "whois:"
+type PrefixStats struct {
+	Entries int   `json:"entries"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// cacheKeyPrefix returns the namespace of a key, up to and including the first colon
+func cacheKeyPrefix(key string) string {
+	if i := strings.IndexByte(key, ':'); i >= 0 {
+		return key[:i+1]
+	}
+	return key
+}
+
+// Cache represents a simple in-memory cache. When limits are set, the least recently
+// used items are evicted once the entry count or the estimated size exceeds them.
 type Cache struct {
-	items map[string]cacheItem
-	mu    sync.RWMutex
+	items map[string]*list.Element
+	// lru orders the items from most (front) to least (back) recently used
+	lru *list.List
+	mu  sync.Mutex
+
+	maxEntries int
+	maxBytes   int64
+	bytes      int64
+
+	evictions    uint64
+	evictedBytes uint64
+	expirations  uint64
+	hits         uint64
+	misses       uint64
+
+	// done stops the background goroutines once the cache is closed
+	done      chan struct{}
+	closeOnce sync.Once
+	// background tracks the running goroutines so Close can wait for them
+	background sync.WaitGroup
 }
 
 // cacheItem represents a single item in the cache
 type cacheItem struct {
+	key        string
 	value      interface{}
+	created    int64
 	expiration int64
+	size       int64
+}
+
+// CacheStats describes the size of a cache, how well it performs and how many items it had to drop
+type CacheStats struct {
+	Backend      string                 `json:"backend"`
+	Entries      int                    `json:"entries"`
+	Bytes        int64                  `json:"bytes"`
+	MaxEntries   int                    `json:"max_entries"`
+	MaxBytes     int64                  `json:"max_bytes"`
+	Hits         uint64                 `json:"hits"`
+	Misses       uint64                 `json:"misses"`
+	HitRatio     float64                `json:"hit_ratio"`
+	Evictions    uint64                 `json:"evictions"`
+	EvictedBytes uint64                 `json:"evicted_bytes"`
+	Expirations  uint64                 `json:"expirations"`
+	Prefixes     map[string]PrefixStats `json:"prefixes"`
+	OldestKey    string                 `json:"oldest_key,omitempty"`
+	OldestAt     *time.Time             `json:"oldest_at,omitempty"`
 }
 
-// NewCache creates a new cache instance
+// hitRatio returns the share of lookups that were served from the cache
+func hitRatio(hits, misses uint64) float64 {
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
+
+// NewCache creates a new cache instance without size limits
 func NewCache() *Cache {
 	cache := &Cache{
-		items: make(map[string]cacheItem),
+		items: make(map[string]*list.Element),
+		lru:   list.New(),
+		done:  make(chan struct{}),
 	}
-	
+
 	// Start a goroutine to periodically clean up expired items
+	cache.background.Add(1)
 	go cache.cleanupExpiredItems()
-	
+
 	return cache
 }
 
-// cleanupExpiredItems removes expired items from the cache
+// SetLimits bounds the number of entries and the estimated size in bytes of the cache.
+// A zero value disables the corresponding limit. Items are evicted immediately if the
+// cache is already over the new limits.
+func (c *Cache) SetLimits(maxEntries int, maxBytes int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.maxEntries = maxEntries
+	c.maxBytes = maxBytes
+	c.evict()
+}
+
+// Close stops the background cleanup and snapshot goroutines and waits for them to
+// return. Snapshots, if enabled, are saved one last time. The cache remains usable.
+func (c *Cache) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+	c.background.Wait()
+	return nil
+}
+
+// cleanupExpiredItems removes expired items from the cache until the cache is closed
 func (c *Cache) cleanupExpiredItems() {
+	defer c.background.Done()
+
 	ticker := time.NewTicker(5 * time.Minute)
 	defer ticker.Stop()
-	
+
+	var lastEvictions uint64
 	for {
-		<-ticker.C
+		select {
+		case <-ticker.C:
+		case <-c.done:
+			return
+		}
 		c.mu.Lock()
 		now := time.Now().UnixNano()
-		for key, item := range c.items {
-			if item.expiration > 0 && item.expiration < now {
-				delete(c.items, key)
+		for _, element := range c.items {
+			if item := element.Value.(*cacheItem); item.expired(now) {
+				c.remove(element)
+				c.expirations++
 			}
 		}
+		stats := c.stats()
 		c.mu.Unlock()
+
+		// Report evictions so the limits can be tuned from the logs
+		if stats.Evictions != lastEvictions {
+			log.Printf("Cache evicted %d items since last cleanup (entries=%d bytes=%d evictions=%d)",
+				stats.Evictions-lastEvictions, stats.Entries, stats.Bytes, stats.Evictions)
+			lastEvictions = stats.Evictions
+		}
 	}
 }
 
 // Set adds an item to the cache with an optional expiration time
 func (c *Cache) Set(key string, value interface{}, duration time.Duration) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	
 	var expiration int64
 	if duration > 0 {
 		expiration = time.Now().Add(duration).UnixNano()
 	}
-	
-	c.items[key] = cacheItem{
+
+	// Estimate the size outside the lock, it may serialize the value
+	size := estimateCacheValueSize(key, value)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.set(key, value, expiration, size)
+}
+
+// set stores an item and evicts older ones if the limits are exceeded. Callers must hold c.mu.
+func (c *Cache) set(key string, value interface{}, expiration int64, size int64) {
+	if element, found := c.items[key]; found {
+		c.remove(element)
+	}
+
+	item := &cacheItem{
+		key:        key,
 		value:      value,
+		created:    time.Now().UnixNano(),
 		expiration: expiration,
+		size:       size,
+	}
+	c.items[key] = c.lru.PushFront(item)
+	c.bytes += size
+
+	c.evict()
+}
+
+// evict drops the least recently used items until the cache fits its limits. Callers must hold c.mu.
+func (c *Cache) evict() {
+	for c.lru.Len() > 0 &&
+		((c.maxEntries > 0 && c.lru.Len() > c.maxEntries) || (c.maxBytes > 0 && c.bytes > c.maxBytes)) {
+		element := c.lru.Back()
+		c.evictions++
+		c.evictedBytes += uint64(element.Value.(*cacheItem).size)
+		c.remove(element)
 	}
 }
 
+// remove deletes an element from the cache. Callers must hold c.mu.
+func (c *Cache) remove(element *list.Element) {
+	item := element.Value.(*cacheItem)
+	c.lru.Remove(element)
+	delete(c.items, item.key)
+	c.bytes -= item.size
+}
+
 // Get retrieves an item from the cache
 func (c *Cache) Get(key string) (interface{}, bool) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	
-	item, found := c.items[key]
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, found := c.items[key]
 	if !found {
+		c.misses++
 		return nil, false
 	}
-	
+
 	// Check if the item has expired
-	if item.expiration > 0 && item.expiration < time.Now().UnixNano() {
+	item := element.Value.(*cacheItem)
+	if item.expired(time.Now().UnixNano()) {
+		c.misses++
 		return nil, false
 	}
-	
+
+	c.hits++
+	c.lru.MoveToFront(element)
 	return item.value, true
 }
 
@@ -85,17 +295,170 @@ func (c *Cache) Get(key string) (interface{}, bool) {
 func (c *Cache) Delete(key string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	
-	delete(c.items, key)
+
+	if element, found := c.items[key]; found {
+		c.remove(element)
+	}
 }
 
 // Clear removes all items from the cache
 func (c *Cache) Clear() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	
-	c.items = make(map[string]cacheItem)
+
+	c.items = make(map[string]*list.Element)
+	c.lru.Init()
+	c.bytes = 0
+}
+
+// Stats returns the current size of the cache and its eviction counters
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.stats()
+}
+
+// Counters returns the entry count, the size and the counters of the cache without
+// walking its items
+func (c *Cache) Counters() CacheCounters {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return CacheCounters{
+		Entries:   c.lru.Len(),
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+		Bytes:     c.bytes,
+	}
+}
+
+// stats builds the cache statistics. Callers must hold c.mu.
+func (c *Cache) stats() CacheStats {
+	stats := CacheStats{
+		Backend:      "memory",
+		Entries:      c.lru.Len(),
+		Bytes:        c.bytes,
+		MaxEntries:   c.maxEntries,
+		MaxBytes:     c.maxBytes,
+		Hits:         c.hits,
+		Misses:       c.misses,
+		HitRatio:     hitRatio(c.hits, c.misses),
+		Evictions:    c.evictions,
+		EvictedBytes: c.evictedBytes,
+		Expirations:  c.expirations,
+		Prefixes:     make(map[string]PrefixStats),
+	}
+
+	var oldest *cacheItem
+	for _, element := range c.items {
+		item := element.Value.(*cacheItem)
+		prefix := stats.Prefixes[cacheKeyPrefix(item.key)]
+		prefix.Entries++
+		prefix.Bytes += item.size
+		stats.Prefixes[cacheKeyPrefix(item.key)] = prefix
+
+		if oldest == nil || item.created < oldest.created {
+			oldest = item
+		}
+	}
+	if oldest != nil {
+		oldestAt := time.Unix(0, oldest.created)
+		stats.OldestKey = oldest.key
+		stats.OldestAt = &oldestAt
+	}
+
+	return stats
+}
+
+// Keys returns the first limit unexpired keys starting with prefix, in sorted order.
+// A limit of zero returns every key.
+func (c *Cache) Keys(prefix string, limit int) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := []string{}
+	now := time.Now().UnixNano()
+	for key, element := range c.items {
+		if strings.HasPrefix(key, prefix) && !element.Value.(*cacheItem).expired(now) {
+			keys = append(keys, key)
+		}
+	}
+	return firstSortedKeys(keys, limit)
+}
+
+// firstSortedKeys sorts keys and keeps the first limit of them, zero meaning no limit
+func firstSortedKeys(keys []string, limit int) []string {
+	sort.Strings(keys)
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+	return keys
+}
+
+// Inspect returns an item with its metadata without counting it as a hit or refreshing its recency
+func (c *Cache) Inspect(key string) (CacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, found := c.items[key]
+	if !found {
+		return CacheEntry{}, false
+	}
+	item := element.Value.(*cacheItem)
+	now := time.Now()
+	if item.expired(now.UnixNano()) {
+		return CacheEntry{}, false
+	}
+
+	createdAt := time.Unix(0, item.created)
+	entry := CacheEntry{
+		Key:       key,
+		Value:     item.value,
+		Bytes:     item.size,
+		CreatedAt: &createdAt,
+	}
+	if item.expiration > 0 {
+		expiresAt := time.Unix(0, item.expiration)
+		entry.ExpiresAt = &expiresAt
+		entry.TTL = expiresAt.Sub(now)
+	}
+	return entry, true
+}
+
+// DeletePrefix removes every item whose key starts with prefix
+func (c *Cache) DeletePrefix(prefix string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := 0
+	for key, element := range c.items {
+		if strings.HasPrefix(key, prefix) {
+			c.remove(element)
+			removed++
+		}
+	}
+	return removed
+}
+
+// expired reports whether the item expired before now
+func (item *cacheItem) expired(now int64) bool {
+	return item.expiration > 0 && item.expiration < now
+}
+
+// cacheItemOverhead approximates the memory used by the bookkeeping of a single item
+const cacheItemOverhead = 128
+
+// estimateCacheValueSize approximates the memory used by an item from its serialized size
+func estimateCacheValueSize(key string, value interface{}) int64 {
+	size := int64(len(key) + cacheItemOverhead)
+	if data, err := json.Marshal(value); err == nil {
+		size += int64(len(data))
+	}
+	return size
 }
 
-// GlobalCache is a singleton instance of Cache that can be used throughout the application
-var GlobalCache = NewCache()
\ No newline at end of file
+// GlobalCache is the cache backend used throughout the application. It defaults to an
+// in-memory Cache and can be replaced at startup, before the routes are served.
+var GlobalCache CacheStore = NewCache()
@@ -0,0 +1,90 @@
+package external
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// cacheTypes maps the names used in serialized cache values to their Go types, so values
+// read back from a remote or persisted cache keep the type the handlers stored
+var (
+	cacheTypesMu   sync.RWMutex
+	cacheTypes     = make(map[string]reflect.Type)
+	cacheTypeNames = make(map[reflect.Type]string)
+)
+
+// encodedCacheValue is the serialized form of a cache value
+type encodedCacheValue struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+// RegisterCacheType registers the type of sample under name so it can be serialized by cache backends
+func RegisterCacheType(name string, sample interface{}) {
+	cacheTypesMu.Lock()
+	defer cacheTypesMu.Unlock()
+
+	t := reflect.TypeOf(sample)
+	cacheTypes[name] = t
+	cacheTypeNames[t] = name
+}
+
+func init() {
+	RegisterCacheType("google_geocoding", &GeocodingResponse{})
+	RegisterCacheType("geoapify_geocoding", &GeoapifyResponse{})
+	RegisterCacheType("nominatim_geocoding", []NominatimGeocodingResult{})
+	RegisterCacheType("maptiler_geocoding", &MapTilerResponse{})
+	RegisterCacheType("address_autocomplete", &AutocompleteResponse{})
+	RegisterCacheType("whois", map[string]interface{}{})
+	RegisterCacheType("geocode", &GeocodeResponse{})
+	RegisterCacheType("policy_entry", &cachePolicyEntry{})
+}
+
+// encodeCacheValue serializes a value of a registered type together with its type name
+func encodeCacheValue(value interface{}) ([]byte, error) {
+	cacheTypesMu.RLock()
+	name, ok := cacheTypeNames[reflect.TypeOf(value)]
+	cacheTypesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unregistered cache value type: %T", value)
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding cache value: %v", err)
+	}
+
+	return json.Marshal(encodedCacheValue{Type: name, Value: data})
+}
+
+// decodeCacheValue restores a value serialized by encodeCacheValue with its original type
+func decodeCacheValue(data []byte) (interface{}, error) {
+	var encoded encodedCacheValue
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return nil, fmt.Errorf("error decoding cache value: %v", err)
+	}
+
+	cacheTypesMu.RLock()
+	t, ok := cacheTypes[encoded.Type]
+	cacheTypesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unregistered cache value type: %s", encoded.Type)
+	}
+
+	// Pointer types are decoded into a new value of the element type
+	if t.Kind() == reflect.Ptr {
+		value := reflect.New(t.Elem())
+		if err := json.Unmarshal(encoded.Value, value.Interface()); err != nil {
+			return nil, fmt.Errorf("error decoding %s cache value: %v", encoded.Type, err)
+		}
+		return value.Interface(), nil
+	}
+
+	value := reflect.New(t)
+	if err := json.Unmarshal(encoded.Value, value.Interface()); err != nil {
+		return nil, fmt.Errorf("error decoding %s cache value: %v", encoded.Type, err)
+	}
+	return value.Elem().Interface(), nil
+}
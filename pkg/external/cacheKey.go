@@ -0,0 +1,99 @@
+package external
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// streetTypeAbbreviations expands the common Brazilian street type abbreviations so that
+// "Av. Paulista" and "Avenida Paulista" share a cache key. "Al" (alameda) is left out on
+// purpose: it is also the state code of Alagoas.
+var streetTypeAbbreviations = map[string]string{
+	"av":   "avenida",
+	"avd":  "avenida",
+	"r":    "rua",
+	"rod":  "rodovia",
+	"trav": "travessa",
+	"tv":   "travessa",
+	"est":  "estrada",
+	"estr": "estrada",
+	"pc":   "praca",
+	"pca":  "praca",
+	"lgo":  "largo",
+	"serv": "servidao",
+}
+
+// normalizeAddressKey folds an address into the form used in cache keys: lowercase,
+// without accents, with collapsed whitespace and expanded street type abbreviations.
+// Only the cache key is normalized, upstream providers still receive the original address.
+func normalizeAddressKey(address string) string {
+	folded := strings.ToLower(stripAccents(address))
+
+	var segments []string
+	for i, segment := range strings.Split(folded, ",") {
+		// Dots only separate abbreviations from the next word ("av.paulista")
+		words := strings.Fields(strings.ReplaceAll(segment, ".", " "))
+		if len(words) == 0 {
+			continue
+		}
+
+		// Street types only start the first part of an address, later parts hold the
+		// neighbourhood, the city or the state ("Maceió, AL")
+		if i == 0 {
+			words[0] = expandStreetType(strings.TrimSpace(segment), words)
+		}
+		segments = append(segments, strings.Join(words, " "))
+	}
+
+	return strings.Join(segments, ", ")
+}
+
+// expandStreetType returns the expanded street type of the first word of segment. A word
+// is only treated as an abbreviation when a dot or another word follows it, so a lone "R"
+// or "Est" is kept as typed.
+func expandStreetType(segment string, words []string) string {
+	word := words[0]
+	expanded, ok := streetTypeAbbreviations[word]
+	if !ok {
+		return word
+	}
+	dotted := strings.HasPrefix(segment, word+".")
+	if !dotted && len(words) == 1 {
+		return word
+	}
+	return expanded
+}
+
+// normalizeQueryKey folds a search prefix into the form used in cache keys: lowercase,
+// without accents and with collapsed whitespace. Unlike normalizeAddressKey it keeps every
+// word as typed, since an autocomplete prefix such as "Est" is not an abbreviation.
+func normalizeQueryKey(query string) string {
+	return strings.Join(strings.Fields(strings.ToLower(stripAccents(query))), " ")
+}
+
+// stripAccents removes the diacritics of a string ("São João" becomes "Sao Joao")
+func stripAccents(value string) string {
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	stripped, _, err := transform.String(t, value)
+	if err != nil {
+		return value
+	}
+	return stripped
+}
+
+// normalizeDomainKey converts a domain into its lowercase ASCII (punycode) form, so
+// "Exemplo.com.BR", "exemplo.com.br." and their IDN spellings share a cache key
+func normalizeDomainKey(domain string) string {
+	domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+
+	ascii, err := idna.Lookup.ToASCII(domain)
+	if err != nil {
+		return domain
+	}
+	return ascii
+}
@@ -0,0 +1,96 @@
+package external
+
+import "testing"
+
+func TestNormalizeAddressKey(t *testing.T) {
+	tests := []struct {
+		address string
+		want    string
+	}{
+		{"Av. Paulista, 1578", "avenida paulista, 1578"},
+		{"av.paulista,1578", "avenida paulista, 1578"},
+		{"AVENIDA  Paulista ,  1578", "avenida paulista, 1578"},
+		{"R. Augusta, 100", "rua augusta, 100"},
+		{"R Augusta 100", "rua augusta 100"},
+		{"Praça da Sé", "praca da se"},
+		{"Pç da Sé", "praca da se"},
+		{"Pça. da Sé", "praca da se"},
+		{"Est. do Mar", "estrada do mar"},
+		// State codes and later address parts are never expanded
+		{"Maceió, AL", "maceio, al"},
+		{"Rua do Sol, Centro, Maceió, AL", "rua do sol, centro, maceio, al"},
+		{"Rua Augusta, R. Oscar Freire", "rua augusta, r oscar freire"},
+		{"Al Santos, 100", "al santos, 100"},
+		// A lone word is not an abbreviation
+		{"R", "r"},
+		{"Est", "est"},
+		{"Al", "al"},
+		{"Av.", "avenida"},
+		// Empty parts are dropped
+		{" , Paulista,, ", "paulista"},
+	}
+
+	for _, tt := range tests {
+		if got := normalizeAddressKey(tt.address); got != tt.want {
+			t.Errorf("normalizeAddressKey(%q) = %q, want %q", tt.address, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeAddressKeyCollisions(t *testing.T) {
+	distinct := [][2]string{
+		{"Maceió, AL", "Maceió, Alameda"},
+		{"Est", "Estrada"},
+		{"R", "Rua"},
+		{"Av", "Avenida"},
+	}
+	for _, pair := range distinct {
+		if a, b := normalizeAddressKey(pair[0]), normalizeAddressKey(pair[1]); a == b {
+			t.Errorf("%q and %q share the key %q", pair[0], pair[1], a)
+		}
+	}
+}
+
+func TestNormalizeQueryKey(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"Al", "al"},
+		{"Est", "est"},
+		{"Av. Paulista", "av. paulista"},
+		{"  São   João ", "sao joao"},
+		{"RUA Augusta, 1", "rua augusta, 1"},
+	}
+	for _, tt := range tests {
+		if got := normalizeQueryKey(tt.query); got != tt.want {
+			t.Errorf("normalizeQueryKey(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+
+	// Autocomplete prefixes must not share the suggestions of the words they abbreviate
+	for _, pair := range [][2]string{{"Al", "Alameda"}, {"Est", "Estrada"}, {"Av", "Avenida"}} {
+		if normalizeQueryKey(pair[0]) == normalizeQueryKey(pair[1]) {
+			t.Errorf("%q and %q share a key", pair[0], pair[1])
+		}
+	}
+}
+
+func TestNormalizeDomainKey(t *testing.T) {
+	tests := []struct {
+		domain string
+		want   string
+	}{
+		{"Example.COM", "example.com"},
+		{" example.com.br. ", "example.com.br"},
+		{"São-Paulo.com.br", "xn--so-paulo-rza.com.br"},
+		{"xn--so-paulo-rza.com.br", "xn--so-paulo-rza.com.br"},
+		// Invalid names are kept as typed, lowercased
+		{"exa mple.com", "exa mple.com"},
+	}
+	for _, tt := range tests {
+		if got := normalizeDomainKey(tt.domain); got != tt.want {
+			t.Errorf("normalizeDomainKey(%q) = %q, want %q", tt.domain, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package external
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/igorsilvestre/simple-go-server/pkg/config"
+)
+
+const (
+	// defaultStaleTTL is how long an expired entry keeps being served while it is refreshed
+	defaultStaleTTL = time.Hour
+	// defaultNegativeTTL is how long a "not found" outcome is cached
+	defaultNegativeTTL = 10 * time.Minute
+)
+
+// CachePolicy controls how long the results of an endpoint are cached
+type CachePolicy struct {
+	// TTL is how long a successful result is considered fresh
+	TTL time.Duration
+	// StaleTTL is how long after TTL an expired result is still served immediately while
+	// a background refresh runs. Zero disables stale-while-revalidate.
+	StaleTTL time.Duration
+	// NegativeTTL is how long a "not found" outcome is cached. Zero disables negative caching.
+	NegativeTTL time.Duration
+}
+
+// defaultCachePolicies holds the policy of every cache namespace
+var defaultCachePolicies = map[string]CachePolicy{
+	"whois":                {TTL: 24 * time.Hour, StaleTTL: defaultStaleTTL, NegativeTTL: defaultNegativeTTL},
+	"address_autocomplete": {TTL: 24 * time.Hour, StaleTTL: defaultStaleTTL, NegativeTTL: defaultNegativeTTL},
+	"google_geocoding":     {TTL: 24 * time.Hour, StaleTTL: defaultStaleTTL, NegativeTTL: defaultNegativeTTL},
+	"geoapify_geocoding":   {TTL: 1000 * time.Hour, StaleTTL: defaultStaleTTL, NegativeTTL: defaultNegativeTTL},
+	"nominatim_geocoding":  {TTL: 24 * time.Hour, StaleTTL: defaultStaleTTL, NegativeTTL: defaultNegativeTTL},
+	"maptiler_geocoding":   {TTL: 24 * time.Hour, StaleTTL: defaultStaleTTL, NegativeTTL: defaultNegativeTTL},
+	"geocode":              {TTL: 24 * time.Hour, StaleTTL: defaultStaleTTL, NegativeTTL: defaultNegativeTTL},
+	"reverse_geocoding":    {TTL: 24 * time.Hour, StaleTTL: defaultStaleTTL, NegativeTTL: defaultNegativeTTL},
+}
+
+// resolveCachePolicies applies the configured overrides on top of the default policies
+func resolveCachePolicies(overrides map[string]config.CachePolicyConfig) map[string]CachePolicy {
+	policies := make(map[string]CachePolicy, len(defaultCachePolicies))
+	for name, policy := range defaultCachePolicies {
+		policies[name] = policy
+	}
+
+	for name, override := range overrides {
+		policy, ok := policies[name]
+		if !ok {
+			log.Printf("Ignoring cache policy for unknown namespace: %s", name)
+			continue
+		}
+		if override.TTL != nil {
+			policy.TTL = *override.TTL
+		}
+		if override.StaleTTL != nil {
+			policy.StaleTTL = *override.StaleTTL
+		}
+		if override.NegativeTTL != nil {
+			policy.NegativeTTL = *override.NegativeTTL
+		}
+		policies[name] = policy
+	}
+
+	return policies
+}
+
+// cachePolicyFor returns the default policy of a cache namespace
+func cachePolicyFor(namespace string) CachePolicy {
+	if policy, ok := defaultCachePolicies[namespace]; ok {
+		return policy
+	}
+	return CachePolicy{TTL: 24 * time.Hour}
+}
+
+// emptyResult is implemented by upstream responses that can report "no results" without an error
+type emptyResult interface {
+	noResults() bool
+}
+
+// isNotFound reports whether an upstream outcome means the lookup found nothing
+func isNotFound(value interface{}, err error) bool {
+	if err != nil {
+		return errors.Is(err, ErrNoResults)
+	}
+	empty, ok := value.(emptyResult)
+	return ok && empty.noResults()
+}
+
+// cachePolicyEntry wraps a cached value with the metadata used by the policy layer
+type cachePolicyEntry struct {
+	Value      interface{}
+	FreshUntil time.Time
+	// Error is the message of a cached "not found" error
+	Error string
+}
+
+// cachedNotFoundError replays a cached "not found" error, still matching ErrNoResults
+type cachedNotFoundError struct {
+	message string
+}
+
+func (e cachedNotFoundError) Error() string {
+	return e.message
+}
+
+func (e cachedNotFoundError) Unwrap() error {
+	return ErrNoResults
+}
+
+// result returns the cached value and error
+func (e *cachePolicyEntry) result() (interface{}, error) {
+	if e.Error != "" {
+		return e.Value, cachedNotFoundError{message: e.Error}
+	}
+	return e.Value, nil
+}
+
+// MarshalJSON serializes the wrapped value with its registered type
+func (e *cachePolicyEntry) MarshalJSON() ([]byte, error) {
+	var value json.RawMessage
+	if e.Value != nil {
+		var err error
+		if value, err = encodeCacheValue(e.Value); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(struct {
+		Value      json.RawMessage `json:"value,omitempty"`
+		FreshUntil time.Time       `json:"fresh_until"`
+		Error      string          `json:"error,omitempty"`
+	}{value, e.FreshUntil, e.Error})
+}
+
+// UnmarshalJSON restores the wrapped value with its registered type
+func (e *cachePolicyEntry) UnmarshalJSON(data []byte) error {
+	var encoded struct {
+		Value      json.RawMessage `json:"value"`
+		FreshUntil time.Time       `json:"fresh_until"`
+		Error      string          `json:"error"`
+	}
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return err
+	}
+
+	e.FreshUntil = encoded.FreshUntil
+	e.Error = encoded.Error
+	e.Value = nil
+	if len(encoded.Value) > 0 {
+		value, err := decodeCacheValue(encoded.Value)
+		if err != nil {
+			return err
+		}
+		e.Value = value
+	}
+	return nil
+}
+
+// storeCachePolicyEntry caches an upstream outcome according to the policy. Successful
+// results are kept for TTL plus the stale window, "not found" outcomes for NegativeTTL
+// and any other error is not cached.
+func storeCachePolicyEntry(key string, policy CachePolicy, now time.Time, value interface{}, err error) {
+	switch {
+	case isNotFound(value, err):
+		if policy.NegativeTTL <= 0 {
+			return
+		}
+		entry := &cachePolicyEntry{Value: value, FreshUntil: now.Add(policy.NegativeTTL)}
+		if err != nil {
+			entry.Error = err.Error()
+		}
+		GlobalCache.Set(key, entry, policy.NegativeTTL)
+	case err == nil:
+		entry := &cachePolicyEntry{Value: value, FreshUntil: now.Add(policy.TTL)}
+		GlobalCache.Set(key, entry, policy.TTL+policy.StaleTTL)
+	}
+}
@@ -0,0 +1,230 @@
+package external
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/igorsilvestre/simple-go-server/pkg/config"
+	"github.com/igorsilvestre/simple-go-server/pkg/logging"
+	"github.com/igorsilvestre/simple-go-server/pkg/tracing"
+)
+
+// useTestGlobalCache replaces GlobalCache with an empty cache for the duration of the test
+func useTestGlobalCache(t *testing.T) *Cache {
+	t.Helper()
+	previous := GlobalCache
+	cache := NewCache()
+	GlobalCache = cache
+	t.Cleanup(func() {
+		waitForFlights(t)
+		cache.Close()
+		GlobalCache = previous
+	})
+	return cache
+}
+
+// testClock is a clock moved forward by the test
+type testClock struct {
+	now atomic.Int64
+}
+
+func newTestClock() *testClock {
+	c := &testClock{}
+	c.now.Store(time.Now().UnixNano())
+	return c
+}
+
+func (c *testClock) Now() time.Time {
+	return time.Unix(0, c.now.Load())
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.now.Add(int64(d))
+}
+
+func TestFetchServesStaleWhileRefreshing(t *testing.T) {
+	cache := useTestGlobalCache(t)
+	clock := newTestClock()
+	typed := &TypedCache[*GeocodeResponse]{
+		namespace: "geocode",
+		policy:    CachePolicy{TTL: time.Hour, StaleTTL: time.Hour},
+		now:       clock.Now,
+	}
+
+	var calls atomic.Int32
+	refreshing, release := make(chan struct{}), make(chan struct{})
+	fetch := func(ctx context.Context) (*GeocodeResponse, error) {
+		if calls.Add(1) > 1 {
+			close(refreshing)
+			<-release
+		}
+		return &GeocodeResponse{Provider: fmt.Sprintf("call %d", calls.Load())}, nil
+	}
+
+	first, err := typed.Fetch(context.Background(), "paulista", fetch)
+	if err != nil || first.Provider != "call 1" {
+		t.Fatalf("first fetch = %v, %v, want call 1", first, err)
+	}
+	// Successful results are kept for the fresh and the stale window
+	if entry, _ := cache.Inspect(typed.Key("paulista")); entry.TTL <= time.Hour || entry.TTL > 2*time.Hour {
+		t.Errorf("cache TTL = %v, want TTL plus the stale window", entry.TTL)
+	}
+
+	// A fresh value is served without calling upstream
+	clock.Advance(30 * time.Minute)
+	if value, _ := typed.Fetch(context.Background(), "paulista", fetch); value.Provider != "call 1" || calls.Load() != 1 {
+		t.Fatalf("fresh fetch = %v after %d calls, want the cached value", value.Provider, calls.Load())
+	}
+
+	// Once expired, the stale value is returned at once while the refresh is still running
+	clock.Advance(time.Hour)
+	stale, err := typed.Fetch(context.Background(), "paulista", fetch)
+	if err != nil || stale.Provider != "call 1" {
+		t.Fatalf("stale fetch = %v, %v, want call 1", stale, err)
+	}
+	select {
+	case <-refreshing:
+	case <-time.After(time.Second):
+		t.Fatal("background refresh did not start")
+	}
+
+	close(release)
+	waitForFlights(t)
+	if calls.Load() != 2 {
+		t.Errorf("calls = %d, want one refresh", calls.Load())
+	}
+	if value, _ := typed.Fetch(context.Background(), "paulista", fetch); value.Provider != "call 2" {
+		t.Errorf("fetch after refresh = %v, want call 2", value.Provider)
+	}
+}
+
+func TestFetchRefreshesOutsideTheRequest(t *testing.T) {
+	useTestGlobalCache(t)
+	clock := newTestClock()
+	typed := &TypedCache[*GeocodeResponse]{
+		namespace: "geocode",
+		policy:    CachePolicy{TTL: time.Hour, StaleTTL: time.Hour},
+		now:       clock.Now,
+	}
+	tracer := tracing.NewWithExporter(config.TracingConfig{SampleRatio: 1, BatchTimeout: time.Hour}, &recordingExporter{})
+	defer tracer.Shutdown(context.Background())
+
+	// Each request records the trace of the handler and the context of the fetch
+	var requestTrace tracing.TraceID
+	refreshed := make(chan context.Context, 1)
+	handler := logging.Middleware(tracer.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestTrace = tracing.SpanFromContext(r.Context()).Context().TraceID
+		typed.Fetch(r.Context(), "paulista", func(ctx context.Context) (*GeocodeResponse, error) {
+			refreshed <- ctx
+			return &GeocodeResponse{}, nil
+		})
+	})))
+	serveFetch := func() context.Context {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/external/geocode", nil))
+		select {
+		case ctx := <-refreshed:
+			return ctx
+		case <-time.After(time.Second):
+			t.Fatal("fetch did not run")
+			return nil
+		}
+	}
+
+	// A miss fetches within the request, under its trace and log
+	if ctx := serveFetch(); tracing.SpanFromContext(ctx).Context().TraceID != requestTrace || logging.RequestID(ctx) == "" {
+		t.Errorf("miss fetched outside of the request")
+	}
+
+	// A background refresh runs in its own trace, without the log of the finished request
+	clock.Advance(90 * time.Minute)
+	ctx := serveFetch()
+	span := tracing.SpanFromContext(ctx)
+	if span == nil || span.Context().TraceID == requestTrace {
+		t.Errorf("refresh span = %+v, want the root of a new trace", span)
+	}
+	if id := logging.RequestID(ctx); id != "" {
+		t.Errorf("refresh belongs to request %q, want none", id)
+	}
+	waitForFlights(t)
+}
+
+func TestFetchNegativeCaching(t *testing.T) {
+	useTestGlobalCache(t)
+
+	tests := []struct {
+		name        string
+		negativeTTL time.Duration
+		err         error
+		// wantCalls is the number of upstream calls made by two fetches in a row
+		wantCalls int32
+	}{
+		{name: "not found is cached", negativeTTL: 50 * time.Millisecond, err: fmt.Errorf("%w for address: nowhere", ErrNoResults), wantCalls: 1},
+		{name: "negative caching disabled", negativeTTL: 0, err: fmt.Errorf("%w for address: nowhere", ErrNoResults), wantCalls: 2},
+		{name: "upstream failures are never cached", negativeTTL: 50 * time.Millisecond, err: errors.New("upstream unavailable"), wantCalls: 2},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			typed := &TypedCache[*GeocodeResponse]{
+				namespace: fmt.Sprintf("negative%d", i),
+				policy:    CachePolicy{TTL: time.Hour, NegativeTTL: tt.negativeTTL},
+				now:       time.Now,
+			}
+			var calls atomic.Int32
+			fetch := func(ctx context.Context) (*GeocodeResponse, error) {
+				calls.Add(1)
+				return nil, tt.err
+			}
+
+			for j := 0; j < 2; j++ {
+				_, err := typed.Fetch(context.Background(), "nowhere", fetch)
+				if err == nil || err.Error() != tt.err.Error() {
+					t.Fatalf("fetch %d error = %v, want %v", j, err, tt.err)
+				}
+				if errors.Is(tt.err, ErrNoResults) && !errors.Is(err, ErrNoResults) {
+					t.Errorf("fetch %d error = %v, want it to match ErrNoResults", j, err)
+				}
+			}
+			if calls.Load() != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", calls.Load(), tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestFetchNegativeEntryExpires(t *testing.T) {
+	useTestGlobalCache(t)
+	typed := &TypedCache[*GeocodeResponse]{
+		namespace: "geocode",
+		policy:    CachePolicy{TTL: time.Hour, StaleTTL: time.Hour, NegativeTTL: 30 * time.Millisecond},
+		now:       time.Now,
+	}
+
+	var calls atomic.Int32
+	fetch := func(ctx context.Context) (*GeocodeResponse, error) {
+		if calls.Add(1) == 1 {
+			return nil, ErrNoResults
+		}
+		return &GeocodeResponse{Provider: "google"}, nil
+	}
+
+	if _, err := typed.Fetch(context.Background(), "new street", fetch); !errors.Is(err, ErrNoResults) {
+		t.Fatalf("first fetch error = %v, want ErrNoResults", err)
+	}
+	if _, err := typed.Fetch(context.Background(), "new street", fetch); !errors.Is(err, ErrNoResults) || calls.Load() != 1 {
+		t.Fatalf("second fetch error = %v after %d calls, want the cached ErrNoResults", err, calls.Load())
+	}
+
+	// Negative entries are not served stale, the next lookup after NegativeTTL goes upstream
+	time.Sleep(50 * time.Millisecond)
+	value, err := typed.Fetch(context.Background(), "new street", fetch)
+	if err != nil || value.Provider != "google" || calls.Load() != 2 {
+		t.Errorf("fetch after NegativeTTL = %v, %v after %d calls, want a fresh upstream result", value, err, calls.Load())
+	}
+}
@@ -0,0 +1,156 @@
+package external
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// cacheSnapshotVersion is bumped whenever the snapshot format changes incompatibly
+const cacheSnapshotVersion = 1
+
+// cacheSnapshot is the on-disk representation of a Cache
+type cacheSnapshot struct {
+	Version int                 `json:"version"`
+	SavedAt time.Time           `json:"saved_at"`
+	Items   []cacheSnapshotItem `json:"items"`
+}
+
+// cacheSnapshotItem is a single cache entry in a snapshot
+type cacheSnapshotItem struct {
+	Key        string          `json:"key"`
+	Expiration int64           `json:"expiration"`
+	Value      json.RawMessage `json:"value"`
+}
+
+// SaveSnapshot writes every unexpired item to path. The file is replaced atomically so a
+// crash while saving never leaves a truncated snapshot behind.
+func (c *Cache) SaveSnapshot(path string) error {
+	snapshot := cacheSnapshot{
+		Version: cacheSnapshotVersion,
+		SavedAt: time.Now(),
+	}
+
+	// Only copy the items under the lock: encoding a large cache would hold up every
+	// lookup for as long
+	c.mu.Lock()
+	now := time.Now().UnixNano()
+	items := make([]cacheItem, 0, c.lru.Len())
+	// Walk from least to most recently used so that reloading preserves the LRU order
+	for element := c.lru.Back(); element != nil; element = element.Prev() {
+		item := element.Value.(*cacheItem)
+		if item.expired(now) {
+			continue
+		}
+		items = append(items, cacheItem{key: item.key, value: item.value, expiration: item.expiration})
+	}
+	c.mu.Unlock()
+
+	for _, item := range items {
+		value, err := encodeCacheValue(item.value)
+		if err != nil {
+			log.Printf("Skipping cache key %s in snapshot: %v", item.key, err)
+			continue
+		}
+		snapshot.Items = append(snapshot.Items, cacheSnapshotItem{
+			Key:        item.key,
+			Expiration: item.expiration,
+			Value:      value,
+		})
+	}
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("error encoding cache snapshot: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("error creating cache snapshot: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing cache snapshot: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing cache snapshot: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("error replacing cache snapshot: %v", err)
+	}
+
+	return nil
+}
+
+// LoadSnapshot restores the items saved in path, dropping the ones that expired in the
+// meantime, and returns how many were loaded. A missing snapshot is not an error.
+func (c *Cache) LoadSnapshot(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error reading cache snapshot: %v", err)
+	}
+
+	var snapshot cacheSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return 0, fmt.Errorf("error decoding cache snapshot: %v", err)
+	}
+	if snapshot.Version != cacheSnapshotVersion {
+		return 0, fmt.Errorf("unsupported cache snapshot version: %d", snapshot.Version)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	loaded := 0
+	now := time.Now().UnixNano()
+	for _, item := range snapshot.Items {
+		if item.Expiration > 0 && item.Expiration < now {
+			continue
+		}
+		value, err := decodeCacheValue(item.Value)
+		if err != nil {
+			log.Printf("Skipping cache key %s from snapshot: %v", item.Key, err)
+			continue
+		}
+		// The limits still apply, so a snapshot taken with a larger budget is trimmed
+		c.set(item.Key, value, item.Expiration, int64(len(item.Key)+len(item.Value)+cacheItemOverhead))
+		loaded++
+	}
+
+	return loaded, nil
+}
+
+// StartSnapshots saves the cache to path every interval, and a last time when the cache is closed
+func (c *Cache) StartSnapshots(path string, interval time.Duration) {
+	c.background.Add(1)
+	go func() {
+		defer c.background.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-c.done:
+				// Keep what was cached since the last tick across the restart
+				if err := c.SaveSnapshot(path); err != nil {
+					log.Printf("Failed to save cache snapshot: %v", err)
+				}
+				return
+			}
+			if err := c.SaveSnapshot(path); err != nil {
+				log.Printf("Failed to save cache snapshot: %v", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,120 @@
+package external
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestCache creates an in-memory cache that is closed with the test
+func newTestCache(t *testing.T) *Cache {
+	t.Helper()
+	cache := NewCache()
+	t.Cleanup(func() { cache.Close() })
+	return cache
+}
+
+func TestCacheSnapshotRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.json")
+
+	cache := newTestCache(t)
+	cache.Set("geocode:google:paulista", &GeocodeResponse{Provider: "google", Results: []GeocodeResult{{Lat: -23.56}}}, time.Hour)
+	cache.Set("whois:example.com", map[string]interface{}{"domain": "example.com"}, 0)
+	cache.Set("geocode:google:expired", &GeocodeResponse{Provider: "google"}, time.Nanosecond)
+	cache.Set("geocode:google:expiring", &GeocodeResponse{Provider: "google"}, 30*time.Millisecond)
+	time.Sleep(time.Millisecond)
+
+	if err := cache.SaveSnapshot(path); err != nil {
+		t.Fatalf("error saving snapshot: %v", err)
+	}
+	// The remaining item expires while the server is down
+	time.Sleep(50 * time.Millisecond)
+
+	restored := newTestCache(t)
+	loaded, err := restored.LoadSnapshot(path)
+	if err != nil {
+		t.Fatalf("error loading snapshot: %v", err)
+	}
+	if loaded != 2 {
+		t.Errorf("loaded = %d, want 2", loaded)
+	}
+	if keys := restored.Keys("", 0); !equalStrings(keys, []string{"geocode:google:paulista", "whois:example.com"}) {
+		t.Errorf("keys = %q, want the unexpired items", keys)
+	}
+
+	value, found := restored.Get("geocode:google:paulista")
+	response, ok := value.(*GeocodeResponse)
+	if !found || !ok || response.Provider != "google" || len(response.Results) != 1 || response.Results[0].Lat != -23.56 {
+		t.Errorf("geocode value = %#v, want the stored *GeocodeResponse", value)
+	}
+	if entry, _ := restored.Inspect("geocode:google:paulista"); entry.TTL <= 0 || entry.TTL > time.Hour {
+		t.Errorf("TTL = %v, want the remaining hour", entry.TTL)
+	}
+
+	value, found = restored.Get("whois:example.com")
+	if whois, ok := value.(map[string]interface{}); !found || !ok || whois["domain"] != "example.com" {
+		t.Errorf("whois value = %#v, want the stored map", value)
+	}
+	if entry, _ := restored.Inspect("whois:example.com"); entry.ExpiresAt != nil {
+		t.Errorf("expires at = %v, want no expiration", entry.ExpiresAt)
+	}
+}
+
+func TestCacheSnapshotKeepsRecency(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.json")
+
+	cache := newTestCache(t)
+	cache.Set("geocode:a", &GeocodeResponse{Provider: "a"}, 0)
+	cache.Set("geocode:b", &GeocodeResponse{Provider: "b"}, 0)
+	cache.Get("geocode:a")
+	if err := cache.SaveSnapshot(path); err != nil {
+		t.Fatalf("error saving snapshot: %v", err)
+	}
+
+	// Loading into a smaller cache keeps the most recently used item
+	restored := newTestCache(t)
+	restored.SetLimits(1, 0)
+	if _, err := restored.LoadSnapshot(path); err != nil {
+		t.Fatalf("error loading snapshot: %v", err)
+	}
+	if keys := restored.Keys("", 0); !equalStrings(keys, []string{"geocode:a"}) {
+		t.Errorf("keys = %q, want the most recently used item", keys)
+	}
+}
+
+func TestCacheSnapshotErrors(t *testing.T) {
+	dir := t.TempDir()
+	cache := newTestCache(t)
+
+	if loaded, err := cache.LoadSnapshot(filepath.Join(dir, "missing.json")); loaded != 0 || err != nil {
+		t.Errorf("missing snapshot = %d, %v, want 0, nil", loaded, err)
+	}
+
+	path := filepath.Join(dir, "future.json")
+	if err := os.WriteFile(path, []byte(`{"version": 99, "items": []}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.LoadSnapshot(path); err == nil {
+		t.Error("loading an unsupported version succeeded")
+	}
+
+	path = filepath.Join(dir, "corrupt.json")
+	if err := os.WriteFile(path, []byte(`{"version": `), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.LoadSnapshot(path); err == nil {
+		t.Error("loading a corrupt snapshot succeeded")
+	}
+
+	// Unregistered value types are skipped instead of failing the whole snapshot
+	cache.Set("custom:a", struct{ Name string }{"a"}, 0)
+	cache.Set("geocode:a", &GeocodeResponse{Provider: "a"}, 0)
+	path = filepath.Join(dir, "cache.json")
+	if err := cache.SaveSnapshot(path); err != nil {
+		t.Fatalf("error saving snapshot: %v", err)
+	}
+	if loaded, err := newTestCache(t).LoadSnapshot(path); loaded != 1 || err != nil {
+		t.Errorf("loaded = %d, %v, want 1, nil", loaded, err)
+	}
+}
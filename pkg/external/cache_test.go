@@ -0,0 +1,119 @@
+package external
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newTestCache(t)
+	cache.SetLimits(3, 0)
+
+	cache.Set("a", "1", 0)
+	cache.Set("b", "2", 0)
+	cache.Set("c", "3", 0)
+	// Reading a makes b the least recently used item
+	cache.Get("a")
+	cache.Set("d", "4", 0)
+
+	if keys := cache.Keys("", 0); !equalStrings(keys, []string{"a", "c", "d"}) {
+		t.Errorf("keys = %q, want b evicted", keys)
+	}
+
+	// Replacing an item refreshes it without evicting anything
+	cache.Set("c", "3", 0)
+	cache.Set("e", "5", 0)
+	if keys := cache.Keys("", 0); !equalStrings(keys, []string{"c", "d", "e"}) {
+		t.Errorf("keys = %q, want a evicted", keys)
+	}
+
+	// Inspecting an item does not refresh it
+	cache.Inspect("d")
+	cache.Set("f", "6", 0)
+	if keys := cache.Keys("", 0); !equalStrings(keys, []string{"c", "e", "f"}) {
+		t.Errorf("keys = %q, want d evicted", keys)
+	}
+
+	stats := cache.Stats()
+	if stats.Entries != 3 || stats.MaxEntries != 3 || stats.Evictions != 3 {
+		t.Errorf("entries/max/evictions = %d/%d/%d, want 3/3/3", stats.Entries, stats.MaxEntries, stats.Evictions)
+	}
+	counters := cache.Counters()
+	want := CacheCounters{Entries: 3, Hits: 1, Evictions: 3, Bytes: stats.Bytes}
+	if counters != want {
+		t.Errorf("counters = %+v, want %+v", counters, want)
+	}
+}
+
+func TestCacheByteLimit(t *testing.T) {
+	value := strings.Repeat("x", 100)
+	size := estimateCacheValueSize("a", value)
+
+	cache := newTestCache(t)
+	cache.SetLimits(0, 2*size)
+
+	cache.Set("a", value, 0)
+	cache.Set("b", value, 0)
+	if stats := cache.Stats(); stats.Entries != 2 || stats.Bytes != 2*size || stats.Evictions != 0 {
+		t.Fatalf("entries/bytes/evictions = %d/%d/%d, want 2/%d/0", stats.Entries, stats.Bytes, stats.Evictions, 2*size)
+	}
+
+	cache.Set("c", value, 0)
+	if keys := cache.Keys("", 0); !equalStrings(keys, []string{"b", "c"}) {
+		t.Errorf("keys = %q, want a evicted", keys)
+	}
+	if stats := cache.Stats(); stats.Bytes != 2*size || stats.EvictedBytes != uint64(size) {
+		t.Errorf("bytes/evicted bytes = %d/%d, want %d/%d", stats.Bytes, stats.EvictedBytes, 2*size, size)
+	}
+
+	// An item larger than the whole budget does not survive its own insertion
+	cache.Set("huge", strings.Repeat("x", int(3*size)), 0)
+	if stats := cache.Stats(); stats.Entries != 0 || stats.Bytes != 0 {
+		t.Errorf("entries/bytes = %d/%d, want an empty cache", stats.Entries, stats.Bytes)
+	}
+
+	// Deleting an item releases its bytes
+	cache.Set("a", value, 0)
+	cache.Delete("a")
+	if stats := cache.Stats(); stats.Bytes != 0 {
+		t.Errorf("bytes = %d after delete, want 0", stats.Bytes)
+	}
+}
+
+func TestCacheSetLimitsTrims(t *testing.T) {
+	cache := newTestCache(t)
+	for _, key := range []string{"a", "b", "c", "d"} {
+		cache.Set(key, key, 0)
+	}
+
+	cache.SetLimits(2, 0)
+	if keys := cache.Keys("", 0); !equalStrings(keys, []string{"c", "d"}) {
+		t.Errorf("keys = %q, want the two most recent items", keys)
+	}
+
+	// Zero removes the limit again
+	cache.SetLimits(0, 0)
+	for _, key := range []string{"e", "f", "g"} {
+		cache.Set(key, key, 0)
+	}
+	if stats := cache.Stats(); stats.Entries != 5 || stats.Evictions != 2 {
+		t.Errorf("entries/evictions = %d/%d, want 5/2", stats.Entries, stats.Evictions)
+	}
+}
+
+func TestCacheKeysLimit(t *testing.T) {
+	cache := newTestCache(t)
+	for _, key := range []string{"whois:e", "whois:c", "geocode:a", "whois:a", "whois:d", "whois:b"} {
+		cache.Set(key, key, 0)
+	}
+
+	// The limit keeps the first keys in order, not whichever the map yields first
+	for i := 0; i < 10; i++ {
+		if keys := cache.Keys("whois:", 3); !equalStrings(keys, []string{"whois:a", "whois:b", "whois:c"}) {
+			t.Fatalf("keys = %q, want the first three whois keys", keys)
+		}
+	}
+	if keys := cache.Keys("whois:", 0); len(keys) != 5 {
+		t.Errorf("keys = %q, want every whois key", keys)
+	}
+}
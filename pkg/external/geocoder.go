@@ -0,0 +1,150 @@
+package external
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/igorsilvestre/simple-go-server/pkg/problem"
+)
+
+// ErrNoResults is returned by a Geocoder when the provider answered but found nothing
+var ErrNoResults = errors.New("no geocoding results found")
+
+// errUnknownProvider and errProviderDisabled are returned by lookupGeocoder
+var (
+	errUnknownProvider  = errors.New("unknown geocoding provider")
+	errProviderDisabled = errors.New("geocoding provider is not configured")
+)
+
+// Geocoder is implemented by every geocoding provider wired into the router
+type Geocoder interface {
+	// Name returns the provider name used in requests and responses
+	Name() string
+	// Geocode converts an address into normalized results
+	Geocode(ctx context.Context, address string) ([]GeocodeResult, error)
+}
+
+// ReverseGeocoder is implemented by providers that can convert coordinates into addresses
+type ReverseGeocoder interface {
+	Geocoder
+	// ReverseGeocode converts a coordinate into normalized results
+	ReverseGeocode(ctx context.Context, lat, lon float64) ([]GeocodeResult, error)
+}
+
+// GeocodeResult is the provider-independent representation of a geocoding result
+type GeocodeResult struct {
+	Lat              float64           `json:"lat"`
+	Lon              float64           `json:"lon"`
+	FormattedAddress string            `json:"formatted_address"`
+	Components       map[string]string `json:"components,omitempty"`
+	BoundingBox      *BoundingBox      `json:"bounding_box,omitempty"`
+	Confidence       float64           `json:"confidence"`
+	Provider         string            `json:"provider"`
+}
+
+// BoundingBox represents the area covered by a geocoding result
+type BoundingBox struct {
+	South float64 `json:"south"`
+	West  float64 `json:"west"`
+	North float64 `json:"north"`
+	East  float64 `json:"east"`
+}
+
+// GeocodeResponse is the response returned by the normalized geocoding endpoint
+type GeocodeResponse struct {
+	Provider string          `json:"provider"`
+	Results  []GeocodeResult `json:"results"`
+}
+
+// lookupGeocoder returns the provider registered under the given name
+func (s *Service) lookupGeocoder(name string) (Geocoder, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	geocoder, ok := s.geocoders[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errUnknownProvider, name)
+	}
+	if !s.config.Providers.Enabled(name) {
+		return nil, fmt.Errorf("%w: %s", errProviderDisabled, name)
+	}
+	return geocoder, nil
+}
+
+// providerProblem reports a provider selected by the request that cannot be used
+func providerProblem(name string, err error) *problem.Problem {
+	if errors.Is(err, errProviderDisabled) {
+		return problem.New(http.StatusBadRequest, problem.ProviderNotConfigured, err.Error()).WithProvider(name)
+	}
+	return problem.Invalid("provider", err.Error())
+}
+
+// cachedGeocode geocodes an address with the given provider, using GlobalCache when possible
+func (s *Service) cachedGeocode(ctx context.Context, geocoder Geocoder, address string) (*GeocodeResponse, error) {
+	// Create a cache key based on the provider and the normalized address
+	cacheKey := geocoder.Name() + ":" + normalizeAddressKey(address)
+
+	// Use the cached data or fetch it once for every concurrent request
+	return s.geocodeCache.Fetch(ctx, cacheKey, func(ctx context.Context) (*GeocodeResponse, error) {
+		results, err := geocoder.Geocode(ctx, address)
+		if err != nil {
+			return nil, err
+		}
+		return &GeocodeResponse{Provider: geocoder.Name(), Results: results}, nil
+	})
+}
+
+// normalizedGeocodingHandler handles geocoding requests that select a provider and
+// returns results in the provider-independent format
+func (s *Service) normalizedGeocodingHandler(w http.ResponseWriter, r *http.Request) {
+	address := r.URL.Query().Get("address")
+	if address == "" {
+		problem.Write(w, r, problem.Missing("address"))
+		return
+	}
+
+	geocoder, err := s.lookupGeocoder(r.URL.Query().Get("provider"))
+	if err != nil {
+		problem.Write(w, r, providerProblem(r.URL.Query().Get("provider"), err))
+		return
+	}
+
+	response, err := s.cachedGeocode(r.Context(), geocoder, address)
+	if err != nil {
+		problem.Write(w, r, upstreamProblem(geocoder.Name(), err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// clampConfidence keeps a provider score within the [0, 1] range
+func clampConfidence(value float64) float64 {
+	if value < 0 {
+		return 0
+	}
+	if value > 1 {
+		return 1
+	}
+	return value
+}
+
+// setComponent adds a non-empty address component to the map
+func setComponent(components map[string]string, key, value string) {
+	if value != "" {
+		components[key] = value
+	}
+}
+
+// firstNonEmpty returns the first value that is not empty
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,161 @@
+package external
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/igorsilvestre/simple-go-server/pkg/logging"
+	"github.com/igorsilvestre/simple-go-server/pkg/tracing"
+)
+
+// UpstreamCall describes a completed call to an upstream provider
+type UpstreamCall struct {
+	Provider string
+	Method   string
+	URL      string
+	// StatusCode is zero when the call failed before a response was received
+	StatusCode int
+	Err        error
+	Duration   time.Duration
+}
+
+// UpstreamObserver is notified of every upstream call made through an instrumented client,
+// with the context of the request that made it
+type UpstreamObserver func(ctx context.Context, call UpstreamCall)
+
+// providerContextKey carries the name of the provider a request is made for
+type providerContextKey struct{}
+
+// withProvider marks the upstream requests made with ctx as calls to provider
+func withProvider(ctx context.Context, provider string) context.Context {
+	return context.WithValue(ctx, providerContextKey{}, provider)
+}
+
+// providerFromContext returns the provider set by withProvider
+func providerFromContext(ctx context.Context) (string, bool) {
+	provider, ok := ctx.Value(providerContextKey{}).(string)
+	return provider, ok
+}
+
+// instrumentedTransport reports every round trip to its observers
+type instrumentedTransport struct {
+	base      http.RoundTripper
+	observers []UpstreamObserver
+}
+
+// RoundTrip executes the request with the base transport and reports it. Traced requests
+// get a client span, propagated to the provider with the traceparent header.
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Requests made without withProvider are reported by host
+	provider, ok := providerFromContext(req.Context())
+	if !ok {
+		provider = req.URL.Host
+	}
+
+	ctx, span := tracing.Start(req.Context(), "upstream "+provider, tracing.KindClient)
+	defer span.End()
+	if span != nil {
+		// A RoundTripper must not modify the request it was given
+		req = req.Clone(ctx)
+		tracing.Inject(ctx, req.Header)
+	}
+
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+
+	call := UpstreamCall{
+		Provider: provider,
+		Method:   req.Method,
+		URL:      redactURL(req.URL),
+		Err:      err,
+		Duration: time.Since(start),
+	}
+	if resp != nil {
+		call.StatusCode = resp.StatusCode
+	}
+
+	span.SetAttribute("provider", provider)
+	span.SetAttribute("http.request.method", call.Method)
+	span.SetAttribute("url.full", call.URL)
+	span.SetAttribute("server.address", req.URL.Host)
+	if err != nil {
+		span.RecordError(err)
+	} else {
+		span.SetAttribute("http.response.status_code", call.StatusCode)
+		if call.StatusCode >= http.StatusBadRequest {
+			span.Fail(http.StatusText(call.StatusCode))
+		}
+	}
+	for _, observe := range t.observers {
+		observe(req.Context(), call)
+	}
+
+	return resp, err
+}
+
+// NewHTTPClient returns the client shared by the upstream calls. Every call is bounded by
+// timeout and reported to the observers, after being logged, counted in the metrics and
+// tracked in the provider statuses.
+func NewHTTPClient(timeout time.Duration, observers ...UpstreamObserver) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &instrumentedTransport{
+			base:      http.DefaultTransport,
+			observers: append([]UpstreamObserver{logUpstreamCall, observeUpstreamCall, trackUpstreamStatus}, observers...),
+		},
+	}
+}
+
+// logUpstreamCall logs the outcome and the duration of an upstream call, and counts it in
+// the access line of the request that made it
+func logUpstreamCall(ctx context.Context, call UpstreamCall) {
+	logging.RecordUpstream(ctx, call.Provider)
+
+	attrs := []slog.Attr{
+		slog.String("provider", call.Provider),
+		slog.String("method", call.Method),
+		slog.String("url", call.URL),
+		slog.Float64("duration_ms", float64(call.Duration.Microseconds())/1000),
+	}
+	if call.Err != nil {
+		slog.LogAttrs(ctx, slog.LevelWarn, "Upstream call failed", append(attrs, slog.String("error", upstreamDetail(call.Err)))...)
+		return
+	}
+	slog.LogAttrs(ctx, slog.LevelInfo, "Upstream call", append(attrs, slog.Int("status", call.StatusCode))...)
+}
+
+// redactedParams are the query parameters that carry API keys
+var redactedParams = []string{"key", "apiKey"}
+
+// redactURL hides the API keys of a URL so it can be logged, as well as the looked up
+// addresses when the logs redact them
+func redactURL(u *url.URL) string {
+	query, redacted := logging.RedactAddresses(u.Query())
+	for _, name := range redactedParams {
+		if query.Has(name) {
+			query.Set(name, "REDACTED")
+			redacted = true
+		}
+	}
+
+	// MapTiler takes the address in the path, as /geocoding/{query}.json
+	path := u.Path
+	if logging.RedactingAddresses() {
+		if i := strings.Index(path, "/geocoding/"); i >= 0 {
+			path = path[:i] + "/geocoding/REDACTED"
+			redacted = true
+		}
+	}
+	if !redacted {
+		return u.String()
+	}
+
+	copied := *u
+	copied.Path, copied.RawPath = path, ""
+	copied.RawQuery = query.Encode()
+	return copied.String()
+}
@@ -0,0 +1,116 @@
+package external
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/igorsilvestre/simple-go-server/pkg/config"
+	"github.com/igorsilvestre/simple-go-server/pkg/tracing"
+)
+
+// recordingExporter keeps the exported spans in memory
+type recordingExporter struct {
+	mu    sync.Mutex
+	spans []tracing.SpanData
+}
+
+// Export records the spans
+func (e *recordingExporter) Export(ctx context.Context, spans []tracing.SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func TestUpstreamCallTracing(t *testing.T) {
+	exporter := &recordingExporter{}
+	tracer := tracing.NewWithExporter(config.TracingConfig{SampleRatio: 1, BatchTimeout: time.Hour}, exporter)
+
+	var traceparent string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer upstream.Close()
+
+	client := NewHTTPClient(time.Second)
+	handler := tracer.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, _ := http.NewRequestWithContext(withProvider(r.Context(), config.Google), http.MethodGet, upstream.URL+"?key=secret", nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Errorf("error calling upstream: %v", err)
+			return
+		}
+		resp.Body.Close()
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/external/geocode", nil))
+	tracer.Shutdown(context.Background())
+
+	if len(exporter.spans) != 2 {
+		t.Fatalf("exported %d spans, want 2", len(exporter.spans))
+	}
+	call, server := exporter.spans[0], exporter.spans[1]
+	if call.Name != "upstream google" || call.Kind != tracing.KindClient || call.Parent != server.Context.SpanID {
+		t.Errorf("upstream span = %+v, want a client span under the server span", call)
+	}
+	if !call.Failed || call.Attributes["http.response.status_code"] != http.StatusTooManyRequests {
+		t.Errorf("upstream span = %+v, want a failed call with status 429", call)
+	}
+	if call.Attributes["url.full"] != upstream.URL+"?key=REDACTED" {
+		t.Errorf("upstream span URL = %v, want the API key redacted", call.Attributes["url.full"])
+	}
+	if traceparent != call.Context.Traceparent() {
+		t.Errorf("traceparent = %q, want %q", traceparent, call.Context.Traceparent())
+	}
+}
+
+func TestUpstreamStatuses(t *testing.T) {
+	provider := "status-test"
+
+	// A rejected key is a failure, an answer to a bad request is not
+	trackUpstreamStatus(context.Background(), UpstreamCall{Provider: provider, StatusCode: http.StatusUnauthorized})
+	status := UpstreamStatuses()[provider]
+	if status.Healthy() || status.LastFailure == nil || status.LastError != "status 401" {
+		t.Errorf("status = %+v, want a failure", status)
+	}
+
+	time.Sleep(time.Millisecond)
+	trackUpstreamStatus(context.Background(), UpstreamCall{Provider: provider, StatusCode: http.StatusBadRequest})
+	if status := UpstreamStatuses()[provider]; !status.Healthy() || status.LastSuccess == nil {
+		t.Errorf("status = %+v, want a success after the failure", status)
+	}
+}
+
+func TestProbeProvidersRespectRateLimits(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	for _, provider := range config.AllProviders {
+		upstream.handle(provider, "", inline(http.StatusOK, ""))
+	}
+
+	// Nominatim allows a single call and the next one cannot wait for its turn
+	cfg := testConfig()
+	cfg.RateLimit.Providers = map[string]config.ProviderRateConfig{
+		config.Nominatim: {RateConfig: config.RateConfig{Rate: 0.01, Burst: 1}},
+	}
+	service := NewService(cfg, ServiceOptions{URLs: upstream.urls()})
+
+	if result := service.ProbeProviders(context.Background())[config.Nominatim]; !result.Reachable {
+		t.Fatalf("first probe = %+v, want nominatim reachable", result)
+	}
+	result := service.ProbeProviders(context.Background())[config.Nominatim]
+	if result.Reachable || !strings.Contains(result.Error, "rate limit") {
+		t.Errorf("second probe = %+v, want it held back by the rate limit", result)
+	}
+	if calls := upstream.callCount(config.Nominatim); calls != 1 {
+		t.Errorf("nominatim calls = %d, want 1", calls)
+	}
+	// Providers without a limit are probed every time
+	if calls := upstream.callCount(config.Google); calls != 2 {
+		t.Errorf("google calls = %d, want 2", calls)
+	}
+}
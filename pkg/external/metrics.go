@@ -0,0 +1,56 @@
+package external
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/igorsilvestre/simple-go-server/pkg/metrics"
+)
+
+var (
+	upstreamRequests = metrics.NewCounter("upstream_requests_total",
+		"Calls made to upstream providers, by provider and status code, or \"error\" when no response was received.", "provider", "status")
+	upstreamErrors = metrics.NewCounter("upstream_errors_total",
+		"Upstream calls that failed or returned a 4xx or 5xx status, by provider.", "provider")
+	upstreamDuration = metrics.NewHistogram("upstream_request_duration_seconds",
+		"Latency of upstream calls, by provider.", metrics.DefaultBuckets, "provider")
+
+	emailsSent   = metrics.NewCounter("emails_sent_total", "Emails accepted by MailerSend.")
+	emailsFailed = metrics.NewCounter("emails_failed_total", "Emails MailerSend failed to accept.")
+)
+
+func init() {
+	metrics.Default.RegisterCollector(collectCacheMetrics)
+}
+
+// observeUpstreamCall counts an upstream call and measures its latency
+func observeUpstreamCall(ctx context.Context, call UpstreamCall) {
+	status := "error"
+	if call.Err == nil {
+		status = strconv.Itoa(call.StatusCode)
+	}
+	upstreamRequests.Inc(call.Provider, status)
+	if call.Err != nil || call.StatusCode >= http.StatusBadRequest {
+		upstreamErrors.Inc(call.Provider)
+	}
+	upstreamDuration.Observe(call.Duration.Seconds(), call.Provider)
+}
+
+// collectCacheMetrics exposes the counters of GlobalCache, whichever backend it is. The
+// full statistics walk every entry, so they are left to the admin API.
+func collectCacheMetrics(w *metrics.Writer) {
+	counter, ok := GlobalCache.(CacheCounter)
+	if !ok {
+		return
+	}
+	counters := counter.Counters()
+
+	w.Counter("cache_hits_total", "Cache lookups that found a value.", float64(counters.Hits))
+	w.Counter("cache_misses_total", "Cache lookups that found nothing.", float64(counters.Misses))
+	w.Counter("cache_evictions_total", "Entries dropped to stay within the cache limits.", float64(counters.Evictions))
+	w.Gauge("cache_entries", "Entries in the cache.", float64(counters.Entries))
+	if counters.Bytes >= 0 {
+		w.Gauge("cache_bytes", "Approximate size of the cache entries in bytes.", float64(counters.Bytes))
+	}
+}
@@ -0,0 +1,60 @@
+package external
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/igorsilvestre/simple-go-server/pkg/problem"
+	"github.com/igorsilvestre/simple-go-server/pkg/ratelimit"
+)
+
+// upstreamProblem maps an error of a provider to the problem returned to the client:
+// 404 when the provider found nothing, 503 when our own limit for the provider held the
+// call back, 504 when it timed out and 502 for every other failure
+func upstreamProblem(provider string, err error) *problem.Problem {
+	var p *problem.Problem
+	var urlErr *url.Error
+	switch {
+	case errors.Is(err, ErrNoResults):
+		p = problem.New(http.StatusNotFound, problem.NoResults, err.Error())
+	case errors.Is(err, ratelimit.ErrLimited):
+		p = problem.New(http.StatusServiceUnavailable, problem.UpstreamUnavailable, upstreamDetail(err))
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &urlErr) && urlErr.Timeout():
+		p = problem.New(http.StatusGatewayTimeout, problem.UpstreamUnavailable, upstreamDetail(err))
+	default:
+		p = problem.New(http.StatusBadGateway, problem.UpstreamUnavailable, upstreamDetail(err))
+	}
+	return p.WithProvider(provider)
+}
+
+// noResultsProblem is the answer of every geocoding route when the providers found
+// nothing: 404 no_results naming the providers that were asked
+func noResultsProblem(providers ...string) *problem.Problem {
+	return upstreamProblem(strings.Join(providers, ","), ErrNoResults)
+}
+
+// upstreamDetail returns the message of an upstream error without the API keys and
+// addresses that the URL of a failed request carries
+func upstreamDetail(err error) string {
+	message := err.Error()
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		if parsed, parseErr := url.Parse(urlErr.URL); parseErr == nil {
+			message = strings.ReplaceAll(message, urlErr.URL, redactURL(parsed))
+		}
+	}
+	return message
+}
+
+// providerFailure describes the failure of one of the providers of a fallback or
+// consensus request, with the code the problem of that provider alone would have
+func providerFailure(name string, err error) ProviderFailure {
+	p := upstreamProblem(name, err)
+	if errors.Is(err, errUnknownProvider) || errors.Is(err, errProviderDisabled) {
+		p = providerProblem(name, err)
+	}
+	return ProviderFailure{Provider: name, Code: p.Code, Error: p.Detail}
+}
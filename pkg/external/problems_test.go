@@ -0,0 +1,68 @@
+package external
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/igorsilvestre/simple-go-server/pkg/problem"
+	"github.com/igorsilvestre/simple-go-server/pkg/ratelimit"
+)
+
+func TestUpstreamProblem(t *testing.T) {
+	requestURL := "https://maps.googleapis.com/maps/api/geocode/json?address=Avenida+Paulista&key=secret"
+
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantCode   string
+	}{
+		{
+			name:       "no results",
+			err:        fmt.Errorf("%w for address: Avenida Paulista", ErrNoResults),
+			wantStatus: http.StatusNotFound,
+			wantCode:   problem.NoResults,
+		},
+		{
+			name:       "held back by our own limit",
+			err:        &url.Error{Op: "Get", URL: requestURL, Err: fmt.Errorf("%w, next slot in 2s", ratelimit.ErrLimited)},
+			wantStatus: http.StatusServiceUnavailable,
+			wantCode:   problem.UpstreamUnavailable,
+		},
+		{
+			name:       "timeout",
+			err:        fmt.Errorf("error executing request: %w", &url.Error{Op: "Get", URL: requestURL, Err: context.DeadlineExceeded}),
+			wantStatus: http.StatusGatewayTimeout,
+			wantCode:   problem.UpstreamUnavailable,
+		},
+		{
+			name:       "connection refused",
+			err:        &url.Error{Op: "Get", URL: requestURL, Err: errors.New("connection refused")},
+			wantStatus: http.StatusBadGateway,
+			wantCode:   problem.UpstreamUnavailable,
+		},
+		{
+			name:       "error status",
+			err:        errors.New("Google API error: REQUEST_DENIED"),
+			wantStatus: http.StatusBadGateway,
+			wantCode:   problem.UpstreamUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := upstreamProblem("google", tt.err)
+			if p.Status != tt.wantStatus || p.Code != tt.wantCode || p.Provider != "google" {
+				t.Errorf("problem = %d %s %s, want %d %s google", p.Status, p.Code, p.Provider, tt.wantStatus, tt.wantCode)
+			}
+			if strings.Contains(p.Detail, "secret") {
+				t.Errorf("detail = %q, want the API key redacted", p.Detail)
+			}
+		})
+	}
+}
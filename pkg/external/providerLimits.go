@@ -0,0 +1,48 @@
+package external
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/igorsilvestre/simple-go-server/pkg/config"
+	"github.com/igorsilvestre/simple-go-server/pkg/ratelimit"
+)
+
+// providerLimit throttles the calls made to a provider
+type providerLimit struct {
+	limiter *ratelimit.Limiter
+	maxWait time.Duration
+}
+
+// newProviderLimits creates the limit of every provider with a configured rate
+func newProviderLimits(limits map[string]config.ProviderRateConfig) map[string]providerLimit {
+	providerLimits := make(map[string]providerLimit)
+	for name, limit := range limits {
+		if limiter := ratelimit.New(limit.Rate, limit.Burst); limiter != nil {
+			providerLimits[name] = providerLimit{limiter: limiter, maxWait: limit.MaxWait}
+		}
+	}
+	return providerLimits
+}
+
+// rateLimitedTransport queues the calls over the limit of their provider for up to the
+// maximum wait, and fails them past that without reaching the provider
+type rateLimitedTransport struct {
+	limits map[string]providerLimit
+	next   http.RoundTripper
+}
+
+// RoundTrip waits for the turn of the request before sending it
+func (t rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	provider, _ := providerFromContext(req.Context())
+	if limit, ok := t.limits[provider]; ok {
+		if err := limit.limiter.Wait(req.Context(), provider, limit.maxWait); err != nil {
+			slog.WarnContext(req.Context(), "Upstream call not sent",
+				"provider", provider, "method", req.Method, "url", redactURL(req.URL), "error", err.Error())
+			return nil, fmt.Errorf("%s: %w", provider, err)
+		}
+	}
+	return t.next.RoundTrip(req)
+}
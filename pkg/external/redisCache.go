@@ -0,0 +1,480 @@
+package external
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultRedisKeyPrefix namespaces the keys written by this server
+	defaultRedisKeyPrefix = "simple-go-server:"
+	// defaultRedisTimeout bounds every dial, read and write on a Redis connection
+	defaultRedisTimeout = 2 * time.Second
+	// defaultRedisPoolSize is the number of idle connections kept for reuse
+	defaultRedisPoolSize = 8
+)
+
+// RedisOptions configures a RedisCache
+type RedisOptions struct {
+	Addr     string
+	Password string
+	DB       int
+	Prefix   string
+	Timeout  time.Duration
+	PoolSize int
+}
+
+// ParseRedisURL builds RedisOptions from a redis://[:password@]host:port[/db] URL
+func ParseRedisURL(rawURL string) (RedisOptions, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return RedisOptions{}, fmt.Errorf("invalid Redis URL: %v", err)
+	}
+	if parsed.Scheme != "redis" {
+		return RedisOptions{}, fmt.Errorf("unsupported Redis URL scheme: %s", parsed.Scheme)
+	}
+
+	options := RedisOptions{Addr: parsed.Host}
+	if parsed.Port() == "" {
+		options.Addr = net.JoinHostPort(parsed.Hostname(), "6379")
+	}
+	if parsed.User != nil {
+		options.Password, _ = parsed.User.Password()
+	}
+	if db := strings.Trim(parsed.Path, "/"); db != "" {
+		if options.DB, err = strconv.Atoi(db); err != nil {
+			return RedisOptions{}, fmt.Errorf("invalid Redis database: %s", db)
+		}
+	}
+	return options, nil
+}
+
+// RedisCache is a CacheStore that speaks the RESP protocol to a Redis-compatible server.
+// Values are serialized with their registered type so handlers get back what they stored.
+type RedisCache struct {
+	options RedisOptions
+	pool    chan *redisConn
+
+	// hits and misses are counted by this replica only
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+// redisConn is a single connection to the server
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// redisError is an error reply sent by the server
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// NewRedisCache creates a RedisCache and checks that the server is reachable
+func NewRedisCache(options RedisOptions) (*RedisCache, error) {
+	if options.Addr == "" {
+		return nil, errors.New("Redis address is not set")
+	}
+	if options.Prefix == "" {
+		options.Prefix = defaultRedisKeyPrefix
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = defaultRedisTimeout
+	}
+	if options.PoolSize <= 0 {
+		options.PoolSize = defaultRedisPoolSize
+	}
+
+	cache := &RedisCache{
+		options: options,
+		pool:    make(chan *redisConn, options.PoolSize),
+	}
+
+	if _, err := cache.do("PING"); err != nil {
+		return nil, fmt.Errorf("error connecting to Redis at %s: %v", options.Addr, err)
+	}
+
+	return cache, nil
+}
+
+// dial opens a new connection, authenticating and selecting the database when configured
+func (c *RedisCache) dial() (*redisConn, error) {
+	conn, err := net.DialTimeout("tcp", c.options.Addr, c.options.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	rc := &redisConn{conn: conn, reader: bufio.NewReader(conn)}
+
+	if c.options.Password != "" {
+		if _, err := rc.do(c.options.Timeout, "AUTH", c.options.Password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if c.options.DB != 0 {
+		if _, err := rc.do(c.options.Timeout, "SELECT", strconv.Itoa(c.options.DB)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return rc, nil
+}
+
+// Ping checks that Redis answers
+func (c *RedisCache) Ping() error {
+	_, err := c.do("PING")
+	return err
+}
+
+// do runs a command on a pooled connection
+func (c *RedisCache) do(args ...string) (interface{}, error) {
+	var rc *redisConn
+	select {
+	case rc = <-c.pool:
+	default:
+		var err error
+		if rc, err = c.dial(); err != nil {
+			return nil, err
+		}
+	}
+
+	reply, err := rc.do(c.options.Timeout, args...)
+
+	// Error replies leave the connection usable, anything else may have left it mid-stream
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		rc.conn.Close()
+		return nil, err
+	}
+
+	select {
+	case c.pool <- rc:
+	default:
+		rc.conn.Close()
+	}
+	return reply, err
+}
+
+// do writes a command as a RESP array of bulk strings and reads the reply
+func (rc *redisConn) do(timeout time.Duration, args ...string) (interface{}, error) {
+	rc.conn.SetDeadline(time.Now().Add(timeout))
+
+	var command strings.Builder
+	fmt.Fprintf(&command, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&command, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(rc.conn, command.String()); err != nil {
+		return nil, err
+	}
+
+	return readRESP(rc.reader)
+}
+
+// readRESP reads a single RESP reply. Bulk strings are returned as []byte, a nil bulk
+// string or array as nil, integers as int64 and arrays as []interface{}.
+func readRESP(reader *bufio.Reader) (interface{}, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("malformed RESP line: %q", line)
+	}
+	kind, payload := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return payload, nil
+	case '-':
+		return nil, redisError(payload)
+	case ':':
+		return strconv.ParseInt(payload, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, fmt.Errorf("malformed RESP bulk length: %q", payload)
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		return data[:size], nil
+	case '*':
+		count, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, fmt.Errorf("malformed RESP array length: %q", payload)
+		}
+		if count < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, count)
+		for i := range items {
+			if items[i], err = readRESP(reader); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("unknown RESP reply type: %q", kind)
+	}
+}
+
+// Get retrieves an item from the cache. Connection and decoding errors are treated as misses.
+func (c *RedisCache) Get(key string) (interface{}, bool) {
+	value, found := c.get(key)
+	if found {
+		c.hits.Add(1)
+	} else {
+		c.misses.Add(1)
+	}
+	return value, found
+}
+
+// get reads and decodes an item without touching the hit counters
+func (c *RedisCache) get(key string) (interface{}, bool) {
+	reply, err := c.do("GET", c.options.Prefix+key)
+	if err != nil {
+		log.Printf("Redis GET %s failed: %v", key, err)
+		return nil, false
+	}
+
+	data, ok := reply.([]byte)
+	if !ok {
+		return nil, false
+	}
+
+	value, err := decodeCacheValue(data)
+	if err != nil {
+		log.Printf("Redis GET %s failed: %v", key, err)
+		return nil, false
+	}
+	return value, true
+}
+
+// Set adds an item to the cache with an optional expiration time
+func (c *RedisCache) Set(key string, value interface{}, duration time.Duration) {
+	data, err := encodeCacheValue(value)
+	if err != nil {
+		log.Printf("Redis SET %s failed: %v", key, err)
+		return
+	}
+
+	args := []string{"SET", c.options.Prefix + key, string(data)}
+	if duration > 0 {
+		args = append(args, "PX", strconv.FormatInt(max(duration.Milliseconds(), 1), 10))
+	}
+	if _, err := c.do(args...); err != nil {
+		log.Printf("Redis SET %s failed: %v", key, err)
+	}
+}
+
+// Delete removes an item from the cache
+func (c *RedisCache) Delete(key string) {
+	if _, err := c.do("DEL", c.options.Prefix+key); err != nil {
+		log.Printf("Redis DEL %s failed: %v", key, err)
+	}
+}
+
+// Clear removes every item written under the configured key prefix
+func (c *RedisCache) Clear() {
+	if _, err := c.deleteMatching(""); err != nil {
+		log.Printf("Redis clear failed: %v", err)
+	}
+}
+
+// scan calls visit with every batch of server-side keys starting with prefix, using SCAN
+// so the server is never blocked. Keys are passed with the configured prefix. Returning
+// false from visit stops the scan.
+func (c *RedisCache) scan(prefix string, visit func(keys []string) (bool, error)) error {
+	cursor := "0"
+	for {
+		reply, err := c.do("SCAN", cursor, "MATCH", escapeRedisPattern(c.options.Prefix+prefix)+"*", "COUNT", "100")
+		if err != nil {
+			return err
+		}
+
+		items, ok := reply.([]interface{})
+		if !ok || len(items) != 2 {
+			return fmt.Errorf("unexpected SCAN reply: %v", reply)
+		}
+		next, _ := items[0].([]byte)
+		names, _ := items[1].([]interface{})
+
+		keys := make([]string, 0, len(names))
+		for _, name := range names {
+			if key, ok := name.([]byte); ok {
+				keys = append(keys, string(key))
+			}
+		}
+		if len(keys) > 0 {
+			more, err := visit(keys)
+			if err != nil || !more {
+				return err
+			}
+		}
+
+		cursor = string(next)
+		if cursor == "0" || cursor == "" {
+			return nil
+		}
+	}
+}
+
+// escapeRedisPattern escapes the glob characters of a literal key prefix
+func escapeRedisPattern(value string) string {
+	var escaped strings.Builder
+	for _, r := range value {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			escaped.WriteByte('\\')
+		}
+		escaped.WriteRune(r)
+	}
+	return escaped.String()
+}
+
+// deleteMatching deletes every key starting with prefix and returns how many were removed
+func (c *RedisCache) deleteMatching(prefix string) (int, error) {
+	removed := 0
+	err := c.scan(prefix, func(keys []string) (bool, error) {
+		reply, err := c.do(append([]string{"DEL"}, keys...)...)
+		if err != nil {
+			return false, err
+		}
+		count, _ := reply.(int64)
+		removed += int(count)
+		return true, nil
+	})
+	return removed, err
+}
+
+// Stats returns the cache statistics. Entry counts come from a SCAN of the key prefix and
+// sizes from STRLEN; creation times are not tracked by Redis, so no oldest entry is reported.
+func (c *RedisCache) Stats() CacheStats {
+	hits, misses := c.hits.Load(), c.misses.Load()
+	stats := CacheStats{
+		Backend:  "redis",
+		Hits:     hits,
+		Misses:   misses,
+		HitRatio: hitRatio(hits, misses),
+		Prefixes: make(map[string]PrefixStats),
+	}
+
+	err := c.scan("", func(keys []string) (bool, error) {
+		for _, key := range keys {
+			reply, err := c.do("STRLEN", key)
+			if err != nil {
+				return false, err
+			}
+			size, _ := reply.(int64)
+
+			name := strings.TrimPrefix(key, c.options.Prefix)
+			prefix := stats.Prefixes[cacheKeyPrefix(name)]
+			prefix.Entries++
+			prefix.Bytes += size
+			stats.Prefixes[cacheKeyPrefix(name)] = prefix
+
+			stats.Entries++
+			stats.Bytes += size
+		}
+		return true, nil
+	})
+	if err != nil {
+		log.Printf("Redis stats failed: %v", err)
+	}
+
+	return stats
+}
+
+// Counters returns the hit and miss counters of this replica and the key count of the
+// database from DBSIZE, which includes keys outside of the prefix when the database is
+// shared. Sizes and evictions are left to Redis, so no byte count is reported.
+func (c *RedisCache) Counters() CacheCounters {
+	counters := CacheCounters{Hits: c.hits.Load(), Misses: c.misses.Load(), Bytes: -1}
+
+	reply, err := c.do("DBSIZE")
+	if err != nil {
+		log.Printf("Redis DBSIZE failed: %v", err)
+		return counters
+	}
+	entries, _ := reply.(int64)
+	counters.Entries = int(entries)
+	return counters
+}
+
+// Keys returns the first limit keys starting with prefix, in sorted order. A limit of zero
+// returns every key. SCAN returns keys in no particular order, so every matching key is
+// collected before the limit is applied.
+func (c *RedisCache) Keys(prefix string, limit int) []string {
+	keys := []string{}
+	err := c.scan(prefix, func(batch []string) (bool, error) {
+		for _, key := range batch {
+			keys = append(keys, strings.TrimPrefix(key, c.options.Prefix))
+		}
+		return true, nil
+	})
+	if err != nil {
+		log.Printf("Redis SCAN %s failed: %v", prefix, err)
+	}
+	return firstSortedKeys(keys, limit)
+}
+
+// Inspect returns an item with its remaining time to live
+func (c *RedisCache) Inspect(key string) (CacheEntry, bool) {
+	value, found := c.get(key)
+	if !found {
+		return CacheEntry{}, false
+	}
+	entry := CacheEntry{Key: key, Value: value}
+
+	if reply, err := c.do("STRLEN", c.options.Prefix+key); err == nil {
+		entry.Bytes, _ = reply.(int64)
+	}
+
+	// PTTL is -1 for keys without expiration and -2 for keys that vanished in the meantime
+	if reply, err := c.do("PTTL", c.options.Prefix+key); err == nil {
+		if ttl, _ := reply.(int64); ttl > 0 {
+			entry.TTL = time.Duration(ttl) * time.Millisecond
+			expiresAt := time.Now().Add(entry.TTL)
+			entry.ExpiresAt = &expiresAt
+		}
+	}
+	return entry, true
+}
+
+// DeletePrefix removes every item whose key starts with prefix
+func (c *RedisCache) DeletePrefix(prefix string) int {
+	removed, err := c.deleteMatching(prefix)
+	if err != nil {
+		log.Printf("Redis delete of prefix %s failed: %v", prefix, err)
+	}
+	return removed
+}
+
+// Close closes every idle connection
+func (c *RedisCache) Close() error {
+	for {
+		select {
+		case rc := <-c.pool:
+			rc.conn.Close()
+		default:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,395 @@
+package external
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is an in-process stand-in for a Redis server. It speaks RESP and implements
+// the handful of commands used by RedisCache.
+type fakeRedis struct {
+	t        *testing.T
+	listener net.Listener
+	password string
+
+	mu       sync.Mutex
+	items    map[string]fakeRedisItem
+	commands [][]string
+}
+
+// fakeRedisItem is a stored string with its optional expiration
+type fakeRedisItem struct {
+	value     string
+	expiresAt time.Time
+}
+
+// newFakeRedis starts a fake Redis server that is closed with the test
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error starting fake Redis: %v", err)
+	}
+	r := &fakeRedis{t: t, listener: listener, password: password, items: make(map[string]fakeRedisItem)}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go r.serve(conn)
+		}
+	}()
+	return r
+}
+
+// addr returns the address the fake server listens on
+func (r *fakeRedis) addr() string {
+	return r.listener.Addr().String()
+}
+
+// serve answers the commands sent on a connection until it is closed
+func (r *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	authenticated := r.password == ""
+	for {
+		request, err := readRESP(reader)
+		if err != nil {
+			return
+		}
+		items, _ := request.([]interface{})
+		args := make([]string, len(items))
+		for i, item := range items {
+			data, _ := item.([]byte)
+			args[i] = string(data)
+		}
+		if len(args) == 0 {
+			return
+		}
+
+		if strings.ToUpper(args[0]) == "AUTH" {
+			if len(args) == 2 && args[1] == r.password {
+				authenticated = true
+				conn.Write([]byte("+OK\r\n"))
+			} else {
+				conn.Write([]byte("-WRONGPASS invalid password\r\n"))
+			}
+			continue
+		}
+		if !authenticated {
+			conn.Write([]byte("-NOAUTH Authentication required.\r\n"))
+			continue
+		}
+		conn.Write([]byte(r.execute(args)))
+	}
+}
+
+// execute runs a command and returns its RESP reply
+func (r *fakeRedis) execute(args []string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.commands = append(r.commands, args)
+
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		return "+PONG\r\n"
+	case "SELECT":
+		return "+OK\r\n"
+	case "GET":
+		item, ok := r.lookup(args[1])
+		if !ok {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(item.value), item.value)
+	case "SET":
+		item := fakeRedisItem{value: args[2]}
+		if len(args) == 5 && strings.ToUpper(args[3]) == "PX" {
+			ms, err := strconv.ParseInt(args[4], 10, 64)
+			if err != nil || ms <= 0 {
+				return "-ERR invalid expire time\r\n"
+			}
+			item.expiresAt = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		}
+		r.items[args[1]] = item
+		return "+OK\r\n"
+	case "DEL":
+		removed := 0
+		for _, key := range args[1:] {
+			if _, ok := r.lookup(key); ok {
+				delete(r.items, key)
+				removed++
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", removed)
+	case "STRLEN":
+		item, _ := r.lookup(args[1])
+		return fmt.Sprintf(":%d\r\n", len(item.value))
+	case "PTTL":
+		item, ok := r.lookup(args[1])
+		switch {
+		case !ok:
+			return ":-2\r\n"
+		case item.expiresAt.IsZero():
+			return ":-1\r\n"
+		default:
+			return fmt.Sprintf(":%d\r\n", time.Until(item.expiresAt).Milliseconds())
+		}
+	case "DBSIZE":
+		return fmt.Sprintf(":%d\r\n", len(r.items))
+	case "SCAN":
+		// Every matching key is returned in a single batch
+		pattern := "*"
+		for i := 2; i+1 < len(args); i += 2 {
+			if strings.ToUpper(args[i]) == "MATCH" {
+				pattern = args[i+1]
+			}
+		}
+		var keys []string
+		for key := range r.items {
+			if matched, _ := path.Match(pattern, key); matched {
+				if _, ok := r.lookup(key); ok {
+					keys = append(keys, key)
+				}
+			}
+		}
+		var reply strings.Builder
+		fmt.Fprintf(&reply, "*2\r\n$1\r\n0\r\n*%d\r\n", len(keys))
+		for _, key := range keys {
+			fmt.Fprintf(&reply, "$%d\r\n%s\r\n", len(key), key)
+		}
+		return reply.String()
+	default:
+		return fmt.Sprintf("-ERR unknown command '%s'\r\n", args[0])
+	}
+}
+
+// lookup returns an item that has not expired, dropping it otherwise. r.mu must be held.
+func (r *fakeRedis) lookup(key string) (fakeRedisItem, bool) {
+	item, ok := r.items[key]
+	if ok && !item.expiresAt.IsZero() && time.Now().After(item.expiresAt) {
+		delete(r.items, key)
+		return fakeRedisItem{}, false
+	}
+	return item, ok
+}
+
+// keys returns the raw keys stored on the server
+func (r *fakeRedis) keys() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	keys := make([]string, 0, len(r.items))
+	for key := range r.items {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// lastCommand returns the last command received whose name is name
+func (r *fakeRedis) lastCommand(name string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := len(r.commands) - 1; i >= 0; i-- {
+		if strings.EqualFold(r.commands[i][0], name) {
+			return r.commands[i]
+		}
+	}
+	return nil
+}
+
+// newTestRedisCache connects a RedisCache to a fake server
+func newTestRedisCache(t *testing.T, server *fakeRedis, options RedisOptions) *RedisCache {
+	t.Helper()
+	options.Addr = server.addr()
+	cache, err := NewRedisCache(options)
+	if err != nil {
+		t.Fatalf("error connecting to fake Redis: %v", err)
+	}
+	t.Cleanup(func() { cache.Close() })
+	return cache
+}
+
+func TestRedisCacheGetSetDelete(t *testing.T) {
+	server := newFakeRedis(t, "")
+	cache := newTestRedisCache(t, server, RedisOptions{})
+
+	if _, found := cache.Get("geocode:missing"); found {
+		t.Fatal("missing key was found")
+	}
+
+	stored := &GeocodeResponse{Provider: "google", Results: []GeocodeResult{{Lat: -23.56, Lon: -46.65, Provider: "google"}}}
+	cache.Set("geocode:paulista", stored, 0)
+
+	value, found := cache.Get("geocode:paulista")
+	if !found {
+		t.Fatal("stored key was not found")
+	}
+	response, ok := value.(*GeocodeResponse)
+	if !ok {
+		t.Fatalf("value type = %T, want *GeocodeResponse", value)
+	}
+	if response.Provider != "google" || len(response.Results) != 1 || response.Results[0].Lat != -23.56 {
+		t.Errorf("value = %+v, want %+v", response, stored)
+	}
+
+	cache.Delete("geocode:paulista")
+	if _, found := cache.Get("geocode:paulista"); found {
+		t.Error("deleted key was found")
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 1 || stats.Misses != 2 {
+		t.Errorf("hits/misses = %d/%d, want 1/2", stats.Hits, stats.Misses)
+	}
+}
+
+func TestRedisCacheCounters(t *testing.T) {
+	server := newFakeRedis(t, "")
+	cache := newTestRedisCache(t, server, RedisOptions{Prefix: "geo:"})
+
+	cache.Set("geocode:paulista", &GeocodeResponse{Provider: "google"}, 0)
+	cache.Set("whois:example.com", map[string]interface{}{"domain": "example.com"}, 0)
+	cache.Get("geocode:paulista")
+	cache.Get("geocode:missing")
+
+	counters := cache.Counters()
+	want := CacheCounters{Entries: 2, Hits: 1, Misses: 1, Bytes: -1}
+	if counters != want {
+		t.Errorf("counters = %+v, want %+v", counters, want)
+	}
+
+	// The counters come from DBSIZE, without walking the keys
+	if server.lastCommand("DBSIZE") == nil {
+		t.Error("DBSIZE was not sent")
+	}
+	for _, name := range []string{"SCAN", "STRLEN"} {
+		if command := server.lastCommand(name); command != nil {
+			t.Errorf("counters sent %q", command)
+		}
+	}
+}
+
+func TestRedisCacheTTL(t *testing.T) {
+	server := newFakeRedis(t, "")
+	cache := newTestRedisCache(t, server, RedisOptions{})
+
+	cache.Set("geocode:long", &GeocodeResponse{Provider: "google"}, time.Minute)
+	if command := server.lastCommand("SET"); len(command) != 5 || command[3] != "PX" || command[4] != "60000" {
+		t.Errorf("SET command = %q, want an expiration of 60000ms", command)
+	}
+	entry, found := cache.Inspect("geocode:long")
+	if !found {
+		t.Fatal("stored key was not found")
+	}
+	if entry.TTL <= 0 || entry.TTL > time.Minute || entry.ExpiresAt == nil {
+		t.Errorf("TTL = %v, expires at %v, want up to a minute", entry.TTL, entry.ExpiresAt)
+	}
+
+	cache.Set("geocode:short", &GeocodeResponse{Provider: "google"}, 20*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	if _, found := cache.Get("geocode:short"); found {
+		t.Error("expired key was found")
+	}
+
+	cache.Set("geocode:forever", &GeocodeResponse{Provider: "google"}, 0)
+	if command := server.lastCommand("SET"); len(command) != 3 {
+		t.Errorf("SET command = %q, want no expiration", command)
+	}
+}
+
+func TestRedisCachePrefix(t *testing.T) {
+	server := newFakeRedis(t, "")
+	cache := newTestRedisCache(t, server, RedisOptions{Prefix: "test:"})
+
+	cache.Set("geocode:a", &GeocodeResponse{Provider: "google"}, 0)
+	cache.Set("geocode:b", &GeocodeResponse{Provider: "google"}, 0)
+	cache.Set("whois:example.com", map[string]interface{}{"domain": "example.com"}, 0)
+
+	raw := server.keys()
+	for _, key := range raw {
+		if !strings.HasPrefix(key, "test:") {
+			t.Errorf("server key %q is not prefixed", key)
+		}
+	}
+	if len(raw) != 3 {
+		t.Errorf("server keys = %q, want 3", raw)
+	}
+
+	if keys := cache.Keys("geocode:", 0); !equalStrings(keys, []string{"geocode:a", "geocode:b"}) {
+		t.Errorf("keys = %q, want the geocode keys without the server prefix", keys)
+	}
+	if keys := cache.Keys("geocode:", 1); !equalStrings(keys, []string{"geocode:a"}) {
+		t.Errorf("keys = %q, want the first geocode key", keys)
+	}
+	if removed := cache.DeletePrefix("geocode:"); removed != 2 {
+		t.Errorf("removed = %d, want 2", removed)
+	}
+	if keys := cache.Keys("", 0); !equalStrings(keys, []string{"whois:example.com"}) {
+		t.Errorf("keys = %q, want only the whois key", keys)
+	}
+
+	// Keys written by another application sharing the server are never touched
+	server.execute([]string{"SET", "other:key", "value"})
+	cache.Clear()
+	if keys := server.keys(); !equalStrings(keys, []string{"other:key"}) {
+		t.Errorf("server keys after clear = %q, want only the foreign key", keys)
+	}
+}
+
+func TestRedisCacheAuth(t *testing.T) {
+	server := newFakeRedis(t, "secret")
+
+	if _, err := NewRedisCache(RedisOptions{Addr: server.addr(), Password: "wrong"}); err == nil {
+		t.Error("connecting with a wrong password succeeded")
+	}
+
+	cache := newTestRedisCache(t, server, RedisOptions{Password: "secret", DB: 2})
+	cache.Set("geocode:a", &GeocodeResponse{Provider: "google"}, 0)
+	if _, found := cache.Get("geocode:a"); !found {
+		t.Error("stored key was not found")
+	}
+	if command := server.lastCommand("SELECT"); len(command) != 2 || command[1] != "2" {
+		t.Errorf("SELECT command = %q, want database 2", command)
+	}
+}
+
+func TestRedisCacheDown(t *testing.T) {
+	server := newFakeRedis(t, "")
+	addr := server.addr()
+	server.listener.Close()
+
+	if _, err := NewRedisCache(RedisOptions{Addr: addr, Timeout: 100 * time.Millisecond}); err == nil {
+		t.Fatal("connecting to a stopped server succeeded")
+	}
+
+	// A server going away after startup turns reads into misses and writes into no-ops
+	server = newFakeRedis(t, "")
+	cache := newTestRedisCache(t, server, RedisOptions{Timeout: 100 * time.Millisecond})
+	cache.Set("geocode:a", &GeocodeResponse{Provider: "google"}, 0)
+	server.listener.Close()
+	cache.Close()
+
+	if err := cache.Ping(); err == nil {
+		t.Error("ping of a stopped server succeeded")
+	}
+	if _, found := cache.Get("geocode:a"); found {
+		t.Error("key was found on a stopped server")
+	}
+	cache.Set("geocode:b", &GeocodeResponse{Provider: "google"}, time.Minute)
+	cache.Delete("geocode:a")
+	if keys := cache.Keys("", 0); len(keys) != 0 {
+		t.Errorf("keys = %q, want none", keys)
+	}
+	if stats := cache.Stats(); stats.Misses != 1 {
+		t.Errorf("misses = %d, want 1", stats.Misses)
+	}
+}
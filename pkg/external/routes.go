@@ -2,15 +2,41 @@ package external
 
 import (
 	"github.com/gorilla/mux"
+	"github.com/igorsilvestre/simple-go-server/pkg/config"
 )
 
-func RegisterExternalRoutes(r *mux.Router) {
+// RegisterExternalRoutes mounts the external API under /external. Routes backed by a single
+// provider are only mounted when that provider is enabled in the service configuration.
+// The middlewares, such as API key authentication, run on every external route.
+func RegisterExternalRoutes(r *mux.Router, s *Service, middlewares ...mux.MiddlewareFunc) {
+	cfg := s.config
 	subrouter := r.PathPrefix("/external").Subrouter()
-	subrouter.HandleFunc("/whois/{domain}", WhoisHandler).Methods("GET", "OPTIONS")
-	subrouter.HandleFunc("/autocomplete-address", AddressAutocompleteHandler).Methods("GET", "OPTIONS")
-	subrouter.HandleFunc("/geocode", googleGeocodingHandler).Methods("GET", "OPTIONS")
-	subrouter.HandleFunc("/geocode-geoapify", GeoapifyGeocodingHandler).Methods("GET", "OPTIONS")
-	subrouter.HandleFunc("/geocode-nominatim", NominatimGeocodingHandler).Methods("GET", "OPTIONS")
-	subrouter.HandleFunc("/geocode-maptiler", MapTilerGeocodingHandler).Methods("GET", "OPTIONS")
-	subrouter.HandleFunc("/send-email", SendEmailHandler).Methods("POST", "OPTIONS")
+	subrouter.Use(middlewares...)
+
+	// WHOIS falls back to a direct lookup when JSONWHOIS is not configured
+	subrouter.HandleFunc("/whois/{domain}", s.WhoisHandler).Methods("GET")
+
+	if cfg.Providers.Enabled(config.Google) {
+		subrouter.HandleFunc("/autocomplete-address", s.AddressAutocompleteHandler).Methods("GET")
+	}
+
+	// The multi-provider routes need at least one geocoding provider
+	if len(cfg.EnabledGeocoders(config.GeocodingProviders)) > 0 {
+		subrouter.HandleFunc("/geocode/batch", s.BatchGeocodingHandler).Methods("POST")
+		subrouter.HandleFunc("/geocode", s.googleGeocodingHandler).Methods("GET")
+		subrouter.HandleFunc("/reverse-geocode", s.ReverseGeocodingHandler).Methods("GET")
+	}
+
+	if cfg.Providers.Enabled(config.Geoapify) {
+		subrouter.HandleFunc("/geocode-geoapify", s.GeoapifyGeocodingHandler).Methods("GET")
+	}
+	if cfg.Providers.Enabled(config.Nominatim) {
+		subrouter.HandleFunc("/geocode-nominatim", s.NominatimGeocodingHandler).Methods("GET")
+	}
+	if cfg.Providers.Enabled(config.MapTiler) {
+		subrouter.HandleFunc("/geocode-maptiler", s.MapTilerGeocodingHandler).Methods("GET")
+	}
+	if cfg.Providers.Enabled(config.MailerSend) {
+		subrouter.HandleFunc("/send-email", s.SendEmailHandler).Methods("POST")
+	}
 }
@@ -0,0 +1,217 @@
+package external
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/igorsilvestre/simple-go-server/pkg/config"
+	"github.com/likexian/whois"
+	"github.com/mailersend/mailersend-go"
+)
+
+// ProviderURLs holds the base URL of every upstream API, so they can be pointed at a fake server
+type ProviderURLs struct {
+	// Google is the base of the Geocoding and Places APIs
+	Google     string
+	Geoapify   string
+	Nominatim  string
+	MapTiler   string
+	JSONWhois  string
+	MailerSend string
+	// WhoisServer is the "host:port" every WHOIS query is sent to. When empty, the server
+	// of each domain is found through IANA.
+	WhoisServer string
+}
+
+// DefaultProviderURLs returns the URLs of the production APIs
+func DefaultProviderURLs() ProviderURLs {
+	return ProviderURLs{
+		Google:     "https://maps.googleapis.com/maps/api",
+		Geoapify:   "https://api.geoapify.com/v1",
+		Nominatim:  "https://nominatim.openstreetmap.org",
+		MapTiler:   "https://api.maptiler.com",
+		JSONWhois:  "https://jsonwhois.com/api/v1",
+		MailerSend: mailersend.APIBase,
+	}
+}
+
+// ServiceOptions holds the dependencies of a Service. Zero values are replaced by the
+// production defaults.
+type ServiceOptions struct {
+	// Client makes every upstream HTTP call
+	Client *http.Client
+	// URLs overrides the base URL of some providers, the others keep their default
+	URLs ProviderURLs
+	// Now is the clock used by the cache policies
+	Now func() time.Time
+}
+
+// Service holds the configuration and the dependencies of the external handlers
+type Service struct {
+	config *config.Config
+	client *http.Client
+	urls   ProviderURLs
+	now    func() time.Time
+
+	// providerLimits throttles the calls, and the probes, of the providers with a rate limit
+	providerLimits map[string]providerLimit
+
+	// geocoders holds every geocoding provider indexed by name
+	geocoders     map[string]Geocoder
+	cachePolicies map[string]CachePolicy
+
+	googleGeocodingCache     *TypedCache[*GeocodingResponse]
+	geoapifyGeocodingCache   *TypedCache[*GeoapifyResponse]
+	nominatimGeocodingCache  *TypedCache[[]NominatimGeocodingResult]
+	mapTilerGeocodingCache   *TypedCache[*MapTilerResponse]
+	addressAutocompleteCache *TypedCache[*AutocompleteResponse]
+	whoisCache               *TypedCache[map[string]interface{}]
+	geocodeCache             *TypedCache[*GeocodeResponse]
+	reverseGeocodingCache    *TypedCache[*GeocodeResponse]
+}
+
+// NewService creates the service behind the external routes
+func NewService(cfg *config.Config, options ServiceOptions) *Service {
+	s := &Service{
+		config:        cfg,
+		client:        options.Client,
+		urls:          options.URLs,
+		now:           options.Now,
+		cachePolicies: resolveCachePolicies(cfg.Cache.Policies),
+	}
+	if s.client == nil {
+		s.client = NewHTTPClient(cfg.Server.UpstreamTimeout)
+	}
+
+	// Throttle the providers with a rate limit, such as Nominatim's 1 request per second
+	s.providerLimits = newProviderLimits(cfg.RateLimit.Providers)
+	if len(s.providerLimits) > 0 {
+		client := *s.client
+		next := client.Transport
+		if next == nil {
+			next = http.DefaultTransport
+		}
+		client.Transport = rateLimitedTransport{limits: s.providerLimits, next: next}
+		s.client = &client
+	}
+	if s.now == nil {
+		s.now = time.Now
+	}
+
+	// Keep the default URL of every provider that is not overridden
+	defaults := DefaultProviderURLs()
+	s.urls.Google = orDefault(s.urls.Google, defaults.Google)
+	s.urls.Geoapify = orDefault(s.urls.Geoapify, defaults.Geoapify)
+	s.urls.Nominatim = orDefault(s.urls.Nominatim, defaults.Nominatim)
+	s.urls.MapTiler = orDefault(s.urls.MapTiler, defaults.MapTiler)
+	s.urls.JSONWhois = orDefault(s.urls.JSONWhois, defaults.JSONWhois)
+	s.urls.MailerSend = orDefault(s.urls.MailerSend, defaults.MailerSend)
+
+	s.geocoders = map[string]Geocoder{
+		config.Google:    googleGeocoder{s},
+		config.Geoapify:  geoapifyGeocoder{s},
+		config.Nominatim: nominatimGeocoder{s},
+		config.MapTiler:  mapTilerGeocoder{s},
+	}
+
+	s.googleGeocodingCache = newServiceCache[*GeocodingResponse](s, "google_geocoding")
+	s.geoapifyGeocodingCache = newServiceCache[*GeoapifyResponse](s, "geoapify_geocoding")
+	s.nominatimGeocodingCache = newServiceCache[[]NominatimGeocodingResult](s, "nominatim_geocoding")
+	s.mapTilerGeocodingCache = newServiceCache[*MapTilerResponse](s, "maptiler_geocoding")
+	s.addressAutocompleteCache = newServiceCache[*AutocompleteResponse](s, "address_autocomplete")
+	s.whoisCache = newServiceCache[map[string]interface{}](s, "whois")
+	s.geocodeCache = newServiceCache[*GeocodeResponse](s, "geocode")
+	s.reverseGeocodingCache = newServiceCache[*GeocodeResponse](s, "reverse_geocoding")
+
+	return s
+}
+
+// Config returns the configuration the service runs with
+func (s *Service) Config() *config.Config {
+	return s.config
+}
+
+// newProviderRequest creates a request to an upstream provider, tagged with the provider
+// name for the instrumentation of the client
+func (s *Service) newProviderRequest(ctx context.Context, provider, method, requestURL string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(withProvider(ctx, provider), method, requestURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+	return req, nil
+}
+
+// whoisClient returns a WHOIS client bounded by the upstream timeout. When a WHOIS server
+// is configured every connection goes to it and referrals are not followed.
+func (s *Service) whoisClient() *whois.Client {
+	client := whois.NewClient().SetTimeout(s.config.Server.UpstreamTimeout)
+	if s.urls.WhoisServer != "" {
+		client.SetDialer(fixedDialer{address: s.urls.WhoisServer, timeout: s.config.Server.UpstreamTimeout})
+		client.SetDisableReferral(true)
+	}
+	return client
+}
+
+// fixedDialer connects to the same address whatever the requested one
+type fixedDialer struct {
+	address string
+	timeout time.Duration
+}
+
+// Dial connects to the fixed address
+func (d fixedDialer) Dial(network, _ string) (net.Conn, error) {
+	return net.DialTimeout(network, d.address, d.timeout)
+}
+
+// newMailerSend creates a MailerSend client that uses the shared HTTP client and the
+// configured base URL
+func (s *Service) newMailerSend(apiKey string) *mailersend.Mailersend {
+	ms := mailersend.NewMailersend(apiKey)
+	if s.urls.MailerSend == mailersend.APIBase {
+		ms.SetClient(s.client)
+		return ms
+	}
+
+	// The library has no base URL option, so its requests are rewritten on the way out
+	client := *s.client
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	client.Transport = rebaseTransport{from: mailersend.APIBase, to: s.urls.MailerSend, next: next}
+	ms.SetClient(&client)
+	return ms
+}
+
+// rebaseTransport sends the requests made for one base URL to another
+type rebaseTransport struct {
+	from, to string
+	next     http.RoundTripper
+}
+
+// RoundTrip rewrites the request URL when it starts with the original base URL
+func (t rebaseTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if rest, ok := strings.CutPrefix(req.URL.String(), t.from); ok {
+		target, err := url.Parse(t.to + rest)
+		if err != nil {
+			return nil, err
+		}
+		req = req.Clone(req.Context())
+		req.URL = target
+		req.Host = target.Host
+	}
+	return t.next.RoundTrip(req)
+}
+
+// orDefault returns value, or fallback when value is empty
+func orDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
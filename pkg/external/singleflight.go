@@ -0,0 +1,54 @@
+package external
+
+import (
+	"errors"
+	"sync"
+)
+
+// errFlightPanicked is returned to the callers waiting on a call whose function panicked
+var errFlightPanicked = errors.New("coalesced upstream call panicked")
+
+// flightGroup coalesces concurrent calls that share a key into a single execution
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+// flightCall is an in-flight or completed call
+type flightCall struct {
+	wg    sync.WaitGroup
+	value interface{}
+	err   error
+}
+
+// Do runs fn once for every caller asking for key while a call is in flight. Every caller
+// receives the same result; shared reports whether it came from another caller's execution.
+func (g *flightGroup) Do(key string, fn func() (interface{}, error)) (value interface{}, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	if call, found := g.calls[key]; found {
+		g.mu.Unlock()
+		call.wg.Wait()
+		return call.value, call.err, true
+	}
+	call := &flightCall{err: errFlightPanicked}
+	call.wg.Add(1)
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	// Release the waiters even if fn panics
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		call.wg.Done()
+	}()
+
+	call.value, call.err = fn()
+	return call.value, call.err, false
+}
+
+// upstreamFlights coalesces the upstream lookups of all handlers, keyed by cache key
+var upstreamFlights flightGroup
@@ -0,0 +1,148 @@
+package external
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// runConcurrently calls Do from n goroutines while the first call is held in flight, and
+// returns the results once every caller has joined and the call was released
+func runConcurrently(t *testing.T, g *flightGroup, n int, fn func() (interface{}, error)) (values []interface{}, errs []error, shared int) {
+	t.Helper()
+
+	started, release := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	held := func() (interface{}, error) {
+		once.Do(func() { close(started) })
+		<-release
+		return fn()
+	}
+
+	values, errs = make([]interface{}, n), make([]error, n)
+	var sharedCount atomic.Int32
+	var joined, done sync.WaitGroup
+	joined.Add(n)
+	done.Add(n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			defer done.Done()
+			if i > 0 {
+				// Wait for the first call to be in flight before joining it
+				<-started
+			}
+			joined.Done()
+			var isShared bool
+			values[i], errs[i], isShared = g.Do("key", held)
+			if isShared {
+				sharedCount.Add(1)
+			}
+		}(i)
+	}
+
+	joined.Wait()
+	// Give the callers that joined time to block on the call in flight
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	done.Wait()
+	return values, errs, int(sharedCount.Load())
+}
+
+func TestFlightGroupCoalesces(t *testing.T) {
+	var g flightGroup
+	var calls atomic.Int32
+	values, errs, shared := runConcurrently(t, &g, 10, func() (interface{}, error) {
+		calls.Add(1)
+		return "value", nil
+	})
+
+	if calls.Load() != 1 {
+		t.Errorf("calls = %d, want 1", calls.Load())
+	}
+	if shared != 9 {
+		t.Errorf("shared = %d, want 9", shared)
+	}
+	for i := range values {
+		if values[i] != "value" || errs[i] != nil {
+			t.Errorf("caller %d got %v, %v", i, values[i], errs[i])
+		}
+	}
+
+	// Completed calls are forgotten, so the next caller runs the function again
+	g.Do("key", func() (interface{}, error) {
+		calls.Add(1)
+		return nil, nil
+	})
+	if calls.Load() != 2 {
+		t.Errorf("calls = %d after the call completed, want 2", calls.Load())
+	}
+}
+
+func TestFlightGroupSharesErrors(t *testing.T) {
+	var g flightGroup
+	failure := errors.New("upstream unavailable")
+	_, errs, _ := runConcurrently(t, &g, 5, func() (interface{}, error) {
+		return nil, failure
+	})
+	for i, err := range errs {
+		if !errors.Is(err, failure) {
+			t.Errorf("caller %d error = %v, want %v", i, err, failure)
+		}
+	}
+}
+
+func TestFlightGroupKeysAreIndependent(t *testing.T) {
+	var g flightGroup
+	release := make(chan struct{})
+	go g.Do("slow", func() (interface{}, error) {
+		<-release
+		return nil, nil
+	})
+	defer close(release)
+
+	// A call for another key does not wait for the one in flight
+	done := make(chan struct{})
+	go func() {
+		g.Do("fast", func() (interface{}, error) { return nil, nil })
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("call for another key was blocked")
+	}
+}
+
+func TestFlightGroupPanic(t *testing.T) {
+	var g flightGroup
+	started, release := make(chan struct{}), make(chan struct{})
+
+	go func() {
+		defer func() { recover() }()
+		g.Do("key", func() (interface{}, error) {
+			close(started)
+			<-release
+			panic("boom")
+		})
+	}()
+
+	<-started
+	waiter := make(chan error)
+	go func() {
+		_, err, _ := g.Do("key", func() (interface{}, error) { return nil, nil })
+		waiter <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+
+	select {
+	case err := <-waiter:
+		if !errors.Is(err, errFlightPanicked) {
+			t.Errorf("waiter error = %v, want %v", err, errFlightPanicked)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiter was not released after the panic")
+	}
+}
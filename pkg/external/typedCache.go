@@ -0,0 +1,167 @@
+package external
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/igorsilvestre/simple-go-server/pkg/logging"
+	"github.com/igorsilvestre/simple-go-server/pkg/tracing"
+)
+
+// TypedCache is a view of GlobalCache restricted to one namespace and one value type.
+// Keys are prefixed with the namespace, and a cached value of another type (a key
+// collision or a value stored before a type change) is treated as a miss instead of
+// panicking the request.
+type TypedCache[T any] struct {
+	namespace string
+	policy    CachePolicy
+	now       func() time.Time
+}
+
+// NewTypedCache creates a typed cache for the given namespace with its default policy
+func NewTypedCache[T any](namespace string) *TypedCache[T] {
+	return &TypedCache[T]{namespace: namespace, policy: cachePolicyFor(namespace), now: time.Now}
+}
+
+// newServiceCache creates a typed cache with the policy and the clock of a service
+func newServiceCache[T any](s *Service, namespace string) *TypedCache[T] {
+	policy, ok := s.cachePolicies[namespace]
+	if !ok {
+		policy = cachePolicyFor(namespace)
+	}
+	return &TypedCache[T]{namespace: namespace, policy: policy, now: s.now}
+}
+
+// Namespace returns the namespace of the cache
+func (c *TypedCache[T]) Namespace() string {
+	return c.namespace
+}
+
+// Key returns the key under which an item is stored in GlobalCache
+func (c *TypedCache[T]) Key(key string) string {
+	return c.namespace + ":" + key
+}
+
+// Policy returns the cache policy of the namespace
+func (c *TypedCache[T]) Policy() CachePolicy {
+	return c.policy
+}
+
+// Get retrieves an item from the cache
+func (c *TypedCache[T]) Get(key string) (T, bool) {
+	var zero T
+
+	cachedData, found := GlobalCache.Get(c.Key(key))
+	if !found {
+		return zero, false
+	}
+
+	// Values stored through Fetch are wrapped with their policy metadata
+	if entry, ok := cachedData.(*cachePolicyEntry); ok {
+		if entry.Error != "" {
+			return zero, false
+		}
+		cachedData = entry.Value
+	}
+
+	value, ok := cachedData.(T)
+	if !ok {
+		c.logMismatch(key, cachedData)
+		return zero, false
+	}
+	return value, true
+}
+
+// Set adds an item to the cache with an optional expiration time
+func (c *TypedCache[T]) Set(key string, value T, duration time.Duration) {
+	GlobalCache.Set(c.Key(key), value, duration)
+}
+
+// Delete removes an item from the cache
+func (c *TypedCache[T]) Delete(key string) {
+	GlobalCache.Delete(c.Key(key))
+}
+
+// Fetch returns the value cached under key according to the namespace policy. On a miss,
+// fetch runs once for all concurrent callers asking for the same key. An expired value
+// still in its stale window is returned immediately while a background refresh replaces
+// it. fetch may return a partial value together with an error; both are shared and, for
+// "not found" outcomes, cached. The context passed to fetch keeps the values of ctx but
+// not its cancellation, since the result is shared with other callers and may outlive ctx.
+// A background refresh gets a detached context instead, with its own trace and no request
+// log, as it runs after the request that triggered it is over.
+func (c *TypedCache[T]) Fetch(ctx context.Context, key string, fetch func(ctx context.Context) (T, error)) (T, error) {
+	cacheKey := c.Key(key)
+
+	// The upstream calls of a miss are traced under the cache span
+	ctx, span := tracing.Start(ctx, "cache "+c.namespace, tracing.KindInternal)
+	defer span.End()
+	load := func(ctx context.Context) func() (interface{}, error) {
+		return func() (interface{}, error) {
+			value, err := fetch(ctx)
+			storeCachePolicyEntry(cacheKey, c.policy, c.now(), value, err)
+			return value, err
+		}
+	}
+
+	// Check if the data is in the cache
+	if cachedData, found := GlobalCache.Get(cacheKey); found {
+		if value, err, ok := c.fromEntry(key, cachedData); ok {
+			outcome := "hit"
+			if err != nil {
+				// A cached "not found" outcome
+				outcome = "negative_hit"
+			}
+			if entry := cachedData.(*cachePolicyEntry); c.now().After(entry.FreshUntil) {
+				// Serve the stale value and refresh it in the background, coalesced with
+				// any other refresh or miss of the same key
+				outcome = "stale"
+				refreshCtx, refreshSpan := tracing.StartDetached(ctx, "cache refresh "+c.namespace, tracing.KindInternal)
+				go func() {
+					defer refreshSpan.End()
+					upstreamFlights.Do(cacheKey, load(refreshCtx))
+				}()
+			}
+			logging.RecordCache(ctx, outcome)
+			span.SetAttribute("cache.outcome", outcome)
+			return value, err
+		}
+	}
+
+	logging.RecordCache(ctx, "miss")
+	span.SetAttribute("cache.outcome", "miss")
+	result, err, _ := upstreamFlights.Do(cacheKey, load(context.WithoutCancel(ctx)))
+	value, _ := result.(T)
+	return value, err
+}
+
+// fromEntry extracts the value and error of a cached policy entry. ok is false when the
+// cached data is not an entry holding a T.
+func (c *TypedCache[T]) fromEntry(key string, cachedData interface{}) (value T, err error, ok bool) {
+	entry, isEntry := cachedData.(*cachePolicyEntry)
+	if !isEntry {
+		c.logMismatch(key, cachedData)
+		return value, nil, false
+	}
+
+	result, err := entry.result()
+
+	// Cached "not found" errors may come without a value
+	if result == nil && err != nil {
+		return value, err, true
+	}
+
+	value, ok = result.(T)
+	if !ok {
+		c.logMismatch(key, result)
+		return value, nil, false
+	}
+	return value, err, true
+}
+
+// logMismatch reports a cached value of an unexpected type, which is then treated as a miss
+func (c *TypedCache[T]) logMismatch(key string, value interface{}) {
+	var expected T
+	log.Printf("Cache type mismatch for %s: expected %T, got %T; treating as a miss", c.Key(key), expected, value)
+}
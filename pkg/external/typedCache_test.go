@@ -0,0 +1,76 @@
+package external
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTypedCacheNamespaces(t *testing.T) {
+	cache := useTestGlobalCache(t)
+	geocode := NewTypedCache[*GeocodeResponse]("geocode")
+	whois := NewTypedCache[map[string]interface{}]("whois")
+
+	geocode.Set("example.com", &GeocodeResponse{Provider: "google"}, time.Hour)
+	whois.Set("example.com", map[string]interface{}{"domain": "example.com"}, time.Hour)
+
+	// The same key in two namespaces is two entries
+	if keys := cache.Keys("", 0); !equalStrings(keys, []string{"geocode:example.com", "whois:example.com"}) {
+		t.Errorf("keys = %q, want one entry per namespace", keys)
+	}
+	if value, found := geocode.Get("example.com"); !found || value.Provider != "google" {
+		t.Errorf("geocode value = %v, %t, want the stored response", value, found)
+	}
+	if value, found := whois.Get("example.com"); !found || value["domain"] != "example.com" {
+		t.Errorf("whois value = %v, %t, want the stored map", value, found)
+	}
+
+	whois.Delete("example.com")
+	if _, found := whois.Get("example.com"); found {
+		t.Error("deleted whois entry was found")
+	}
+	if _, found := geocode.Get("example.com"); !found {
+		t.Error("deleting a whois entry removed the geocode entry")
+	}
+}
+
+func TestTypedCacheTypeMismatchIsAMiss(t *testing.T) {
+	useTestGlobalCache(t)
+	typed := NewTypedCache[*GeocodeResponse]("geocode")
+
+	// A value of another type under the same key, e.g. stored before a type change
+	GlobalCache.Set(typed.Key("paulista"), "not a response", time.Hour)
+	if value, found := typed.Get("paulista"); found || value != nil {
+		t.Errorf("Get = %v, %t, want a miss", value, found)
+	}
+
+	calls := 0
+	value, err := typed.Fetch(context.Background(), "paulista", func(ctx context.Context) (*GeocodeResponse, error) {
+		calls++
+		return &GeocodeResponse{Provider: "google"}, nil
+	})
+	if err != nil || value.Provider != "google" || calls != 1 {
+		t.Fatalf("Fetch = %v, %v after %d calls, want a fresh upstream result", value, err, calls)
+	}
+
+	// The mismatched value was replaced, so the next lookup is a hit
+	if value, found := typed.Get("paulista"); !found || value.Provider != "google" {
+		t.Errorf("Get = %v, %t, want the fetched response", value, found)
+	}
+
+	// A policy entry holding another type is a miss as well
+	GlobalCache.Set(typed.Key("augusta"), &cachePolicyEntry{Value: map[string]interface{}{}, FreshUntil: time.Now().Add(time.Hour)}, time.Hour)
+	if _, found := typed.Get("augusta"); found {
+		t.Error("policy entry of another type was a hit")
+	}
+}
+
+func TestTypedCachePolicy(t *testing.T) {
+	if policy := NewTypedCache[*GeocodeResponse]("whois").Policy(); policy != defaultCachePolicies["whois"] {
+		t.Errorf("whois policy = %+v, want the default", policy)
+	}
+	// Namespaces without a policy keep their values for a day without stale or negative caching
+	if policy := NewTypedCache[string]("custom").Policy(); policy != (CachePolicy{TTL: 24 * time.Hour}) {
+		t.Errorf("custom policy = %+v, want a one day TTL", policy)
+	}
+}
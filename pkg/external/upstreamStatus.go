@@ -0,0 +1,163 @@
+package external
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/igorsilvestre/simple-go-server/pkg/config"
+)
+
+// probeTimeout bounds a provider probe
+const probeTimeout = 5 * time.Second
+
+// UpstreamStatus describes the latest successful and failed calls to a provider
+type UpstreamStatus struct {
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastFailure *time.Time `json:"last_failure,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+}
+
+// Healthy reports whether the latest call to the provider succeeded, or none was made
+func (s UpstreamStatus) Healthy() bool {
+	return s.LastFailure == nil || (s.LastSuccess != nil && s.LastSuccess.After(*s.LastFailure))
+}
+
+// upstreamStatuses holds the status of every provider called since the server started
+var upstreamStatuses = struct {
+	sync.Mutex
+	providers map[string]*UpstreamStatus
+}{providers: make(map[string]*UpstreamStatus)}
+
+// upstreamFailed reports whether a call shows that the provider is not working for us:
+// it could not be reached, failed, rejected our key or throttled us. Other 4xx statuses
+// are answers to the request, such as an invalid address.
+func upstreamFailed(call UpstreamCall) (bool, string) {
+	switch {
+	case call.Err != nil:
+		return true, call.Err.Error()
+	case call.StatusCode >= http.StatusInternalServerError,
+		call.StatusCode == http.StatusUnauthorized,
+		call.StatusCode == http.StatusForbidden,
+		call.StatusCode == http.StatusTooManyRequests:
+		return true, fmt.Sprintf("status %d", call.StatusCode)
+	}
+	return false, ""
+}
+
+// trackUpstreamStatus records the time of the latest successful or failed call to a provider
+func trackUpstreamStatus(ctx context.Context, call UpstreamCall) {
+	now := time.Now()
+	failed, reason := upstreamFailed(call)
+
+	upstreamStatuses.Lock()
+	defer upstreamStatuses.Unlock()
+	status, ok := upstreamStatuses.providers[call.Provider]
+	if !ok {
+		status = &UpstreamStatus{}
+		upstreamStatuses.providers[call.Provider] = status
+	}
+	if failed {
+		status.LastFailure = &now
+		status.LastError = reason
+	} else {
+		status.LastSuccess = &now
+	}
+}
+
+// UpstreamStatuses returns the status of every provider called since the server started
+func UpstreamStatuses() map[string]UpstreamStatus {
+	upstreamStatuses.Lock()
+	defer upstreamStatuses.Unlock()
+
+	statuses := make(map[string]UpstreamStatus, len(upstreamStatuses.providers))
+	for provider, status := range upstreamStatuses.providers {
+		statuses[provider] = *status
+	}
+	return statuses
+}
+
+// ProbeResult is the outcome of a provider probe
+type ProbeResult struct {
+	Reachable bool    `json:"reachable"`
+	Status    int     `json:"status,omitempty"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// baseURL returns the base URL of an HTTP provider
+func (s *Service) baseURL(provider string) string {
+	switch provider {
+	case config.Google:
+		return s.urls.Google
+	case config.Geoapify:
+		return s.urls.Geoapify
+	case config.Nominatim:
+		return s.urls.Nominatim
+	case config.MapTiler:
+		return s.urls.MapTiler
+	case config.JSONWhois:
+		return s.urls.JSONWhois
+	case config.MailerSend:
+		return s.urls.MailerSend
+	}
+	return ""
+}
+
+// ProbeProviders checks that every enabled provider can be reached. A probe is a HEAD
+// request to the base URL of the provider without its API key, so it is never billed;
+// any HTTP answer means the provider is reachable. Probes bypass the instrumented client
+// and do not count as calls to the provider, but they wait for their turn under the rate
+// limit of the provider like any other call.
+func (s *Service) ProbeProviders(ctx context.Context) map[string]ProbeResult {
+	client := &http.Client{
+		Timeout:   probeTimeout,
+		Transport: rateLimitedTransport{limits: s.providerLimits, next: http.DefaultTransport},
+	}
+
+	var providers []string
+	for _, name := range config.AllProviders {
+		if s.config.Providers.Enabled(name) {
+			providers = append(providers, name)
+		}
+	}
+
+	results := make(map[string]ProbeResult, len(providers))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, name := range providers {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			result := probe(withProvider(ctx, name), client, s.baseURL(name))
+			mu.Lock()
+			results[name] = result
+			mu.Unlock()
+		}(name)
+	}
+	wg.Wait()
+	return results
+}
+
+// probe sends a HEAD request to url
+func probe(ctx context.Context, client *http.Client, url string) ProbeResult {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return ProbeResult{Error: err.Error()}
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	result := ProbeResult{LatencyMS: float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	resp.Body.Close()
+
+	result.Reachable = true
+	result.Status = resp.StatusCode
+	return result
+}
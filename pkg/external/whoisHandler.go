@@ -1,28 +1,32 @@
 package external
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/http"
-	"os"
+	"net/url"
 	"regexp"
 	"strings"
-	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/likexian/whois"
+	"github.com/igorsilvestre/simple-go-server/pkg/config"
+	"github.com/igorsilvestre/simple-go-server/pkg/logging"
+	"github.com/igorsilvestre/simple-go-server/pkg/problem"
+	"github.com/igorsilvestre/simple-go-server/pkg/tracing"
 )
 
 // Function to fetch WHOIS data using the JSONWHOIS API
-func fetchWhoisData(domain string) (map[string]interface{}, error) {
-	req, err := http.NewRequest("GET", "https://jsonwhois.com/api/v1/whois?domain="+domain, nil)
+func (s *Service) fetchWhoisData(ctx context.Context, domain string) (map[string]interface{}, error) {
+	req, err := s.newProviderRequest(ctx, config.JSONWhois, "GET", s.urls.JSONWhois+"/whois?domain="+url.QueryEscape(domain))
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Add("Accept", "application/json")
-	req.Header.Add("Authorization", "Token token="+os.Getenv("JSONWHOIS_APIKEY"))
+	req.Header.Add("Authorization", "Token token="+s.config.Providers.APIKey(config.JSONWhois))
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := s.client.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -57,9 +61,13 @@ func fetchWhoisData(domain string) (map[string]interface{}, error) {
 }
 
 // Function to fetch WHOIS data using the alternative method
-func fetchWhoisDataAlternative(domain string) (map[string]interface{}, error) {
-	// Perform the WHOIS lookup
-	result, err := whois.Whois(domain)
+func (s *Service) fetchWhoisDataAlternative(domain string) (map[string]interface{}, error) {
+	// Perform the WHOIS lookup, on the configured server when there is one
+	var servers []string
+	if s.urls.WhoisServer != "" {
+		servers = append(servers, s.urls.WhoisServer)
+	}
+	result, err := s.whoisClient().Whois(domain, servers...)
 	if err != nil {
 		return nil, fmt.Errorf("Error fetching WHOIS data: %v", err)
 	}
@@ -73,6 +81,11 @@ func fetchWhoisDataAlternative(domain string) (map[string]interface{}, error) {
 		return data, fmt.Errorf("Permission denied by the WHOIS server for domain: %s", domain)
 	}
 
+	// An unregistered domain is a "not found" answer, cached like the empty geocoding ones
+	if isNotRegistered(result) {
+		return data, fmt.Errorf("%w for domain: %s", ErrNoResults, domain)
+	}
+
 	// Parse data["raw"] using parseRawData
 	parsedData := parseRawData(result)
 
@@ -119,54 +132,63 @@ func isPermissionDenied(raw string) bool {
 		strings.Contains(lowerRaw, "refused")
 }
 
-func WhoisHandler(w http.ResponseWriter, r *http.Request) {
+// Helper function to check if the WHOIS server has no record of the domain, in the
+// wording of the common registries
+func isNotRegistered(raw string) bool {
+	lowerRaw := strings.ToLower(raw)
+	return strings.Contains(lowerRaw, "no match for") ||
+		strings.Contains(lowerRaw, "not found") ||
+		strings.Contains(lowerRaw, "no entries found") ||
+		strings.Contains(lowerRaw, "no data found") ||
+		strings.Contains(lowerRaw, "status: free")
+}
+
+func (s *Service) WhoisHandler(w http.ResponseWriter, r *http.Request) {
 	// Use mux.Vars to extract the domain parameter
 	vars := mux.Vars(r)
 	domain := vars["domain"]
 	if domain == "" {
-		http.Error(w, "Domain parameter is required", http.StatusBadRequest)
-		return
-	}
-
-	// Create a cache key based on the domain
-	cacheKey := "whois:" + domain
-
-	// Check if the data is in the cache
-	if cachedData, found := GlobalCache.Get(cacheKey); found {
-		// Use the cached data
-		data := cachedData.(map[string]interface{})
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(data)
+		problem.Write(w, r, problem.Missing("domain"))
 		return
 	}
 
-	var data map[string]interface{}
-	var err error
-
-	// Try the first method
-	data, err = fetchWhoisData(domain)
-	if err != nil {
-		// Log the error (optional)
-		fmt.Printf("Error with JSONWHOIS API: %v\n", err)
+	// Use the cached data or look it up once for every concurrent request
+	data, err := s.whoisCache.Fetch(r.Context(), normalizeDomainKey(domain), func(ctx context.Context) (map[string]interface{}, error) {
+		// Try the first method when JSONWHOIS is configured
+		if s.config.Providers.Enabled(config.JSONWhois) {
+			data, err := s.fetchWhoisData(ctx, domain)
+			if err == nil {
+				return data, nil
+			}
+			// Log the error (optional)
+			slog.WarnContext(ctx, "Error with JSONWHOIS API", "domain", domain, "error", upstreamDetail(err))
+		}
 
-		// First method failed, try the alternative method
-		data, err = fetchWhoisDataAlternative(domain)
+		// First method failed or is disabled, try the alternative method
+		// The direct lookup speaks the WHOIS protocol, outside of the instrumented client
+		logging.RecordUpstream(ctx, "whois")
+		_, span := tracing.Start(ctx, "upstream whois", tracing.KindClient)
+		span.SetAttribute("provider", "whois")
+		data, err := s.fetchWhoisDataAlternative(domain)
+		span.RecordError(err)
+		span.End()
 		if err != nil {
 			// Log the error (optional)
-			fmt.Printf("Error with alternative WHOIS method: %v\n", err)
-			// Return the data with the error message
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(map[string]interface{}{
-				"error": err.Error(),
-				"data":  data,
-			})
-			return
+			slog.WarnContext(ctx, "Error with alternative WHOIS method", "domain", domain, "error", err.Error())
 		}
+		return data, err
+	})
+	if err != nil {
+		// The WHOIS server is the last method tried; keep what it answered, such as the
+		// raw text of a refusal
+		p := upstreamProblem("whois", err)
+		if data != nil {
+			p.With("data", data)
+		}
+		problem.Write(w, r, p)
+		return
 	}
 
-	// Store the data in the cache (24 hours expiration)
-	GlobalCache.Set(cacheKey, data, 24*time.Hour)
-
 	// Write the response in JSON format
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(data)
@@ -0,0 +1,135 @@
+package external
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/igorsilvestre/simple-go-server/pkg/config"
+)
+
+func TestWhoisHandler(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture fixture
+		// disableJSONWhois removes the JSONWHOIS key so only the WHOIS server is queried
+		disableJSONWhois bool
+		domain           string
+		requests         int
+		wantStatus       int
+		wantBody         string
+		wantCalls        int
+	}{
+		{
+			name:       "JSONWHOIS answers and is cached",
+			fixture:    recorded("jsonwhois/whois_ok.json"),
+			domain:     "example.com.br",
+			requests:   2,
+			wantStatus: http.StatusOK,
+			wantBody:   `"owner":"Example Comercio Ltda"`,
+			wantCalls:  1,
+		},
+		{
+			name:       "incomplete answer falls back to the WHOIS server",
+			fixture:    fromFile(http.StatusOK, "jsonwhois/whois_incomplete.json"),
+			domain:     "example.com.br",
+			requests:   1,
+			wantStatus: http.StatusOK,
+			wantBody:   "% Copyright (c) Nic.br",
+			wantCalls:  1,
+		},
+		{
+			name:       "non-200 falls back to the WHOIS server",
+			fixture:    inline(http.StatusUnauthorized, `{"error": "unauthorized"}`),
+			domain:     "EXAMPLE.com.br",
+			requests:   1,
+			wantStatus: http.StatusOK,
+			wantBody:   `"ownerid":"12.345.678/0001-90"`,
+			wantCalls:  1,
+		},
+		{
+			name:       "malformed JSON falls back to the WHOIS server",
+			fixture:    inline(http.StatusOK, `{"raw": `),
+			domain:     "example.com.br",
+			requests:   1,
+			wantStatus: http.StatusOK,
+			wantBody:   `"nserver":"a.sec.dns.br, b.sec.dns.br"`,
+			wantCalls:  1,
+		},
+		{
+			name:             "disabled JSONWHOIS uses the WHOIS server",
+			fixture:          recorded("jsonwhois/whois_ok.json"),
+			disableJSONWhois: true,
+			domain:           "example.com.br",
+			requests:         2,
+			wantStatus:       http.StatusOK,
+			wantBody:         `"owner":"Example Comercio Ltda"`,
+			wantCalls:        0,
+		},
+		{
+			name:       "unregistered domain is a cached no_results problem",
+			fixture:    inline(http.StatusNotFound, `{"error": "not found"}`),
+			domain:     "nowhere.com.br",
+			requests:   2,
+			wantStatus: http.StatusNotFound,
+			wantBody:   `"code":"no_results"`,
+			wantCalls:  1,
+		},
+		{
+			name:       "both methods fail",
+			fixture:    inline(http.StatusInternalServerError, "internal error"),
+			domain:     "denied.com.br",
+			requests:   2,
+			wantStatus: http.StatusBadGateway,
+			wantBody:   `"detail":"Permission denied by the WHOIS server for domain: denied.com.br","instance":"/external/whois/denied.com.br","provider":"whois"`,
+			wantCalls:  2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			upstream.handle(config.JSONWhois, "/whois", tt.fixture)
+
+			urls := upstream.urls()
+			urls.WhoisServer = newFakeWhois(t, map[string]fixture{
+				"example.com.br": recorded("whois/example.com.br.txt"),
+				"denied.com.br":  fromFile(0, "whois/denied.txt"),
+				"nowhere.com.br": fromFile(0, "whois/nowhere.com.br.txt"),
+			})
+
+			cfg := testConfig()
+			if tt.disableJSONWhois {
+				cfg.Providers.JSONWhois.APIKey = ""
+			}
+			router := newTestRouter(t, cfg, urls)
+
+			for i := 0; i < tt.requests; i++ {
+				w := serve(router, http.MethodGet, "/external/whois/"+tt.domain, "")
+				if w.Code != tt.wantStatus {
+					t.Fatalf("request %d: status = %d, want %d: %s", i, w.Code, tt.wantStatus, w.Body)
+				}
+				if !strings.Contains(w.Body.String(), tt.wantBody) {
+					t.Errorf("request %d: body = %s, want it to contain %s", i, w.Body, tt.wantBody)
+				}
+			}
+			if calls := upstream.callCount(config.JSONWhois); calls != tt.wantCalls {
+				t.Errorf("upstream calls = %d, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestParseRawData(t *testing.T) {
+	data := parseRawData("domain:  example.com.br\nnserver: a.sec.dns.br\nnserver: b.sec.dns.br\n% comment\n")
+
+	if data["domain"] != "example.com.br" {
+		t.Errorf("domain = %q, want example.com.br", data["domain"])
+	}
+	if data["nserver"] != "a.sec.dns.br, b.sec.dns.br" {
+		t.Errorf("nserver = %q, want both servers", data["nserver"])
+	}
+	if len(data) != 2 {
+		t.Errorf("parsed %d keys, want 2: %v", len(data), data)
+	}
+}
@@ -0,0 +1,235 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/igorsilvestre/simple-go-server/pkg/config"
+	"github.com/igorsilvestre/simple-go-server/pkg/external"
+)
+
+// Version is the version of the build, set with
+// -ldflags "-X github.com/igorsilvestre/simple-go-server/pkg/health.Version=v1.2.3"
+var Version = "dev"
+
+// deepCheckInterval is how long the result of the deep checks is reused, so that frequent
+// readiness checks do not turn into traffic to the providers
+const deepCheckInterval = 30 * time.Second
+
+// BuildInfo describes the running binary
+type BuildInfo struct {
+	Version   string    `json:"version"`
+	Commit    string    `json:"commit,omitempty"`
+	Modified  bool      `json:"modified,omitempty"`
+	GoVersion string    `json:"go_version"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// CacheReport describes the cache backend
+type CacheReport struct {
+	Backend string `json:"backend"`
+	OK      bool   `json:"ok"`
+	Error   string `json:"error,omitempty"`
+}
+
+// ProviderReport describes the configuration of a provider and the outcome of its latest calls
+type ProviderReport struct {
+	// Configured is true when the provider has its API key, or needs none
+	Configured bool `json:"configured"`
+	Enabled    bool `json:"enabled"`
+	external.UpstreamStatus
+	Probe *external.ProbeResult `json:"probe,omitempty"`
+}
+
+// Readiness is the body of /readyz
+type Readiness struct {
+	// Status is "ready", "degraded" when a provider is failing, or "unavailable"
+	Status    string                    `json:"status"`
+	Build     BuildInfo                 `json:"build"`
+	Cache     CacheReport               `json:"cache"`
+	Providers map[string]ProviderReport `json:"providers"`
+}
+
+// Checker answers the liveness and readiness checks of the server
+type Checker struct {
+	cfg     *config.Config
+	service *external.Service
+	build   BuildInfo
+
+	mu         sync.Mutex
+	deep       map[string]external.ProbeResult
+	deepAt     time.Time
+	deepFlight chan struct{}
+}
+
+// New creates the checker of a server started now
+func New(cfg *config.Config, service *external.Service) *Checker {
+	return &Checker{cfg: cfg, service: service, build: buildInfo(time.Now())}
+}
+
+// buildInfo reads the version control information embedded by the Go toolchain
+func buildInfo(started time.Time) BuildInfo {
+	build := BuildInfo{Version: Version, GoVersion: runtime.Version(), StartedAt: started.UTC()}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				build.Commit = setting.Value
+			case "vcs.modified":
+				build.Modified = setting.Value == "true"
+			}
+		}
+	}
+	return build
+}
+
+// RegisterRoutes mounts /healthz and /readyz
+func RegisterRoutes(r *mux.Router, c *Checker) {
+	r.HandleFunc("/healthz", c.LivenessHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/readyz", c.ReadinessHandler).Methods("GET", "HEAD")
+}
+
+// writeJSON writes a JSON response with the given status
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+// LivenessHandler reports that the process is serving requests
+func (c *Checker) LivenessHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":         "ok",
+		"uptime_seconds": int(time.Since(c.build.StartedAt).Seconds()),
+	})
+}
+
+// ReadinessHandler reports whether the server can do its work: 200 when it is ready or
+// degraded, 503 when the cache backend is unreachable or, with ?deep=true, when no
+// geocoding provider can be reached
+func (c *Checker) ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	readiness := c.Readiness(r.Context(), r.URL.Query().Get("deep") == "true")
+
+	status := http.StatusOK
+	if readiness.Status == "unavailable" {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, readiness)
+}
+
+// Readiness builds the readiness report, probing the providers when deep is set
+func (c *Checker) Readiness(ctx context.Context, deep bool) Readiness {
+	readiness := Readiness{
+		Status:    "ready",
+		Build:     c.build,
+		Cache:     checkCache(c.cfg.Cache.Backend),
+		Providers: make(map[string]ProviderReport, len(config.AllProviders)),
+	}
+
+	var probes map[string]external.ProbeResult
+	if deep {
+		probes = c.probes(ctx)
+	}
+
+	statuses := external.UpstreamStatuses()
+	degraded := false
+	geocoders, reachableGeocoders := 0, 0
+	for _, name := range config.AllProviders {
+		report := ProviderReport{
+			Configured:     c.cfg.Providers.APIKey(name) != "" || name == config.Nominatim,
+			Enabled:        c.cfg.Providers.Enabled(name),
+			UpstreamStatus: statuses[name],
+		}
+		if !report.Enabled {
+			readiness.Providers[name] = report
+			continue
+		}
+		if !report.Healthy() {
+			degraded = true
+		}
+
+		if probe, ok := probes[name]; ok {
+			report.Probe = &probe
+			if !probe.Reachable {
+				degraded = true
+			}
+			if isGeocoder(name) {
+				geocoders++
+				if probe.Reachable {
+					reachableGeocoders++
+				}
+			}
+		}
+		readiness.Providers[name] = report
+	}
+
+	switch {
+	case !readiness.Cache.OK || (geocoders > 0 && reachableGeocoders == 0):
+		readiness.Status = "unavailable"
+	case degraded:
+		readiness.Status = "degraded"
+	}
+	return readiness
+}
+
+// isGeocoder reports whether a provider implements geocoding
+func isGeocoder(name string) bool {
+	for _, geocoder := range config.GeocodingProviders {
+		if geocoder == name {
+			return true
+		}
+	}
+	return false
+}
+
+// checkCache pings the cache backend when it lives outside of the process
+func checkCache(backend string) CacheReport {
+	report := CacheReport{Backend: backend, OK: true}
+	if pinger, ok := external.GlobalCache.(external.CachePinger); ok {
+		if err := pinger.Ping(); err != nil {
+			report.OK = false
+			report.Error = err.Error()
+		}
+	}
+	return report
+}
+
+// probes returns the result of the latest deep checks, probing the providers again when
+// it is older than deepCheckInterval. Concurrent checks share the same probes.
+func (c *Checker) probes(ctx context.Context) map[string]external.ProbeResult {
+	c.mu.Lock()
+	if c.deep != nil && time.Since(c.deepAt) < deepCheckInterval {
+		defer c.mu.Unlock()
+		return c.deep
+	}
+	if flight := c.deepFlight; flight != nil {
+		c.mu.Unlock()
+		select {
+		case <-flight:
+		case <-ctx.Done():
+			return nil
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.deep
+	}
+	flight := make(chan struct{})
+	c.deepFlight = flight
+	c.mu.Unlock()
+
+	// The probes are shared, the request that started them should not cancel them
+	results := c.service.ProbeProviders(context.WithoutCancel(ctx))
+
+	c.mu.Lock()
+	c.deep, c.deepAt, c.deepFlight = results, time.Now(), nil
+	c.mu.Unlock()
+	close(flight)
+	return results
+}
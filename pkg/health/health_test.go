@@ -0,0 +1,92 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/igorsilvestre/simple-go-server/pkg/config"
+	"github.com/igorsilvestre/simple-go-server/pkg/external"
+)
+
+// newTestRouter serves the health routes of a server with Google and Nominatim enabled
+func newTestRouter(t *testing.T, urls external.ProviderURLs) http.Handler {
+	cfg := config.Default()
+	cfg.Providers.Google.APIKey = "google-key"
+	cfg.RateLimit.Providers = nil
+
+	r := mux.NewRouter()
+	RegisterRoutes(r, New(cfg, external.NewService(cfg, external.ServiceOptions{URLs: urls})))
+	return r
+}
+
+// getReadiness requests a readiness report
+func getReadiness(t *testing.T, router http.Handler, target string) (int, Readiness) {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+
+	var readiness Readiness
+	if err := json.Unmarshal(w.Body.Bytes(), &readiness); err != nil {
+		t.Fatalf("error decoding %q: %v", w.Body, err)
+	}
+	return w.Code, readiness
+}
+
+func TestLiveness(t *testing.T) {
+	router := newTestRouter(t, external.ProviderURLs{})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	reachable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead || r.URL.RawQuery != "" {
+			t.Errorf("probe = %s %s, want a HEAD without API key", r.Method, r.URL)
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer reachable.Close()
+
+	// A closed server refuses connections
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	tests := []struct {
+		name       string
+		urls       external.ProviderURLs
+		target     string
+		wantCode   int
+		wantStatus string
+	}{
+		{name: "shallow", urls: external.ProviderURLs{Google: down.URL, Nominatim: down.URL}, target: "/readyz", wantCode: http.StatusOK, wantStatus: "ready"},
+		{name: "deep, every geocoder reachable", urls: external.ProviderURLs{Google: reachable.URL, Nominatim: reachable.URL}, target: "/readyz?deep=true", wantCode: http.StatusOK, wantStatus: "ready"},
+		{name: "deep, one geocoder down", urls: external.ProviderURLs{Google: reachable.URL, Nominatim: down.URL}, target: "/readyz?deep=true", wantCode: http.StatusOK, wantStatus: "degraded"},
+		{name: "deep, every geocoder down", urls: external.ProviderURLs{Google: down.URL, Nominatim: down.URL}, target: "/readyz?deep=true", wantCode: http.StatusServiceUnavailable, wantStatus: "unavailable"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, readiness := getReadiness(t, newTestRouter(t, tt.urls), tt.target)
+			if code != tt.wantCode || readiness.Status != tt.wantStatus {
+				t.Fatalf("readiness = %d %s, want %d %s", code, readiness.Status, tt.wantCode, tt.wantStatus)
+			}
+
+			if !readiness.Cache.OK || readiness.Cache.Backend != "memory" || readiness.Build.GoVersion == "" {
+				t.Errorf("readiness = %+v, want a working memory cache and the build info", readiness)
+			}
+			google, geoapify := readiness.Providers[config.Google], readiness.Providers[config.Geoapify]
+			if !google.Configured || !google.Enabled || geoapify.Configured || geoapify.Enabled || geoapify.Probe != nil {
+				t.Errorf("providers = %+v, want only Google and Nominatim enabled", readiness.Providers)
+			}
+			if deep := tt.target != "/readyz"; deep != (google.Probe != nil) {
+				t.Errorf("google probe = %+v, want a probe only for deep checks", google.Probe)
+			}
+		})
+	}
+}
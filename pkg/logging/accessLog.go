@@ -0,0 +1,85 @@
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/igorsilvestre/simple-go-server/internal/httpx"
+)
+
+// Header is the request and response header carrying the request ID
+const Header = "X-Request-ID"
+
+// maxRequestIDLength bounds the request IDs accepted from clients
+const maxRequestIDLength = 128
+
+// validRequestID reports whether a request ID sent by a client can be reused. IDs are
+// limited to printable ASCII without spaces so they cannot forge log lines.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID generates a random request ID
+func newRequestID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// Middleware gives every request an ID, taken from X-Request-ID when the client sends a
+// valid one, and writes an access line once the response is complete. The ID is returned
+// in X-Request-ID and carried by the request context into the logs of the handlers.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get(Header)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(Header, id)
+
+		ctx, log := withRequestLog(r.Context(), id)
+		recorder := httpx.NewRecorder(w)
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		status := recorder.Status()
+
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+		}
+		if r.URL.RawQuery != "" {
+			query := r.URL.RawQuery
+			if redacted, ok := RedactAddresses(r.URL.Query()); ok {
+				query = redacted.Encode()
+			}
+			attrs = append(attrs, slog.String("query", query))
+		}
+		attrs = append(attrs,
+			slog.Int("status", status),
+			slog.Int("bytes", recorder.Bytes()),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("remote_addr", r.RemoteAddr),
+		)
+		attrs = append(attrs, log.attrs()...)
+
+		// Server errors stay visible at the warn level
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelWarn
+		}
+		slog.LogAttrs(ctx, level, "Request", attrs...)
+	})
+}
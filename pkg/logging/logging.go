@@ -0,0 +1,199 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/igorsilvestre/simple-go-server/pkg/config"
+)
+
+// redactAddresses is set from the configuration by Setup
+var redactAddresses atomic.Bool
+
+// addressParams are the query parameters, of the API and of the providers, that carry an
+// address or a position looked up by a client
+var addressParams = []string{"address", "q", "text", "input", "latlng", "lat", "lon"}
+
+// Setup makes the logger described by the configuration the default one. The standard log
+// package writes through it as well, so every line of the server has the same format.
+func Setup(cfg config.LogConfig, w io.Writer) {
+	slog.SetDefault(New(cfg, w))
+	redactAddresses.Store(cfg.RedactAddresses)
+}
+
+// New creates a logger writing to w in the configured format. Records logged with a
+// request context carry its request ID.
+func New(cfg config.LogConfig, w io.Writer) *slog.Logger {
+	// The level is checked by the configuration validation
+	var level slog.Level
+	level.UnmarshalText([]byte(cfg.Level))
+	options := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	if cfg.Format == "text" {
+		handler = slog.NewTextHandler(w, options)
+	} else {
+		handler = slog.NewJSONHandler(w, options)
+	}
+	return slog.New(contextHandler{handler})
+}
+
+// contextHandler adds the request ID of the context to every record
+type contextHandler struct {
+	slog.Handler
+}
+
+// Handle adds the request ID before passing the record on
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+// WithAttrs keeps the request ID on loggers with attributes
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup keeps the request ID on loggers with a group
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// RedactingAddresses reports whether addresses are hidden from the logs
+func RedactingAddresses() bool {
+	return redactAddresses.Load()
+}
+
+// RedactAddresses replaces the values of the address parameters of a query with REDACTED
+// when redaction is enabled. It reports whether the query was changed; the original
+// query is left untouched.
+func RedactAddresses(query url.Values) (url.Values, bool) {
+	if !RedactingAddresses() {
+		return query, false
+	}
+
+	var redacted url.Values
+	for _, name := range addressParams {
+		if !query.Has(name) {
+			continue
+		}
+		if redacted == nil {
+			redacted = make(url.Values, len(query))
+			for key, values := range query {
+				redacted[key] = values
+			}
+		}
+		redacted.Set(name, "REDACTED")
+	}
+	if redacted == nil {
+		return query, false
+	}
+	return redacted, true
+}
+
+// requestLog collects what happens while a request is served, for its access line. It is
+// shared by the goroutines working on the request.
+type requestLog struct {
+	id string
+
+	mu        sync.Mutex
+	client    string
+	cache     map[string]int
+	upstreams map[string]int
+}
+
+// requestLogKey carries the requestLog of a request
+type requestLogKey struct{}
+
+// withRequestLog starts the log of a request with the given ID
+func withRequestLog(ctx context.Context, id string) (context.Context, *requestLog) {
+	log := &requestLog{id: id}
+	return context.WithValue(ctx, requestLogKey{}, log), log
+}
+
+// fromContext returns the log of the request ctx belongs to, or nil
+func fromContext(ctx context.Context) *requestLog {
+	log, _ := ctx.Value(requestLogKey{}).(*requestLog)
+	return log
+}
+
+// RequestID returns the ID of the request ctx belongs to, or "" outside of a request
+func RequestID(ctx context.Context) string {
+	if log := fromContext(ctx); log != nil {
+		return log.id
+	}
+	return ""
+}
+
+// SetClient names the API key client that made the request
+func SetClient(ctx context.Context, name string) {
+	if log := fromContext(ctx); log != nil {
+		log.mu.Lock()
+		log.client = name
+		log.mu.Unlock()
+	}
+}
+
+// RecordCache counts a cache lookup of the request by outcome, such as "hit" or "miss"
+func RecordCache(ctx context.Context, outcome string) {
+	if log := fromContext(ctx); log != nil {
+		log.mu.Lock()
+		if log.cache == nil {
+			log.cache = make(map[string]int)
+		}
+		log.cache[outcome]++
+		log.mu.Unlock()
+	}
+}
+
+// RecordUpstream counts a call made to a provider for the request
+func RecordUpstream(ctx context.Context, provider string) {
+	if log := fromContext(ctx); log != nil {
+		log.mu.Lock()
+		if log.upstreams == nil {
+			log.upstreams = make(map[string]int)
+		}
+		log.upstreams[provider]++
+		log.mu.Unlock()
+	}
+}
+
+// attrs returns the client, cache outcomes and upstream calls of the request
+func (l *requestLog) attrs() []slog.Attr {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var attrs []slog.Attr
+	if l.client != "" {
+		attrs = append(attrs, slog.String("client", l.client))
+	}
+	if len(l.cache) > 0 {
+		attrs = append(attrs, countGroup("cache", l.cache))
+	}
+	if len(l.upstreams) > 0 {
+		attrs = append(attrs, countGroup("upstream", l.upstreams))
+	}
+	return attrs
+}
+
+// countGroup logs counts by name as a group sorted by name
+func countGroup(group string, counts map[string]int) slog.Attr {
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	attrs := make([]any, 0, len(names))
+	for _, name := range names {
+		attrs = append(attrs, slog.Int(name, counts[name]))
+	}
+	return slog.Group(group, attrs...)
+}
@@ -0,0 +1,119 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/igorsilvestre/simple-go-server/pkg/config"
+)
+
+// captureLogs makes the default logger write JSON into the returned buffer for the test
+func captureLogs(t *testing.T, redact bool) *bytes.Buffer {
+	previous := slog.Default()
+	var buf bytes.Buffer
+	Setup(config.LogConfig{Format: "json", Level: "info", RedactAddresses: redact}, &buf)
+	t.Cleanup(func() {
+		slog.SetDefault(previous)
+		redactAddresses.Store(false)
+	})
+	return &buf
+}
+
+// logLines decodes the JSON lines of the buffer
+func logLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var decoded map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &decoded); err != nil {
+			t.Fatalf("error decoding log line %q: %v", line, err)
+		}
+		lines = append(lines, decoded)
+	}
+	return lines
+}
+
+func TestMiddleware(t *testing.T) {
+	buf := captureLogs(t, false)
+
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		SetClient(ctx, "website")
+		RecordCache(ctx, "hit")
+		RecordCache(ctx, "miss")
+		RecordUpstream(ctx, "google")
+		slog.InfoContext(ctx, "Handler")
+
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/external/geocode?address=Rua+A", nil)
+	req.Header.Set(Header, "client-id-1")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if id := w.Header().Get(Header); id != "client-id-1" {
+		t.Errorf("%s = %q, want the ID sent by the client", Header, id)
+	}
+
+	lines := logLines(t, buf)
+	if len(lines) != 2 {
+		t.Fatalf("got %d log lines, want 2: %s", len(lines), buf)
+	}
+	if lines[0]["msg"] != "Handler" || lines[0]["request_id"] != "client-id-1" {
+		t.Errorf("handler line = %v, want the request ID", lines[0])
+	}
+
+	access := lines[1]
+	want := map[string]interface{}{
+		"msg":        "Request",
+		"request_id": "client-id-1",
+		"method":     "GET",
+		"path":       "/external/geocode",
+		"query":      "address=Rua+A",
+		"status":     float64(http.StatusCreated),
+		"bytes":      float64(len("created")),
+		"client":     "website",
+	}
+	for key, value := range want {
+		if access[key] != value {
+			t.Errorf("access line %s = %v, want %v", key, access[key], value)
+		}
+	}
+	if cache, _ := access["cache"].(map[string]interface{}); cache["hit"] != float64(1) || cache["miss"] != float64(1) {
+		t.Errorf("access line cache = %v, want 1 hit and 1 miss", access["cache"])
+	}
+	if upstream, _ := access["upstream"].(map[string]interface{}); upstream["google"] != float64(1) {
+		t.Errorf("access line upstream = %v, want 1 google call", access["upstream"])
+	}
+}
+
+func TestMiddlewareGeneratesRequestIDs(t *testing.T) {
+	buf := captureLogs(t, true)
+
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	// An ID that could forge log lines is replaced
+	req := httptest.NewRequest(http.MethodGet, "/external/reverse-geocode?lat=-23.5&lon=-46.6&provider=google", nil)
+	req.Header.Set(Header, "forged id\n")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	id := w.Header().Get(Header)
+	if len(id) != 32 {
+		t.Errorf("%s = %q, want a generated ID", Header, id)
+	}
+
+	access := logLines(t, buf)[0]
+	if access["request_id"] != id || access["status"] != float64(http.StatusOK) {
+		t.Errorf("access line = %v, want status 200 and the generated ID", access)
+	}
+	if query := access["query"]; query != "lat=REDACTED&lon=REDACTED&provider=google" {
+		t.Errorf("access line query = %v, want the coordinates redacted", query)
+	}
+}
@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/igorsilvestre/simple-go-server/internal/httpx"
+)
+
+var (
+	httpRequests = NewCounter("http_requests_total",
+		"Requests served, by route template, method and status code.", "route", "method", "status")
+	httpDuration = NewHistogram("http_request_duration_seconds",
+		"Time to serve a request, by route template and method.", DefaultBuckets, "route", "method")
+)
+
+// Middleware counts the requests served through router and measures their latency.
+// Requests are labeled by route template, such as /external/whois/{domain}, so that paths
+// do not create a series each. It wraps the router instead of being one of its
+// middlewares, which only run for matched routes, so that the requests no route matches
+// are counted under the "unmatched" route.
+func Middleware(router *mux.Router) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			recorder := httpx.NewRecorder(w)
+			next.ServeHTTP(recorder, r)
+
+			route := "unmatched"
+			var match mux.RouteMatch
+			if router.Match(r, &match) && match.Route != nil {
+				if template, err := match.Route.GetPathTemplate(); err == nil {
+					route = template
+				}
+			}
+
+			httpRequests.Inc(route, r.Method, strconv.Itoa(recorder.Status()))
+			httpDuration.Observe(time.Since(start).Seconds(), route, r.Method)
+		})
+	}
+}
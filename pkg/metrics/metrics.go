@@ -0,0 +1,307 @@
+package metrics
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/igorsilvestre/simple-go-server/pkg/problem"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of the latency histograms
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Default is the registry served by the /metrics endpoint, where the metrics created by
+// NewCounter and NewHistogram are registered
+var Default = NewRegistry()
+
+// metric is a metric family that can write itself in the Prometheus text format
+type metric interface {
+	write(w *Writer)
+}
+
+// Collector writes metrics computed at scrape time, such as the size of the cache
+type Collector func(w *Writer)
+
+// Registry holds the metrics exposed together
+type Registry struct {
+	mu         sync.Mutex
+	metrics    []metric
+	collectors []Collector
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// register adds a metric family to the registry
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// RegisterCollector adds a collector called on every scrape
+func (r *Registry) RegisterCollector(c Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// WriteTo writes every metric of the registry in the Prometheus text format
+func (r *Registry) WriteTo(out io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	collectors := append([]Collector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	w := &Writer{w: bufio.NewWriter(out)}
+	for _, m := range metrics {
+		m.write(w)
+	}
+	for _, collect := range collectors {
+		collect(w)
+	}
+	if err := w.w.Flush(); err != nil {
+		return w.n, err
+	}
+	return w.n, nil
+}
+
+// Handler serves the registry to Prometheus. When token is set, scrapes must carry it as
+// a bearer token.
+func (r *Registry) Handler(token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if token != "" {
+			given, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+				problem.Write(w, req, problem.New(http.StatusUnauthorized, problem.Unauthorized, "Missing or invalid metrics token"))
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteTo(w)
+	})
+}
+
+// Writer writes metric families in the Prometheus text format
+type Writer struct {
+	w *bufio.Writer
+	n int64
+}
+
+// printf writes formatted text and counts the bytes written
+func (w *Writer) printf(format string, args ...interface{}) {
+	n, _ := fmt.Fprintf(w.w, format, args...)
+	w.n += int64(n)
+}
+
+// header writes the HELP and TYPE lines of a metric family
+func (w *Writer) header(name, help, kind string) {
+	w.printf("# HELP %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help))
+	w.printf("# TYPE %s %s\n", name, kind)
+}
+
+// sample writes a single sample line
+func (w *Writer) sample(name string, labels []string, values []string, value float64) {
+	w.printf("%s%s %s\n", name, formatLabels(labels, values), formatValue(value))
+}
+
+// Counter writes a counter without labels
+func (w *Writer) Counter(name, help string, value float64) {
+	w.header(name, help, "counter")
+	w.sample(name, nil, nil, value)
+}
+
+// Gauge writes a gauge without labels
+func (w *Writer) Gauge(name, help string, value float64) {
+	w.header(name, help, "gauge")
+	w.sample(name, nil, nil, value)
+}
+
+// formatLabels formats label pairs as {name="value",...}
+func formatLabels(labels []string, values []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, label := range labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(label)
+		b.WriteString(`="`)
+		b.WriteString(escaper.Replace(values[i]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// formatValue formats a sample value, including infinities
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// labelKey joins label values into a map key
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// sortedKeys returns the keys of the series of a family in a stable order
+func sortedKeys[T any](series map[string]T) []string {
+	keys := make([]string, 0, len(series))
+	for key := range series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Counter is a family of counters partitioned by labels
+type Counter struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	series map[string]*counterSeries
+}
+
+// counterSeries is the counter of one combination of label values
+type counterSeries struct {
+	values []string
+	value  float64
+}
+
+// NewCounter creates a counter family with the given labels and registers it in Default
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{name: name, help: help, labels: labels, series: make(map[string]*counterSeries)}
+	Default.register(c)
+	return c
+}
+
+// Inc adds one to the counter of the label values
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds delta to the counter of the label values, which must match the labels of the family
+func (c *Counter) Add(delta float64, values ...string) {
+	if len(values) != len(c.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", c.name, len(c.labels), len(values)))
+	}
+	key := labelKey(values)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.series[key]
+	if !ok {
+		s = &counterSeries{values: append([]string(nil), values...)}
+		c.series[key] = s
+	}
+	s.value += delta
+}
+
+// write writes every series of the family
+func (c *Counter) write(w *Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	w.header(c.name, c.help, "counter")
+	// A counter without labels is exposed at zero before its first increment
+	if len(c.labels) == 0 && len(c.series) == 0 {
+		w.sample(c.name, nil, nil, 0)
+	}
+	for _, key := range sortedKeys(c.series) {
+		s := c.series[key]
+		w.sample(c.name, c.labels, s.values, s.value)
+	}
+}
+
+// Histogram is a family of histograms partitioned by labels
+type Histogram struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+// histogramSeries is the histogram of one combination of label values
+type histogramSeries struct {
+	values []string
+	// counts holds the observations of each bucket, not cumulated
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram creates a histogram family with the given bucket upper bounds and labels and
+// registers it in Default
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histogramSeries)}
+	Default.register(h)
+	return h
+}
+
+// Observe adds an observation to the histogram of the label values
+func (h *Histogram) Observe(value float64, values ...string) {
+	if len(values) != len(h.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", h.name, len(h.labels), len(values)))
+	}
+	key := labelKey(values)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{values: append([]string(nil), values...), counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	if i := sort.SearchFloat64s(h.buckets, value); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += value
+}
+
+// write writes the buckets, sum and count of every series of the family
+func (h *Histogram) write(w *Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	w.header(h.name, h.help, "histogram")
+	bucketLabels := append(append([]string(nil), h.labels...), "le")
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			w.sample(h.name+"_bucket", bucketLabels, append(append([]string(nil), s.values...), formatValue(bound)), float64(cumulative))
+		}
+		w.sample(h.name+"_bucket", bucketLabels, append(append([]string(nil), s.values...), "+Inf"), float64(s.count))
+		w.sample(h.name+"_sum", h.labels, s.values, s.sum)
+		w.sample(h.name+"_count", h.labels, s.values, float64(s.count))
+	}
+}
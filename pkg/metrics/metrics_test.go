@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestRegistry(t *testing.T) {
+	counter := NewCounter("test_calls_total", "Calls by provider.", "provider")
+	counter.Inc("google")
+	counter.Add(2, `quo"te`)
+
+	histogram := NewHistogram("test_duration_seconds", "Durations.", []float64{0.1, 1}, "provider")
+	histogram.Observe(0.05, "google")
+	histogram.Observe(0.5, "google")
+	histogram.Observe(3, "google")
+
+	Default.RegisterCollector(func(w *Writer) {
+		w.Gauge("test_entries", "Entries.", 42)
+	})
+
+	var out strings.Builder
+	if _, err := Default.WriteTo(&out); err != nil {
+		t.Fatalf("error writing metrics: %v", err)
+	}
+
+	for _, want := range []string{
+		"# HELP test_calls_total Calls by provider.\n# TYPE test_calls_total counter\n",
+		`test_calls_total{provider="google"} 1` + "\n",
+		`test_calls_total{provider="quo\"te"} 2` + "\n",
+		"# TYPE test_duration_seconds histogram\n",
+		`test_duration_seconds_bucket{provider="google",le="0.1"} 1` + "\n",
+		`test_duration_seconds_bucket{provider="google",le="1"} 2` + "\n",
+		`test_duration_seconds_bucket{provider="google",le="+Inf"} 3` + "\n",
+		`test_duration_seconds_sum{provider="google"} 3.55` + "\n",
+		`test_duration_seconds_count{provider="google"} 3` + "\n",
+		"# TYPE test_entries gauge\ntest_entries 42\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("metrics do not contain %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestMiddlewareAndHandler(t *testing.T) {
+	r := mux.NewRouter()
+	r.HandleFunc("/whois/{domain}", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "not found", http.StatusNotFound)
+	}).Methods("GET")
+	r.Handle("/metrics", Default.Handler("secret")).Methods("GET")
+	handler := Middleware(r)(r)
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/whois/example.com", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/wp-login.php", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/whois/example.com", nil))
+
+	// Scrapes need the token
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	// Requests are labeled by route template, not by path, and the ones no route matches
+	// by method or path are counted together
+	for _, want := range []string{
+		`http_requests_total{route="/whois/{domain}",method="GET",status="404"} 1`,
+		`http_requests_total{route="unmatched",method="GET",status="404"} 1`,
+		`http_requests_total{route="unmatched",method="DELETE",status="405"} 1`,
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("metrics do not contain %q:\n%s", want, w.Body)
+		}
+	}
+}
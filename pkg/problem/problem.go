@@ -0,0 +1,156 @@
+package problem
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/igorsilvestre/simple-go-server/pkg/logging"
+)
+
+// ContentType is the media type of problem details (RFC 7807)
+const ContentType = "application/problem+json"
+
+// typePrefix prefixes the code of a problem to build its type URI
+const typePrefix = "urn:simple-go-server:problem:"
+
+// Stable error codes. Clients branch on these, so they must never change.
+const (
+	// MissingParameter: a required query parameter, path parameter or body field is absent
+	MissingParameter = "missing_parameter"
+	// InvalidParameter: a parameter is present but cannot be used
+	InvalidParameter = "invalid_parameter"
+	// InvalidBody: the request body cannot be parsed
+	InvalidBody = "invalid_body"
+	// PayloadTooLarge: the request body or batch is over its limit
+	PayloadTooLarge = "payload_too_large"
+	// NoResults: the provider answered but found nothing
+	NoResults = "no_results"
+	// UpstreamUnavailable: the provider could not be reached, failed or throttled us
+	UpstreamUnavailable = "upstream_unavailable"
+	// ProviderNotConfigured: the selected provider is unknown or has no API key
+	ProviderNotConfigured = "provider_not_configured"
+	// Unauthorized: the request carries no valid credentials
+	Unauthorized = "unauthorized"
+	// Forbidden: the credentials may not call this route
+	Forbidden = "forbidden"
+	// QuotaExceeded: the daily or monthly quota of the API key is exhausted
+	QuotaExceeded = "quota_exceeded"
+	// RateLimited: the client sends requests faster than allowed
+	RateLimited = "rate_limited"
+	// NotFound: the route or the resource does not exist
+	NotFound = "not_found"
+	// MethodNotAllowed: the route does not accept the method
+	MethodNotAllowed = "method_not_allowed"
+	// NotImplemented: the feature is not supported by the current configuration
+	NotImplemented = "not_implemented"
+	// InternalError: the server failed on its own
+	InternalError = "internal_error"
+)
+
+// Problem is an RFC 7807 problem details object. Code repeats the last segment of Type
+// so clients do not have to parse URIs; Provider names the failing upstream provider.
+type Problem struct {
+	Type      string
+	Title     string
+	Status    int
+	Detail    string
+	Instance  string
+	Code      string
+	Provider  string
+	RequestID string
+
+	// Extensions are extra members, such as retry_after on a 429
+	Extensions map[string]interface{}
+}
+
+// New creates a problem with the given status, code and human readable detail
+func New(status int, code, detail string) *Problem {
+	return &Problem{
+		Type:   typePrefix + code,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// Newf creates a problem with a formatted detail
+func Newf(status int, code, format string, args ...interface{}) *Problem {
+	return New(status, code, fmt.Sprintf(format, args...))
+}
+
+// Missing reports a missing required parameter
+func Missing(name string) *Problem {
+	return Newf(http.StatusBadRequest, MissingParameter, "Missing '%s' parameter", name).With("parameter", name)
+}
+
+// Invalid reports a parameter that cannot be used
+func Invalid(name, detail string) *Problem {
+	return New(http.StatusBadRequest, InvalidParameter, detail).With("parameter", name)
+}
+
+// WithProvider sets the failing provider
+func (p *Problem) WithProvider(provider string) *Problem {
+	p.Provider = provider
+	return p
+}
+
+// With adds an extension member
+func (p *Problem) With(key string, value interface{}) *Problem {
+	if p.Extensions == nil {
+		p.Extensions = make(map[string]interface{})
+	}
+	p.Extensions[key] = value
+	return p
+}
+
+// MarshalJSON writes the standard members, then the extensions. Extensions never
+// override a standard member.
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	members := make(map[string]interface{}, len(p.Extensions)+8)
+	for key, value := range p.Extensions {
+		members[key] = value
+	}
+	members["type"] = p.Type
+	members["title"] = p.Title
+	members["status"] = p.Status
+	members["code"] = p.Code
+	if p.Detail != "" {
+		members["detail"] = p.Detail
+	}
+	if p.Instance != "" {
+		members["instance"] = p.Instance
+	}
+	if p.Provider != "" {
+		members["provider"] = p.Provider
+	}
+	if p.RequestID != "" {
+		members["request_id"] = p.RequestID
+	}
+	return json.Marshal(members)
+}
+
+// Write sends a problem as the response, filling the instance with the request path and
+// the request ID so the client can quote it when reporting the error
+func Write(w http.ResponseWriter, r *http.Request, p *Problem) {
+	if p.Instance == "" {
+		p.Instance = r.URL.Path
+	}
+	if p.RequestID == "" {
+		p.RequestID = logging.RequestID(r.Context())
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// Handler returns a handler answering every request with a problem of the given status,
+// for the router's not found and method not allowed handlers
+func Handler(status int, code, detail string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Write(w, r, New(status, code, detail))
+	})
+}
@@ -0,0 +1,52 @@
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/igorsilvestre/simple-go-server/pkg/logging"
+)
+
+func TestWrite(t *testing.T) {
+	handler := logging.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Write(w, r, New(http.StatusBadGateway, UpstreamUnavailable, "non-200 response from Nominatim (500)").
+			WithProvider("nominatim").
+			With("retry_after", 3).
+			With("status", "overridden"))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/external/geocode-nominatim?address=x", nil)
+	req.Header.Set(logging.Header, "request-1")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadGateway || w.Header().Get("Content-Type") != ContentType {
+		t.Fatalf("response = %d %q, want %d %q", w.Code, w.Header().Get("Content-Type"), http.StatusBadGateway, ContentType)
+	}
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("error decoding %q: %v", w.Body, err)
+	}
+	want := map[string]interface{}{
+		"type":        "urn:simple-go-server:problem:upstream_unavailable",
+		"title":       "Bad Gateway",
+		"status":      float64(http.StatusBadGateway),
+		"code":        UpstreamUnavailable,
+		"detail":      "non-200 response from Nominatim (500)",
+		"instance":    "/external/geocode-nominatim",
+		"provider":    "nominatim",
+		"request_id":  "request-1",
+		"retry_after": float64(3),
+	}
+	if len(body) != len(want) {
+		t.Errorf("body = %v, want %v", body, want)
+	}
+	for key, value := range want {
+		if body[key] != value {
+			t.Errorf("%s = %v, want %v", key, body[key], value)
+		}
+	}
+}
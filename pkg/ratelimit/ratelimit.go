@@ -0,0 +1,236 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/igorsilvestre/simple-go-server/pkg/problem"
+)
+
+// sweepInterval is how often idle buckets are removed
+const sweepInterval = time.Minute
+
+// bucket is the token bucket of a single key
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter is a set of token buckets sharing the same rate, one per key (a client IP, an
+// API key, a provider...). A nil Limiter allows everything.
+type Limiter struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// New creates a limiter allowing rate requests per second with bursts of burst requests.
+// It returns nil, which allows everything, when rate is not positive.
+func New(rate float64, burst int) *Limiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{
+		rate:    rate,
+		burst:   float64(burst),
+		now:     time.Now,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// take refills the bucket of key and takes n tokens from it. When the bucket holds fewer
+// the tokens are only taken if they become available within maxWait, and the wait is
+// returned. Otherwise ok is false and wait is the time until they are available. A charge
+// larger than the burst only needs a full bucket, which it leaves in debt.
+func (l *Limiter) take(key string, n float64, maxWait time.Duration) (wait time.Duration, ok bool) {
+	if l == nil {
+		return 0, true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	b, found := l.buckets[key]
+	if !found {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	// The bucket can go negative: every waiting caller holds a future token, in order
+	missing := math.Min(n, l.burst) - b.tokens
+	if missing > 0 {
+		wait = time.Duration(missing / l.rate * float64(time.Second))
+		if wait > maxWait {
+			return wait, false
+		}
+	}
+	b.tokens -= n
+	return wait, true
+}
+
+// sweep removes the buckets that have been idle long enough to be full again, so that
+// keys seen once do not accumulate
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	refill := time.Duration(l.burst / l.rate * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > refill {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Allow takes a token for key without waiting. When the bucket is empty it returns false
+// and how long to wait before retrying.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	return l.AllowN(key, 1)
+}
+
+// AllowN takes n tokens for key without waiting, for work that costs more than one
+// request. When they are not available it returns false and how long to wait before
+// retrying.
+func (l *Limiter) AllowN(key string, n int) (bool, time.Duration) {
+	wait, ok := l.take(key, float64(n), 0)
+	return ok, wait
+}
+
+// ErrLimited is returned by Wait when a token would not be available within the maximum wait
+var ErrLimited = errors.New("rate limit exceeded")
+
+// Wait takes a token for key, waiting up to maxWait for one to become available
+func (l *Limiter) Wait(ctx context.Context, key string, maxWait time.Duration) error {
+	wait, ok := l.take(key, 1, maxWait)
+	if !ok {
+		return fmt.Errorf("%w, next slot in %s", ErrLimited, wait.Round(time.Millisecond))
+	}
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// KeyFunc returns the key a request is limited by, or false to let it through unlimited
+type KeyFunc func(r *http.Request) (string, bool)
+
+// Middleware rejects the requests over the limit of their key with 429
+func (l *Limiter) Middleware(key KeyFunc) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if l == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			k, ok := key(r)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if allowed, wait := l.Allow(k); !allowed {
+				writeRateLimited(w, r, wait)
+				return
+			}
+
+			// The handler charges the rest of an expensive request to the same bucket
+			ctx := WithCharger(r.Context(), func(w http.ResponseWriter, r *http.Request, n int) bool {
+				if allowed, wait := l.AllowN(k, n); !allowed {
+					writeRateLimited(w, r, wait)
+					return false
+				}
+				return true
+			})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Charger charges n more units of work to a request that a middleware let through, such
+// as the rows of a batch. When they do not fit it writes the rejection and returns false.
+type Charger func(w http.ResponseWriter, r *http.Request, n int) bool
+
+// chargersContextKey carries the chargers registered by the middlewares of a request
+type chargersContextKey struct{}
+
+// WithCharger registers a charger for the rest of the request, after the ones of the
+// middlewares that ran before
+func WithCharger(ctx context.Context, charger Charger) context.Context {
+	chargers, _ := ctx.Value(chargersContextKey{}).([]Charger)
+	chargers = append(chargers[:len(chargers):len(chargers)], charger)
+	return context.WithValue(ctx, chargersContextKey{}, chargers)
+}
+
+// Charge charges n more units to every limit and quota the request went through, in the
+// order of their middlewares. It writes the rejection of the first one they do not fit
+// and returns false; the limits charged before it keep the charge, as they do for a
+// request rejected by a later middleware.
+func Charge(w http.ResponseWriter, r *http.Request, n int) bool {
+	if n <= 0 {
+		return true
+	}
+	chargers, _ := r.Context().Value(chargersContextKey{}).([]Charger)
+	for _, charge := range chargers {
+		if !charge(w, r, n) {
+			return false
+		}
+	}
+	return true
+}
+
+// writeRateLimited responds with 429 and tells the client when to retry
+func writeRateLimited(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	retryAfter := int(math.Ceil(wait.Seconds()))
+
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	problem.Write(w, r, problem.Newf(http.StatusTooManyRequests, problem.RateLimited,
+		"Too many requests, retry in %d seconds", retryAfter).With("retry_after", retryAfter))
+}
+
+// ClientIP returns a KeyFunc limiting requests by client IP. With trustProxy the IP is
+// the last entry of X-Forwarded-For, the one added by the proxy in front of the server;
+// the entries before it are set by the client and can be forged.
+func ClientIP(trustProxy bool) KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		if forwarded := r.Header.Values("X-Forwarded-For"); trustProxy && len(forwarded) > 0 {
+			entries := strings.Split(forwarded[len(forwarded)-1], ",")
+			return strings.TrimSpace(entries[len(entries)-1]), true
+		}
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return r.RemoteAddr, true
+		}
+		return host, true
+	}
+}
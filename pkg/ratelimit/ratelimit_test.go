@@ -0,0 +1,170 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAllow(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	l := New(2, 3)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatalf("request %d of the burst was rejected", i)
+		}
+	}
+	ok, wait := l.Allow("a")
+	if ok || wait != 500*time.Millisecond {
+		t.Errorf("Allow() = %v, %s, want false, 500ms", ok, wait)
+	}
+
+	// Other keys have their own bucket
+	if ok, _ := l.Allow("b"); !ok {
+		t.Error("another key was rejected")
+	}
+
+	// Half a second later one token is back
+	now = now.Add(500 * time.Millisecond)
+	if ok, _ := l.Allow("a"); !ok {
+		t.Error("refilled token was rejected")
+	}
+
+	// Idle buckets are removed once full again
+	now = now.Add(time.Hour)
+	l.Allow("c")
+	if _, found := l.buckets["a"]; found {
+		t.Error("idle bucket was not removed")
+	}
+}
+
+func TestAllowN(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	l := New(1, 5)
+	l.now = func() time.Time { return now }
+
+	if ok, _ := l.AllowN("a", 3); !ok {
+		t.Fatal("charge within the burst was rejected")
+	}
+	if ok, wait := l.AllowN("a", 3); ok || wait != time.Second {
+		t.Errorf("AllowN() = %v, %s, want false, 1s", ok, wait)
+	}
+
+	// A charge larger than the burst takes a full bucket and leaves it in debt
+	if ok, wait := l.AllowN("b", 20); !ok {
+		t.Errorf("AllowN() on a full bucket = false, %s, want true", wait)
+	}
+	if ok, wait := l.Allow("b"); ok || wait != 16*time.Second {
+		t.Errorf("Allow() after the debt = %v, %s, want false, 16s", ok, wait)
+	}
+	if ok, _ := l.AllowN("c", 20); !ok {
+		t.Fatal("large charge on a full bucket was rejected")
+	}
+	// Ten seconds later the debt of 15 is down to 5, and a large charge waits for a full bucket
+	now = now.Add(10 * time.Second)
+	if ok, wait := l.AllowN("c", 20); ok || wait != 10*time.Second {
+		t.Errorf("AllowN() still in debt = %v, %s, want false, 10s", ok, wait)
+	}
+}
+
+func TestCharge(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	l := New(1, 5)
+	l.now = func() time.Time { return now }
+
+	// The handler charges the rows of its request after the middleware took the first one
+	handler := l.Middleware(ClientIP(false))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Charge(w, r, 3)
+	}))
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/external/geocode/batch", nil))
+		return w
+	}
+
+	if w := serve(); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	// One token is left: the middleware takes it and the rows do not fit
+	w := serve()
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "3" {
+		t.Errorf("status = %d, Retry-After = %q, want 429 after 3s", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	// Without a middleware there is nothing to charge
+	if w := httptest.NewRecorder(); !Charge(w, httptest.NewRequest(http.MethodGet, "/", nil), 10) {
+		t.Error("Charge() without chargers = false, want true")
+	}
+}
+
+func TestWait(t *testing.T) {
+	l := New(50, 1)
+
+	if err := l.Wait(context.Background(), "nominatim", 0); err != nil {
+		t.Fatalf("first call: %v", err)
+	}
+
+	// The next token is 20ms away: queued within the maximum wait, rejected past it
+	if err := l.Wait(context.Background(), "nominatim", time.Millisecond); !errors.Is(err, ErrLimited) {
+		t.Errorf("Wait() = %v, want ErrLimited", err)
+	}
+	start := time.Now()
+	if err := l.Wait(context.Background(), "nominatim", time.Second); err != nil {
+		t.Fatalf("queued call: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 15*time.Millisecond {
+		t.Errorf("queued call returned after %s, want about 20ms", elapsed)
+	}
+
+	if err := (*Limiter)(nil).Wait(context.Background(), "any", 0); err != nil {
+		t.Errorf("nil limiter: %v", err)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	tests := []struct {
+		name       string
+		trustProxy bool
+		requests   []string // X-Forwarded-For of each request, all from the same remote address
+		wantStatus []int
+	}{
+		{
+			name:       "limited by remote address",
+			requests:   []string{"1.1.1.1", "2.2.2.2"},
+			wantStatus: []int{http.StatusOK, http.StatusTooManyRequests},
+		},
+		{
+			name:       "limited by the IP added by the proxy",
+			trustProxy: true,
+			requests:   []string{"9.9.9.9, 1.1.1.1", "8.8.8.8, 2.2.2.2", "7.7.7.7, 1.1.1.1"},
+			wantStatus: []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(1, 1)
+			handler := l.Middleware(ClientIP(tt.trustProxy))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			for i, forwarded := range tt.requests {
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				req.RemoteAddr = "10.0.0.1:4321"
+				req.Header.Set("X-Forwarded-For", forwarded)
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, req)
+
+				if w.Code != tt.wantStatus[i] {
+					t.Fatalf("request %d: status = %d, want %d", i, w.Code, tt.wantStatus[i])
+				}
+				if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") != "1" {
+					t.Errorf("Retry-After = %q, want 1", w.Header().Get("Retry-After"))
+				}
+			}
+		})
+	}
+}
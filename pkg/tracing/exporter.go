@@ -0,0 +1,295 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxQueueSize bounds the spans waiting for export; spans are dropped past it
+	maxQueueSize = 2048
+	// maxBatchSize is the number of spans sent in a single export
+	maxBatchSize = 512
+	// exportTimeout bounds a single export to the collector
+	exportTimeout = 10 * time.Second
+)
+
+// Exporter sends finished spans to a tracing backend
+type Exporter interface {
+	Export(ctx context.Context, spans []SpanData) error
+}
+
+// OTLPExporter sends spans to an OpenTelemetry collector with OTLP over HTTP, in its JSON
+// encoding. The OpenTelemetry SDK and its OTLP exporter bring in protobuf and gRPC, while
+// the server only produces flat spans, so the few messages it sends are encoded here.
+// exporter_test.go checks them against the schema of opentelemetry-proto.
+type OTLPExporter struct {
+	url         string
+	headers     map[string]string
+	serviceName string
+	client      *http.Client
+}
+
+// NewOTLPExporter creates an exporter posting to <endpoint>/v1/traces with the extra headers,
+// such as the API key of a hosted collector
+func NewOTLPExporter(endpoint string, headers map[string]string, serviceName string) *OTLPExporter {
+	return &OTLPExporter{
+		url:         strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		headers:     headers,
+		serviceName: serviceName,
+		// A plain client: exports must not be traced themselves
+		client: &http.Client{Timeout: exportTimeout},
+	}
+}
+
+// Export posts the spans to the collector
+func (e *OTLPExporter) Export(ctx context.Context, spans []SpanData) error {
+	body, err := json.Marshal(otlpRequest(e.serviceName, spans))
+	if err != nil {
+		return fmt.Errorf("error encoding spans: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating export request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range e.headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error exporting spans: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("collector returned %d: %s", resp.StatusCode, message)
+	}
+	return nil
+}
+
+// otlpValue is an attribute value in the OTLP JSON encoding
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+// otlpAttribute is a key and value pair in the OTLP JSON encoding
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+// otlpSpan is a span in the OTLP JSON encoding, where IDs are hex and times are decimal strings
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	TraceState        string          `json:"traceState,omitempty"`
+	Name              string          `json:"name"`
+	Kind              Kind            `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            *otlpStatus     `json:"status,omitempty"`
+}
+
+// otlpStatus is the status of a failed span, code 2 being an error
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// attribute converts an attribute to the OTLP JSON encoding
+func attribute(key string, value interface{}) otlpAttribute {
+	var v otlpValue
+	switch value := value.(type) {
+	case bool:
+		v.BoolValue = &value
+	case int:
+		s := strconv.Itoa(value)
+		v.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(value, 10)
+		v.IntValue = &s
+	case float64:
+		v.DoubleValue = &value
+	case string:
+		v.StringValue = &value
+	default:
+		// Integers and floats of the other sizes, then anything else as text
+		switch rv := reflect.ValueOf(value); {
+		case rv.CanInt():
+			s := strconv.FormatInt(rv.Int(), 10)
+			v.IntValue = &s
+		case rv.CanUint() && rv.Uint() <= math.MaxInt64:
+			s := strconv.FormatUint(rv.Uint(), 10)
+			v.IntValue = &s
+		case rv.CanFloat():
+			f := rv.Float()
+			v.DoubleValue = &f
+		default:
+			s := fmt.Sprint(value)
+			v.StringValue = &s
+		}
+	}
+	return otlpAttribute{Key: key, Value: v}
+}
+
+// otlpRequest builds the export request of a batch of spans
+func otlpRequest(serviceName string, spans []SpanData) map[string]interface{} {
+	encoded := make([]otlpSpan, len(spans))
+	for i, span := range spans {
+		s := otlpSpan{
+			TraceID:           hex.EncodeToString(span.Context.TraceID[:]),
+			SpanID:            hex.EncodeToString(span.Context.SpanID[:]),
+			TraceState:        span.Context.TraceState,
+			Name:              span.Name,
+			Kind:              span.Kind,
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+		}
+		if span.Parent != (SpanID{}) {
+			s.ParentSpanID = hex.EncodeToString(span.Parent[:])
+		}
+
+		keys := make([]string, 0, len(span.Attributes))
+		for key := range span.Attributes {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			s.Attributes = append(s.Attributes, attribute(key, span.Attributes[key]))
+		}
+
+		if span.Failed {
+			s.Status = &otlpStatus{Code: 2, Message: span.Error}
+		}
+		encoded[i] = s
+	}
+
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": []otlpAttribute{attribute("service.name", serviceName)},
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": "github.com/igorsilvestre/simple-go-server/pkg/tracing"},
+						"spans": encoded,
+					},
+				},
+			},
+		},
+	}
+}
+
+// batcher queues finished spans and exports them in batches, when a batch is full or every
+// interval, so that requests never wait for the collector
+type batcher struct {
+	exporter Exporter
+	interval time.Duration
+	queue    chan SpanData
+
+	stop    sync.Once
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// newBatcher starts exporting in the background
+func newBatcher(exporter Exporter, interval time.Duration) *batcher {
+	b := &batcher{
+		exporter: exporter,
+		interval: interval,
+		queue:    make(chan SpanData, maxQueueSize),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// add queues a span, or drops it when the queue is full
+func (b *batcher) add(span SpanData) {
+	select {
+	case b.queue <- span:
+	default:
+		log.Printf("Tracing queue is full, dropping span %s", span.Name)
+	}
+}
+
+// run exports the queued spans until shutdown, then exports what is left
+func (b *batcher) run() {
+	defer close(b.stopped)
+
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	batch := make([]SpanData, 0, maxBatchSize)
+	export := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+		defer cancel()
+		if err := b.exporter.Export(ctx, batch); err != nil {
+			log.Printf("Failed to export %d spans: %v", len(batch), err)
+		}
+		batch = make([]SpanData, 0, maxBatchSize)
+	}
+
+	for {
+		select {
+		case span := <-b.queue:
+			batch = append(batch, span)
+			if len(batch) >= maxBatchSize {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case <-b.done:
+			for {
+				select {
+				case span := <-b.queue:
+					batch = append(batch, span)
+					if len(batch) >= maxBatchSize {
+						export()
+					}
+				default:
+					export()
+					return
+				}
+			}
+		}
+	}
+}
+
+// shutdown stops the batcher after the queued spans are exported, or when ctx is done
+func (b *batcher) shutdown(ctx context.Context) error {
+	b.stop.Do(func() { close(b.done) })
+	select {
+	case <-b.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,269 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The types below mirror ExportTraceServiceRequest of opentelemetry-proto
+// (opentelemetry/proto/collector/trace/v1 and trace/v1) in its OTLP/JSON mapping: fields
+// in lowerCamelCase, trace and span IDs in hex, 64-bit integers as decimal strings and
+// enums as numbers. Decoding with unknown fields disallowed rejects any field the schema
+// does not have.
+
+type otlpExportRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	SchemaURL  string           `json:"schemaUrl"`
+}
+
+type otlpResource struct {
+	Attributes             []otlpKeyValue `json:"attributes"`
+	DroppedAttributesCount uint32         `json:"droppedAttributesCount"`
+}
+
+type otlpScopeSpans struct {
+	Scope     otlpScope        `json:"scope"`
+	Spans     []otlpSchemaSpan `json:"spans"`
+	SchemaURL string           `json:"schemaUrl"`
+}
+
+type otlpScope struct {
+	Name                   string         `json:"name"`
+	Version                string         `json:"version"`
+	Attributes             []otlpKeyValue `json:"attributes"`
+	DroppedAttributesCount uint32         `json:"droppedAttributesCount"`
+}
+
+type otlpSchemaSpan struct {
+	TraceID                string            `json:"traceId"`
+	SpanID                 string            `json:"spanId"`
+	TraceState             string            `json:"traceState"`
+	ParentSpanID           string            `json:"parentSpanId"`
+	Flags                  uint32            `json:"flags"`
+	Name                   string            `json:"name"`
+	Kind                   int               `json:"kind"`
+	StartTimeUnixNano      string            `json:"startTimeUnixNano"`
+	EndTimeUnixNano        string            `json:"endTimeUnixNano"`
+	Attributes             []otlpKeyValue    `json:"attributes"`
+	DroppedAttributesCount uint32            `json:"droppedAttributesCount"`
+	Events                 []json.RawMessage `json:"events"`
+	DroppedEventsCount     uint32            `json:"droppedEventsCount"`
+	Links                  []json.RawMessage `json:"links"`
+	DroppedLinksCount      uint32            `json:"droppedLinksCount"`
+	Status                 *otlpSchemaStatus `json:"status"`
+}
+
+type otlpSchemaStatus struct {
+	Message string `json:"message"`
+	Code    int    `json:"code"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string          `json:"stringValue"`
+	BoolValue   *bool            `json:"boolValue"`
+	IntValue    *string          `json:"intValue"`
+	DoubleValue *float64         `json:"doubleValue"`
+	ArrayValue  *json.RawMessage `json:"arrayValue"`
+	KvlistValue *json.RawMessage `json:"kvlistValue"`
+	BytesValue  *string          `json:"bytesValue"`
+}
+
+var (
+	otlpTraceID = regexp.MustCompile(`^[0-9a-f]{32}$`)
+	otlpSpanID  = regexp.MustCompile(`^[0-9a-f]{16}$`)
+)
+
+// decodeOTLP decodes an export request against the schema and checks the values the
+// types cannot express
+func decodeOTLP(t *testing.T, body []byte) otlpExportRequest {
+	t.Helper()
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	var request otlpExportRequest
+	if err := decoder.Decode(&request); err != nil {
+		t.Fatalf("export request does not match the OTLP schema: %v\n%s", err, body)
+	}
+
+	for _, resource := range request.ResourceSpans {
+		checkOTLPAttributes(t, "resource", resource.Resource.Attributes)
+		for _, scope := range resource.ScopeSpans {
+			for _, span := range scope.Spans {
+				checkOTLPSpan(t, span)
+			}
+		}
+	}
+	return request
+}
+
+// checkOTLPSpan checks the IDs, times, kind and status of a span
+func checkOTLPSpan(t *testing.T, span otlpSchemaSpan) {
+	t.Helper()
+	if !otlpTraceID.MatchString(span.TraceID) || span.TraceID == strings.Repeat("0", 32) {
+		t.Errorf("span %q: traceId = %q, want 16 non-zero bytes in hex", span.Name, span.TraceID)
+	}
+	if !otlpSpanID.MatchString(span.SpanID) || span.SpanID == strings.Repeat("0", 16) {
+		t.Errorf("span %q: spanId = %q, want 8 non-zero bytes in hex", span.Name, span.SpanID)
+	}
+	if span.ParentSpanID != "" && !otlpSpanID.MatchString(span.ParentSpanID) {
+		t.Errorf("span %q: parentSpanId = %q, want 8 bytes in hex", span.Name, span.ParentSpanID)
+	}
+	if span.Name == "" {
+		t.Error("span without a name")
+	}
+	// SPAN_KIND_INTERNAL to SPAN_KIND_CONSUMER; UNSPECIFIED is never sent
+	if span.Kind < 1 || span.Kind > 5 {
+		t.Errorf("span %q: kind = %d, want 1 to 5", span.Name, span.Kind)
+	}
+
+	start, err := strconv.ParseUint(span.StartTimeUnixNano, 10, 64)
+	if err != nil {
+		t.Errorf("span %q: startTimeUnixNano = %q, want a fixed64 string", span.Name, span.StartTimeUnixNano)
+	}
+	end, err := strconv.ParseUint(span.EndTimeUnixNano, 10, 64)
+	if err != nil {
+		t.Errorf("span %q: endTimeUnixNano = %q, want a fixed64 string", span.Name, span.EndTimeUnixNano)
+	}
+	if end < start {
+		t.Errorf("span %q ends at %d, before it starts at %d", span.Name, end, start)
+	}
+
+	// STATUS_CODE_UNSET, OK or ERROR
+	if span.Status != nil && (span.Status.Code < 0 || span.Status.Code > 2) {
+		t.Errorf("span %q: status code = %d, want 0 to 2", span.Name, span.Status.Code)
+	}
+	checkOTLPAttributes(t, span.Name, span.Attributes)
+}
+
+// checkOTLPAttributes checks that every value sets exactly one member of the AnyValue oneof
+func checkOTLPAttributes(t *testing.T, owner string, attributes []otlpKeyValue) {
+	t.Helper()
+	for _, attribute := range attributes {
+		if attribute.Key == "" {
+			t.Errorf("%s: attribute without a key", owner)
+		}
+		v := attribute.Value
+		set := 0
+		for _, member := range []bool{v.StringValue != nil, v.BoolValue != nil, v.IntValue != nil, v.DoubleValue != nil,
+			v.ArrayValue != nil, v.KvlistValue != nil, v.BytesValue != nil} {
+			if member {
+				set++
+			}
+		}
+		if set != 1 {
+			t.Errorf("%s: attribute %q sets %d values, want 1", owner, attribute.Key, set)
+		}
+		if v.IntValue != nil {
+			if _, err := strconv.ParseInt(*v.IntValue, 10, 64); err != nil {
+				t.Errorf("%s: attribute %q intValue = %q, want an int64 string", owner, attribute.Key, *v.IntValue)
+			}
+		}
+	}
+}
+
+// testSpans returns a server span continuing a caller's trace, its failed client span and
+// the root span of a new trace
+func testSpans() []SpanData {
+	start := time.Unix(1700000000, 123456789)
+	trace := TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36}
+	server := SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7}
+
+	return []SpanData{
+		{
+			Name:    "GET /external/whois/{domain}",
+			Kind:    KindServer,
+			Context: SpanContext{TraceID: trace, SpanID: server, Sampled: true, TraceState: "vendor=value"},
+			Parent:  SpanID{0x53, 0x99, 0x5c, 0x3f, 0x42, 0xcd, 0x8a, 0xd8},
+			Start:   start,
+			End:     start.Add(250 * time.Millisecond),
+			Attributes: map[string]interface{}{
+				"http.request.method":       "GET",
+				"http.response.status_code": 200,
+				"cache.hit":                 false,
+				"upstream.calls":            int64(2),
+				"cache.ratio":               0.5,
+				"url.port":                  uint16(8080),
+				"upstream.provider":         struct{ Name string }{"whois"},
+			},
+		},
+		{
+			Name:    "upstream whois",
+			Kind:    KindClient,
+			Context: SpanContext{TraceID: trace, SpanID: SpanID{0x7a, 0x08, 0x5e, 0x2c, 0x1b, 0x44, 0x90, 0x01}, Sampled: true},
+			Parent:  server,
+			Start:   start.Add(time.Millisecond),
+			End:     start.Add(200 * time.Millisecond),
+			Error:   "connection refused",
+			Failed:  true,
+		},
+		{
+			Name:    "refresh",
+			Kind:    KindInternal,
+			Context: SpanContext{TraceID: TraceID{15: 1}, SpanID: SpanID{7: 1}, Sampled: true},
+			Start:   start,
+			End:     start,
+		},
+	}
+}
+
+func TestOTLPRequestSchema(t *testing.T) {
+	body, err := json.Marshal(otlpRequest("simple-go-server", testSpans()))
+	if err != nil {
+		t.Fatalf("error encoding spans: %v", err)
+	}
+	request := decodeOTLP(t, body)
+
+	if len(request.ResourceSpans) != 1 || len(request.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("export request = %s, want one resource with one scope", body)
+	}
+	if spans := request.ResourceSpans[0].ScopeSpans[0].Spans; len(spans) != 3 {
+		t.Errorf("exported %d spans, want 3", len(spans))
+	}
+
+	// Every span, attribute type and status lands where the collector expects it
+	golden, err := os.ReadFile("testdata/otlp/export_request.json")
+	if err != nil {
+		t.Fatalf("error reading the expected request: %v", err)
+	}
+	var got, want interface{}
+	json.Unmarshal(body, &got)
+	if err := json.Unmarshal(golden, &want); err != nil {
+		t.Fatalf("error decoding the expected request: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		indented, _ := json.MarshalIndent(got, "", "  ")
+		t.Errorf("export request =\n%s\nwant\n%s", indented, golden)
+	}
+}
+
+func TestOTLPExporterCollectorError(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid traceId", http.StatusBadRequest)
+	}))
+	defer collector.Close()
+
+	exporter := NewOTLPExporter(collector.URL+"/", nil, "simple-go-server")
+	err := exporter.Export(context.Background(), testSpans())
+	if err == nil || !strings.Contains(err.Error(), "collector returned 400: invalid traceId") {
+		t.Errorf("error = %v, want the status and the message of the collector", err)
+	}
+}
@@ -0,0 +1,49 @@
+package tracing
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/igorsilvestre/simple-go-server/internal/httpx"
+	"github.com/igorsilvestre/simple-go-server/pkg/logging"
+)
+
+// Middleware starts a server span for every request of the router, named after the method
+// and the route template. A traceparent sent by the caller makes it part of the caller's
+// trace. The span is the parent of the spans started from the request context.
+func (t *Tracer) Middleware(next http.Handler) http.Handler {
+	if t == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parent, _ := ParseTraceparent(r.Header.Get("traceparent"))
+		if parent.valid() {
+			parent.TraceState = r.Header.Get("tracestate")
+		}
+
+		route := r.URL.Path
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+
+		ctx, span := t.start(r.Context(), r.Method+" "+route, KindServer, parent)
+		defer span.End()
+		span.SetAttribute("http.request.method", r.Method)
+		span.SetAttribute("http.route", route)
+		span.SetAttribute("url.path", r.URL.Path)
+		if id := logging.RequestID(ctx); id != "" {
+			span.SetAttribute("request.id", id)
+		}
+
+		recorder := httpx.NewRecorder(w)
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		status := recorder.Status()
+		span.SetAttribute("http.response.status_code", status)
+		if status >= http.StatusInternalServerError {
+			span.Fail(http.StatusText(status))
+		}
+	})
+}
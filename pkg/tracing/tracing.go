@@ -0,0 +1,286 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/igorsilvestre/simple-go-server/pkg/config"
+)
+
+// TraceID identifies a trace across services
+type TraceID [16]byte
+
+// SpanID identifies a span within a trace
+type SpanID [8]byte
+
+// SpanContext is the part of a span propagated to other services
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+	// TraceState is the vendor data of the tracestate header, passed on untouched
+	TraceState string
+}
+
+// valid reports whether the trace and span IDs are set
+func (sc SpanContext) valid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Traceparent formats the span context as a W3C traceparent header
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", hex.EncodeToString(sc.TraceID[:]), hex.EncodeToString(sc.SpanID[:]), flags)
+}
+
+// ParseTraceparent parses a W3C traceparent header, as sent by the caller of a request
+func ParseTraceparent(header string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	// Future versions may add fields after the flags
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, false
+	}
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.valid()
+}
+
+// Kind tells whether a span serves a request, makes one or is internal work
+type Kind int
+
+// Span kinds, numbered as in OTLP
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+// SpanData is a finished span, as exported
+type SpanData struct {
+	Name       string
+	Kind       Kind
+	Context    SpanContext
+	Parent     SpanID
+	Start, End time.Time
+	Attributes map[string]interface{}
+	// Error is the description of the failure of the span, empty when it succeeded
+	Error  string
+	Failed bool
+}
+
+// Span is an operation being traced. A nil Span, returned when tracing is off, ignores
+// every call.
+type Span struct {
+	tracer *Tracer
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+// SetAttribute records a string, integer, float or boolean attribute of the span
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data.Attributes == nil {
+		s.data.Attributes = make(map[string]interface{})
+	}
+	s.data.Attributes[key] = value
+}
+
+// RecordError marks the span as failed with the error
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.Fail(err.Error())
+}
+
+// Fail marks the span as failed with a description
+func (s *Span) Fail(description string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Failed = true
+	s.data.Error = description
+}
+
+// Context returns the span context to propagate
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.Context
+}
+
+// End finishes the span and hands it to the exporter when it is sampled. Only the first
+// call has an effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = s.tracer.now()
+	data := s.data
+	s.mu.Unlock()
+
+	if data.Context.Sampled {
+		s.tracer.batcher.add(data)
+	}
+}
+
+// Tracer creates spans and exports them in batches. A nil Tracer traces nothing.
+type Tracer struct {
+	serviceName string
+	// threshold is compared to the trace ID to sample a share of the new traces
+	threshold uint64
+	sampleAll bool
+	now       func() time.Time
+
+	batcher *batcher
+}
+
+// New creates a tracer exporting to the configured OTLP collector. It returns nil, which
+// traces nothing, when no endpoint is configured.
+func New(cfg config.TracingConfig) *Tracer {
+	if cfg.Endpoint == "" {
+		return nil
+	}
+	exporter := NewOTLPExporter(cfg.Endpoint, cfg.Headers, cfg.ServiceName)
+	return NewWithExporter(cfg, exporter)
+}
+
+// NewWithExporter creates a tracer exporting to exporter, with the sampling and batching
+// settings of the configuration
+func NewWithExporter(cfg config.TracingConfig, exporter Exporter) *Tracer {
+	t := &Tracer{
+		serviceName: cfg.ServiceName,
+		sampleAll:   cfg.SampleRatio >= 1,
+		now:         time.Now,
+	}
+	if cfg.SampleRatio > 0 && cfg.SampleRatio < 1 {
+		t.threshold = uint64(cfg.SampleRatio * math.MaxUint64)
+	}
+	t.batcher = newBatcher(exporter, cfg.BatchTimeout)
+	return t
+}
+
+// sampled decides whether a new trace is recorded, from the random part of its ID so
+// that the decision does not depend on the tracer
+func (t *Tracer) sampled(id TraceID) bool {
+	if t.sampleAll {
+		return true
+	}
+	return binary.BigEndian.Uint64(id[8:]) < t.threshold
+}
+
+// Shutdown exports the spans still queued, waiting at most until ctx is done
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	return t.batcher.shutdown(ctx)
+}
+
+// start starts a span. A valid parent, local or propagated by the caller, puts it in the
+// parent's trace with the parent's sampling decision; otherwise it starts a new trace.
+func (t *Tracer) start(ctx context.Context, name string, kind Kind, parent SpanContext) (context.Context, *Span) {
+	sc := SpanContext{
+		TraceID:    parent.TraceID,
+		Sampled:    parent.Sampled,
+		TraceState: parent.TraceState,
+	}
+	rand.Read(sc.SpanID[:])
+
+	data := SpanData{Name: name, Kind: kind, Start: t.now()}
+	if parent.valid() {
+		data.Parent = parent.SpanID
+	} else {
+		rand.Read(sc.TraceID[:])
+		sc.Sampled = t.sampled(sc.TraceID)
+		sc.TraceState = ""
+	}
+	data.Context = sc
+
+	span := &Span{tracer: t, data: data}
+	return context.WithValue(ctx, spanContextKey{}, span), span
+}
+
+// spanContextKey carries the current span
+type spanContextKey struct{}
+
+// SpanFromContext returns the current span of ctx, or nil
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanContextKey{}).(*Span)
+	return span
+}
+
+// Start starts a child of the current span of ctx. Without a current span the work is not
+// traced and the returned span is nil, which is safe to use.
+func Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	return parent.tracer.start(ctx, name, kind, parent.Context())
+}
+
+// StartDetached starts the root span of a new trace for background work that outlives the
+// request of ctx. The returned context keeps nothing of ctx but the tracer: neither its
+// values, its cancellation nor its current span. Without a current span the work is not
+// traced and the returned span is nil, which is safe to use.
+func StartDetached(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return context.Background(), nil
+	}
+	return parent.tracer.start(context.Background(), name, kind, SpanContext{})
+}
+
+// Inject sets the traceparent and tracestate headers of an outgoing request to the
+// current span of ctx
+func Inject(ctx context.Context, header http.Header) {
+	span := SpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+	sc := span.Context()
+	header.Set("traceparent", sc.Traceparent())
+	if sc.TraceState != "" {
+		header.Set("tracestate", sc.TraceState)
+	}
+}
@@ -0,0 +1,220 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/igorsilvestre/simple-go-server/pkg/config"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		header  string
+		valid   bool
+		sampled bool
+	}{
+		{header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", valid: true, sampled: true},
+		{header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", valid: true},
+		// Later versions may append fields
+		{header: "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", valid: true, sampled: true},
+		{header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"},
+		{header: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		{header: "00-00000000000000000000000000000000-00f067aa0ba902b7-01"},
+		{header: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01"},
+		{header: "00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01"},
+		{header: "00-4bf92f3577b34da6a3ce929d0e0e47zz-00f067aa0ba902b7-01"},
+		{header: ""},
+	}
+
+	for _, tt := range tests {
+		sc, ok := ParseTraceparent(tt.header)
+		if ok != tt.valid {
+			t.Errorf("ParseTraceparent(%q) valid = %v, want %v", tt.header, ok, tt.valid)
+			continue
+		}
+		if ok && sc.Sampled != tt.sampled {
+			t.Errorf("ParseTraceparent(%q) sampled = %v, want %v", tt.header, sc.Sampled, tt.sampled)
+		}
+		if ok && tt.header[:2] == "00" && sc.Traceparent() != tt.header {
+			t.Errorf("Traceparent() = %q, want %q", sc.Traceparent(), tt.header)
+		}
+	}
+}
+
+// fakeCollector stands in for an OTLP/HTTP collector and keeps the spans it receives
+type fakeCollector struct {
+	mu    sync.Mutex
+	spans []map[string]interface{}
+}
+
+// ServeHTTP decodes an export request
+func (c *fakeCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" || r.Header.Get("Api-Key") != "secret" {
+		http.Error(w, "unexpected export request", http.StatusBadRequest)
+		return
+	}
+	var request struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []map[string]interface{} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, resource := range request.ResourceSpans {
+		for _, scope := range resource.ScopeSpans {
+			c.spans = append(c.spans, scope.Spans...)
+		}
+	}
+	w.Write([]byte("{}"))
+}
+
+// attributes indexes the attributes of an exported span by key
+func attributes(span map[string]interface{}) map[string]interface{} {
+	indexed := make(map[string]interface{})
+	list, _ := span["attributes"].([]interface{})
+	for _, item := range list {
+		attribute := item.(map[string]interface{})
+		for _, value := range attribute["value"].(map[string]interface{}) {
+			indexed[attribute["key"].(string)] = value
+		}
+	}
+	return indexed
+}
+
+func TestMiddlewareExportsSpans(t *testing.T) {
+	collector := &fakeCollector{}
+	collectorServer := httptest.NewServer(collector)
+	defer collectorServer.Close()
+
+	tracer := New(config.TracingConfig{
+		Endpoint:     collectorServer.URL,
+		Headers:      map[string]string{"Api-Key": "secret"},
+		ServiceName:  "test",
+		SampleRatio:  1,
+		BatchTimeout: time.Hour,
+	})
+
+	// The provider receives the trace context of the client span
+	var propagated string
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		propagated = r.Header.Get("traceparent")
+	}))
+	defer provider.Close()
+
+	r := mux.NewRouter()
+	r.HandleFunc("/whois/{domain}", func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := Start(r.Context(), "upstream whois", KindClient)
+		defer span.End()
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, provider.URL, nil)
+		Inject(ctx, req.Header)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("error calling the provider: %v", err)
+			return
+		}
+		resp.Body.Close()
+		http.Error(w, "unavailable", http.StatusBadGateway)
+	})
+	r.Use(tracer.Middleware)
+
+	req := httptest.NewRequest(http.MethodGet, "/whois/example.com", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("error shutting down: %v", err)
+	}
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	if len(collector.spans) != 2 {
+		t.Fatalf("collector received %d spans, want 2", len(collector.spans))
+	}
+	client, server := collector.spans[0], collector.spans[1]
+
+	// The server span continues the trace of the caller
+	if server["name"] != "GET /whois/{domain}" || server["traceId"] != "4bf92f3577b34da6a3ce929d0e0e4736" || server["parentSpanId"] != "00f067aa0ba902b7" {
+		t.Errorf("server span = %v, want a child of the caller's span", server)
+	}
+	if attrs := attributes(server); attrs["http.response.status_code"] != "502" || attrs["http.route"] != "/whois/{domain}" {
+		t.Errorf("server span attributes = %v", attrs)
+	}
+	if status, _ := server["status"].(map[string]interface{}); status["code"] != float64(2) {
+		t.Errorf("server span status = %v, want an error", server["status"])
+	}
+
+	if client["parentSpanId"] != server["spanId"] || client["traceId"] != server["traceId"] || client["kind"] != float64(KindClient) {
+		t.Errorf("client span = %v, want a child of the server span", client)
+	}
+	if want := "00-4bf92f3577b34da6a3ce929d0e0e4736-" + client["spanId"].(string) + "-01"; propagated != want {
+		t.Errorf("traceparent sent to the provider = %q, want %q", propagated, want)
+	}
+}
+
+func TestSampling(t *testing.T) {
+	exported := make(chan []SpanData, 1)
+	tracer := NewWithExporter(config.TracingConfig{SampleRatio: 0, BatchTimeout: time.Hour}, exporterFunc(func(ctx context.Context, spans []SpanData) error {
+		exported <- spans
+		return nil
+	}))
+
+	// New traces are not sampled, but a sampled caller is followed
+	_, dropped := tracer.start(context.Background(), "new", KindServer, SpanContext{})
+	dropped.End()
+	parent, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	_, kept := tracer.start(context.Background(), "continued", KindServer, parent)
+	kept.End()
+
+	tracer.Shutdown(context.Background())
+	spans := <-exported
+	if len(spans) != 1 || spans[0].Name != "continued" {
+		t.Errorf("exported %v, want only the span of the sampled caller", spans)
+	}
+}
+
+func TestStartDetached(t *testing.T) {
+	tracer := NewWithExporter(config.TracingConfig{SampleRatio: 1, BatchTimeout: time.Hour}, exporterFunc(func(ctx context.Context, spans []SpanData) error {
+		return nil
+	}))
+	defer tracer.Shutdown(context.Background())
+
+	type requestKey struct{}
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), requestKey{}, "request"))
+	ctx, request := tracer.start(ctx, "GET /external/geocode", KindServer, SpanContext{})
+	cancel()
+
+	// The background work neither continues the trace nor keeps the request context
+	detached, span := StartDetached(ctx, "cache refresh", KindInternal)
+	if SpanFromContext(detached) != span || span.data.Parent != (SpanID{}) || span.Context().TraceID == request.Context().TraceID {
+		t.Errorf("detached span = %+v, want the root of a new trace", span.data)
+	}
+	if detached.Err() != nil || detached.Value(requestKey{}) != nil {
+		t.Error("detached context kept the cancellation or the values of the request")
+	}
+
+	// Untraced work stays untraced
+	if untraced, span := StartDetached(context.Background(), "cache refresh", KindInternal); span != nil || SpanFromContext(untraced) != nil {
+		t.Errorf("StartDetached() without a span = %v, want no span", span)
+	}
+}
+
+// exporterFunc adapts a function to the Exporter interface
+type exporterFunc func(ctx context.Context, spans []SpanData) error
+
+// Export calls the function
+func (f exporterFunc) Export(ctx context.Context, spans []SpanData) error {
+	return f(ctx, spans)
+}